@@ -12,17 +12,56 @@
 package ae
 
 import (
+	"bytes"
+	"errors"
 	"io"
 
 	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
 )
 
 // TODO: add links to diagrams when they are finalised and added to the repository.
 
-// Chunksize is the artificial chunksize used for the ChunkStream to write small
+// DefaultChunksize is the artificial chunksize used for the ChunkStream to write small
 // encrypted files of exactly 2 kB. This is also a good value to reduce the losses
 // through padding and overhead to < 1% on files larger than 1 MB.
-const Chunksize = 1984 // big brother is watching you
+const DefaultChunksize = 1984 // big brother is watching you
+
+// Chunksize is the chunksize of new files. Other values than DefaultChunksize are
+// recorded in the header, so readers always use the chunksize of the file.
+var Chunksize = DefaultChunksize
+
+// Framed selects the framed chunks of chunkstream.NewFramedWriter for new files. Writers
+// then implement chunkstream.FlushWriteCloser, so written data can be sealed and sent
+// immediately with Flush, e.g. when encrypting a log file that is still growing. Framing
+// is recorded in the header and readers follow it, but framed files cannot be opened
+// with NewReaderAt.
+var Framed = false
+
+// ErrFramed is returned when framed files are opened for random access.
+var ErrFramed = errors.New("framed files cannot be opened for random access, the offsets of their chunks are unknown")
+
+// newChunkWriter returns a fixed or framed chunk writer with the parameters in the
+// header. The key is derived from a random ephemeral key for every file, so no stream
+// ID is needed.
+func newChunkWriter(w io.Writer, key, head []byte) (io.WriteCloser, error) {
+	p := parseParams(head)
+	if p.framed {
+		return chunkstream.NewFramedWriter(w, key, head, p.size(), chunkOptions(head, nil)...)
+	}
+	return chunkstream.NewWriter(w, key, head, p.size(), chunkOptions(head, nil)...)
+}
+
+// newChunkReader returns a reader for fixed or framed chunks with the parameters in the
+// header, which are repaired if the file has parity and report is not nil
+func newChunkReader(r io.Reader, key, head []byte, report func(index uint64)) (io.Reader, error) {
+	p := parseParams(head)
+	if p.framed {
+		return chunkstream.NewFramedReader(r, key, head, p.size(), chunkOptions(head, report)...)
+	}
+	return chunkstream.NewReader(r, key, head, p.size(), chunkOptions(head, report)...)
+}
 
 // NewWriter derives an ephemeral shared key with the given Curve25519 public key,
 // writes a header to the provided Writer and then returns a ChunkWriter, which will encrypt
@@ -34,12 +73,17 @@ const Chunksize = 1984 // big brother is watching you
 func NewWriter(w io.Writer, public *[32]byte) (cw io.WriteCloser, err error) {
 
 	// write new header and derive key
+	prefix, err := newParams().write(w)
+	if err != nil {
+		return
+	}
 	key, head, err := writeNewHeader(w, public)
 	if err != nil {
 		return
 	}
+	defer securebuf.Wipe(key)
 
-	return chunkstream.NewWriter(w, key, head, Chunksize)
+	return newChunkWriter(w, key, append(prefix, head...))
 
 }
 
@@ -50,14 +94,233 @@ func NewWriter(w io.Writer, public *[32]byte) (cw io.WriteCloser, err error) {
 // Please note that opening a valid header will succeed even if you provide the wrong private
 // key as the header itself is not MAC'ed. It is however used as associated data in the chunks, so
 // decryption will fail upon the first call to .Read().
+//
+// The returned Reader implements chunkstream.Destroyer to wipe the derived key early.
 func NewReader(r io.Reader, private *[32]byte) (cr io.Reader, err error) {
 
 	// open header and derive key
-	key, head, err := openHeader(r, private)
+	key, head, err := openHeader(r, private, nil)
+	if err != nil {
+		return
+	}
+	defer securebuf.Wipe(key)
+
+	return newChunkReader(r, key, head, Repair)
+
+}
+
+// NewReaderAt opens a complete file of the given size for random access with your private
+// key. Since all chunks have the same size, any offset can be read by decrypting only the
+// chunks it overlaps. The final chunk is authenticated immediately, so wrong keys and
+// truncated files are detected here. Framed files cannot be opened this way.
+//
+// The returned ReaderAt implements chunkstream.Destroyer to wipe the derived key early.
+func NewReaderAt(r io.ReaderAt, size int64, private *[32]byte) (*chunkstream.ReaderAt, error) {
+	return NewHybridReaderAt(r, size, private, nil)
+}
+
+// NewHybridReaderAt works like NewReaderAt but takes the seed of the ML-KEM-768 decapsulation
+// key to open files for hybrid recipients, like NewHybridReader.
+func NewHybridReaderAt(r io.ReaderAt, size int64, private *[32]byte, kemseed []byte) (*chunkstream.ReaderAt, error) {
+
+	// read the header and count its length
+	header := &countReader{r: io.NewSectionReader(r, 0, size)}
+	key, head, err := openHeader(header, private, kemseed)
+	if err != nil {
+		return nil, err
+	}
+	defer securebuf.Wipe(key)
+	p := parseParams(head)
+	if p.framed {
+		return nil, ErrFramed
+	}
+
+	return chunkstream.NewReaderAt(io.NewSectionReader(r, header.n, size-header.n), size-header.n, key, head, p.size(), chunkOptions(head, nil)...)
+
+}
+
+// NewReaderAny works like NewReader but tries several private keys, e.g. a master key and
+// its derived subkeys. Since the header is not authenticated, the first chunk is decrypted
+// with each of the keys in turn and the first one that succeeds is used for the rest.
+func NewReaderAny(r io.Reader, private ...*[32]byte) (cr io.Reader, err error) {
+
+	// read the header once
+	info, head, err := readHeader(r)
+	if err != nil {
+		return
+	}
+
+	// derive all candidate keys, skipping those without a stanza in a multi header
+	keys := make([][]byte, 0, len(private))
+	for _, p := range private {
+		key, err := info.deriveKey(p, nil)
+		if err != nil {
+			if info.magic == MultiMagic {
+				continue
+			}
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return trialReader(r, head, keys)
+
+}
+
+// SharedFunc computes the Curve25519 shared secret of a private key, which is held
+// elsewhere, e.g. in a key agent, with the ephemeral public key of a file header.
+type SharedFunc func(ephemeral *[32]byte) (shared *[32]byte, err error)
+
+// NewReaderShared works like NewReaderAny but never sees the private keys themselves.
+// Instead, the shared secrets with the ephemeral key are computed by the given functions.
+// Files for hybrid recipients cannot be opened this way.
+func NewReaderShared(r io.Reader, shared ...SharedFunc) (cr io.Reader, err error) {
+
+	// read the header once
+	info, head, err := readHeader(r)
+	if err != nil {
+		return
+	}
+	if info.magic == HybridMagic {
+		return nil, errors.New("file is encrypted for a hybrid key")
+	}
+
+	// derive all candidate keys
+	keys := make([][]byte, 0, len(shared))
+	for _, fn := range shared {
+		if info.magic == MultiMagic {
+			if key, err := info.multiKey(fn); err == nil {
+				keys = append(keys, key)
+			}
+			continue
+		}
+		secret, err := fn(info.ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, keyderivation.HKDF(secret[:], info.salt, Keyinfo))
+		securebuf.Wipe(secret[:])
+	}
+
+	return trialReader(r, head, keys)
+
+}
+
+// trialReader decrypts the first chunk with each of the keys in turn and returns a
+// ChunkReader with the first key that succeeds.
+func trialReader(r io.Reader, head []byte, keys [][]byte) (cr io.Reader, err error) {
+
+	if len(keys) == 0 {
+		return nil, errors.New("no keys given")
+	}
+	defer func() {
+		for _, key := range keys {
+			securebuf.Wipe(key)
+		}
+	}()
+
+	// buffer the first chunk or frame for trial decryption
+	aead, err := chunkstream.NewAEAD(keys[0])
+	if err != nil {
+		return
+	}
+	first := make([]byte, firstGroup(head, aead.Overhead()))
+	n, err := io.ReadFull(r, first)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return
+	}
+	first = first[:n]
+
+	// damaged chunks are repaired on trial but only reported once
+	report := Repair
+	if report != nil {
+		report = func(uint64) {}
+	}
+	// a shorter frame may be followed by a part of the next one, which is only read if
+	// the first frame is empty, and then the buffer ends after the first chunk did open
+	for _, key := range keys {
+		trial, err := newChunkReader(bytes.NewReader(first), key, head, report)
+		if err != nil {
+			return nil, err
+		}
+		_, err = trial.Read(make([]byte, 1))
+		trial.(chunkstream.Destroyer).Destroy()
+		if err == nil || err == io.EOF || err == chunkstream.ErrTruncated {
+			return newChunkReader(io.MultiReader(bytes.NewReader(first), r), key, head, Repair)
+		}
+	}
+
+	return nil, errors.New("none of the keys can open this file")
+
+}
+
+// NewHybridWriter works like NewWriter but encrypts for a hybrid recipient. In addition to
+// the Curve25519 public key, an ML-KEM-768 encapsulation key is required and a second shared
+// secret is encapsulated in the header. Both secrets are used to derive the key, so the file
+// remains confidential even if one of the two algorithms is broken.
+func NewHybridWriter(w io.Writer, public *[32]byte, kempub []byte) (cw io.WriteCloser, err error) {
+
+	// write new hybrid header and derive key
+	prefix, err := newParams().write(w)
+	if err != nil {
+		return
+	}
+	key, head, err := writeNewHybridHeader(w, public, kempub)
+	if err != nil {
+		return
+	}
+	defer securebuf.Wipe(key)
+
+	return newChunkWriter(w, key, append(prefix, head...))
+
+}
+
+// NewHybridReader works like NewReader but additionally takes the seed of the ML-KEM-768
+// decapsulation key to open files encrypted for a hybrid recipient. Classic files that
+// were encrypted for the Curve25519 part of the hybrid key alone are opened as well.
+func NewHybridReader(r io.Reader, private *[32]byte, kemseed []byte) (cr io.Reader, err error) {
+
+	// open either header variant and derive key
+	key, head, err := openHeader(r, private, kemseed)
+	if err != nil {
+		return
+	}
+	defer securebuf.Wipe(key)
+
+	return newChunkReader(r, key, head, Repair)
+
+}
+
+// Salvage decrypts a damaged file with your private key and writes the plaintext of all
+// chunks that authenticate, see chunkstream.Salvage. The kemseed may be nil unless the
+// file is encrypted for a hybrid key. The header must be intact to derive the key. Framed
+// files are salvaged with chunkstream.SalvageFramed and if Repair is set, files with
+// parity are repaired first.
+func Salvage(w io.Writer, r io.Reader, private *[32]byte, kemseed []byte, zerofill bool) (lost []chunkstream.Range, err error) {
+
+	// open header and derive key
+	key, head, err := openHeader(r, private, kemseed)
 	if err != nil {
 		return
 	}
+	defer securebuf.Wipe(key)
 
-	return chunkstream.NewReader(r, key, head, Chunksize)
+	p := parseParams(head)
+	if p.framed {
+		return chunkstream.SalvageFramed(w, r, key, head, p.size(), zerofill, chunkOptions(head, nil)...)
+	}
+	return chunkstream.Salvage(w, r, key, head, p.size(), zerofill, chunkOptions(head, Repair)...)
+
+}
+
+// countReader counts the bytes read
+type countReader struct {
+	r io.Reader
+	n int64
+}
 
+func (cr *countReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.n += int64(n)
+	return
 }
@@ -0,0 +1,69 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package age reads and writes files in the age v1 format [0] with X25519 recipients,
+// so that files can be exchanged with users of age [1] and its compatible implementations.
+// Aenker keys are plain Curve25519 keys and can be used with either format. Use the
+// Encode* and Parse* functions to convert them to and from age's bech32 encoding.
+//
+//  [0]: https://age-encryption.org/v1
+//  [1]: https://github.com/FiloSottile/age
+package age
+
+import (
+	"bufio"
+	"crypto/rand"
+	"io"
+)
+
+// NewWriter generates a new file key, wraps it for the given Curve25519 public key,
+// writes an age header to the provided Writer and then returns a WriteCloser, which will
+// encrypt any written data.
+//
+// Don't forget to call .Close() when you're done, otherwise the final chunk will never be
+// written. This does NOT close the writer that was originally passed.
+func NewWriter(w io.Writer, public *[32]byte) (wc io.WriteCloser, err error) {
+
+	// write header with a single X25519 recipient
+	fileKey, err := writeHeader(w, public)
+	if err != nil {
+		return
+	}
+
+	// payload key is derived from the file key and a random nonce
+	nonce := make([]byte, 16)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	if _, err = w.Write(nonce); err != nil {
+		return
+	}
+
+	return newStreamWriter(w, hkdfSHA256(fileKey, nonce, "payload"))
+
+}
+
+// NewReader parses and authenticates the age header from the given Reader with your
+// Curve25519 private key and then returns a Reader, which will transparently decrypt
+// the payload upon calling .Read().
+//
+// Unlike ae.NewReader, the header is authenticated, so a wrong private key or a
+// manipulated header returns an error immediately.
+func NewReader(r io.Reader, private *[32]byte) (rd io.Reader, err error) {
+
+	// header lines and payload are read through the same buffer
+	br := bufio.NewReader(r)
+
+	fileKey, err := readHeader(br, private)
+	if err != nil {
+		return
+	}
+
+	nonce := make([]byte, 16)
+	if _, err = io.ReadFull(br, nonce); err != nil {
+		return
+	}
+
+	return newStreamReader(br, hkdfSHA256(fileKey, nonce, "payload"))
+
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package age
+
+import (
+	"bytes"
+	"crypto/rand"
+	b64 "encoding/base64"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+func TestRoundtrip(t *testing.T) {
+
+	private := new([32]byte)
+	rand.Read(private[:])
+	public := keyderivation.Public(private)
+
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize} {
+
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(w, bytes.NewReader(plain))
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), private)
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		decrypted, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if !bytes.Equal(plain, decrypted) {
+			t.Errorf("size %d: decrypted plaintext differs", size)
+		}
+
+		// truncating the last byte must fail
+		r, err = NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), private)
+		if err == nil {
+			if _, err = ioutil.ReadAll(r); err == nil {
+				t.Errorf("size %d: truncated ciphertext did not fail", size)
+			}
+		}
+
+	}
+
+}
+
+func TestKeys(t *testing.T) {
+
+	// valid strings from BIP 173
+	for _, s := range []string{
+		"A12UEL5L",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	} {
+		if _, _, err := decodeBech32(s); err != nil {
+			t.Errorf("%q: %s", s, err)
+		}
+	}
+
+	key := new([32]byte)
+	rand.Read(key[:])
+
+	identity := EncodeIdentity(key)
+	if !IsIdentity(identity) {
+		t.Errorf("not recognized as identity: %s", identity)
+	}
+	if k, err := ParseIdentity(identity); err != nil || *k != *key {
+		t.Errorf("identity did not roundtrip: %s", err)
+	}
+
+	recipient := EncodeRecipient(key)
+	if !IsRecipient(recipient) {
+		t.Errorf("not recognized as recipient: %s", recipient)
+	}
+	if _, err := ParseIdentity(recipient); err == nil {
+		t.Errorf("recipient parsed as identity")
+	}
+
+}
+
+// known answers generated with filippo.io/age v1.2.1
+const (
+	kaIdentity  = "AGE-SECRET-KEY-1MJJUPF2Y64RZFZ2K5DZZQ98JPCLSCPRAWKKKVZKRPT8ZPY5LJTVSGH80N6"
+	kaRecipient = "age1szxplf5w3k2rfn3xj50he2mlrkrfntqtwc0m2jl9ky6muak03asq3vju9z"
+
+	// encrypted for another recipient first and then for kaRecipient
+	kaSmall = "YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBNNmppRXQ4OHNtTnBMM3diRFlVQUM1SFpYSnovRXhOTGdTTGZHZmt5cEFRCnZPTzQ0WWJTRGZiZnNaakhZQ1RDcEZNQlZMb2QxYmxvUEtPazVpMGd1b0kKLT4gWDI1NTE5IHlTWjMxV2YxdzRTUjd3M3hlY1B2ZDlZalNXRUErTUpjTnVjMXNneGU3VGMKa01BTm5RT3Q1SGtmK2RDTS9KVkZ0QUp1ajh4WlAyb2o1QU5yR1N4eTZhRQotLS0ga2xObm5vZUlRL1hJeUNjVkcrTWFCeUcra1hvTUN6bDhGbFQ2OERndHZoSQo9Xhs3JytkS9CFhZht1G1FWYOqr7QdG+uXfQmkFWRrCi5a8S5Ucpa6e++Qhg/wlkwpH8FdVLel/vQ="
+)
+
+func TestKnownAnswer(t *testing.T) {
+
+	private, err := ParseIdentity(kaIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := EncodeRecipient(keyderivation.Public(private)); r != kaRecipient {
+		t.Errorf("wrong recipient: %s", r)
+	}
+	if s := EncodeIdentity(private); s != kaIdentity {
+		t.Errorf("wrong identity: %s", s)
+	}
+
+	small, _ := b64.StdEncoding.DecodeString(kaSmall)
+	r, err := NewReader(bytes.NewReader(small), private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadAll(r)
+	if err != nil || string(plain) != "aenker known answer test\n" {
+		t.Errorf("small file: %q, %v", plain, err)
+	}
+
+	// two chunks of the bytes i % 251
+	chunks, err := ioutil.ReadFile("testdata/chunks.age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = NewReader(bytes.NewReader(chunks), private); err != nil {
+		t.Fatal(err)
+	}
+	if plain, err = ioutil.ReadAll(r); err != nil || len(plain) != 70000 {
+		t.Fatalf("two chunks: %d bytes, %v", len(plain), err)
+	}
+	for i, b := range plain {
+		if b != byte(i%251) {
+			t.Fatalf("two chunks: wrong byte at %d", i)
+		}
+	}
+
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package age
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32 as specified in BIP 173 [0], which age uses to encode its keys. Unlike
+// the BIP, the total length of a string is not limited to 90 characters because
+// age secret keys may be longer than that.
+//
+//  [0]: https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	h := []byte(strings.ToLower(hrp))
+	var ret []byte
+	for _, c := range h {
+		ret = append(ret, c>>5)
+	}
+	ret = append(ret, 0)
+	for _, c := range h {
+		ret = append(ret, c&31)
+	}
+	return ret
+}
+
+func verifyChecksum(hrp string, data []byte) bool {
+	return polymod(append(hrpExpand(hrp), data...)) == 1
+}
+
+func createChecksum(hrp string, data []byte) []byte {
+	values := append(hrpExpand(hrp), data...)
+	values = append(values, []byte{0, 0, 0, 0, 0, 0}...)
+	mod := polymod(values) ^ 1
+	ret := make([]byte, 6)
+	for p := 0; p < 6; p++ {
+		ret[p] = byte(mod>>uint(5*(5-p))) & 31
+	}
+	return ret
+}
+
+// convertBits regroups a slice of frombits-sized values to tobits-sized values.
+func convertBits(data []byte, frombits, tobits byte, pad bool) ([]byte, error) {
+	var ret []byte
+	acc := uint32(0)
+	bits := byte(0)
+	maxv := byte(1<<tobits - 1)
+	for _, value := range data {
+		if value>>frombits != 0 {
+			return nil, errors.New("bech32: invalid data range")
+		}
+		acc = acc<<frombits | uint32(value)
+		bits += frombits
+		for bits >= tobits {
+			bits -= tobits
+			ret = append(ret, byte(acc>>bits)&maxv)
+		}
+	}
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(tobits-bits))&maxv)
+		}
+	} else if bits >= frombits {
+		return nil, errors.New("bech32: illegal zero padding")
+	} else if byte(acc<<(tobits-bits))&maxv != 0 {
+		return nil, errors.New("bech32: non-zero padding")
+	}
+	return ret, nil
+}
+
+// encodeBech32 encodes data with a human-readable part. The case of hrp
+// determines the case of the entire result.
+func encodeBech32(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	var ret strings.Builder
+	ret.WriteString(strings.ToLower(hrp))
+	ret.WriteByte('1')
+	for _, p := range append(values, createChecksum(hrp, values)...) {
+		ret.WriteByte(charset[p])
+	}
+	if strings.ToUpper(hrp) == hrp {
+		return strings.ToUpper(ret.String()), nil
+	}
+	return ret.String(), nil
+}
+
+// decodeBech32 decodes a bech32 string and returns its lowercased human-readable
+// part and the decoded data.
+func decodeBech32(s string) (hrp string, data []byte, err error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("bech32: mixed case")
+	}
+	pos := strings.LastIndex(s, "1")
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("bech32: separator '1' at invalid position")
+	}
+	hrp = strings.ToLower(s[:pos])
+	for _, c := range hrp {
+		if c < 33 || c > 126 {
+			return "", nil, fmt.Errorf("bech32: invalid character in human-readable part: %q", c)
+		}
+	}
+	s = strings.ToLower(s)
+	values := make([]byte, 0, len(s)-pos-1)
+	for _, c := range s[pos+1:] {
+		d := strings.IndexRune(charset, c)
+		if d == -1 {
+			return "", nil, fmt.Errorf("bech32: invalid character in data part: %q", c)
+		}
+		values = append(values, byte(d))
+	}
+	if !verifyChecksum(hrp, values) {
+		return "", nil, errors.New("bech32: invalid checksum")
+	}
+	data, err = convertBits(values[:len(values)-6], 5, 8, false)
+	return hrp, data, err
+}
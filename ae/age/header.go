@@ -0,0 +1,240 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Version is the first line of every age v1 file.
+const Version = "age-encryption.org/v1"
+
+// x25519Label is used as HKDF info to derive the key that wraps the file key.
+const x25519Label = "age-encryption.org/v1/X25519"
+
+// stanza bodies are wrapped at this many base64 characters per line
+const columns = 64
+
+var base64 = b64.RawStdEncoding.Strict()
+
+// stanza is a recipient block in the age header.
+type stanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// marshal writes the stanza in its textual form to a buffer.
+func (s *stanza) marshal(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "-> %s", s.Type)
+	for _, a := range s.Args {
+		fmt.Fprintf(buf, " %s", a)
+	}
+	buf.WriteByte('\n')
+	body := base64.EncodeToString(s.Body)
+	for len(body) >= columns {
+		buf.WriteString(body[:columns] + "\n")
+		body = body[columns:]
+	}
+	// the final line is always shorter than a full column, possibly empty
+	buf.WriteString(body + "\n")
+}
+
+// hkdfSHA256 derives a 32 byte key with HKDF-SHA-256 like age does.
+func hkdfSHA256(secret, salt []byte, info string) (key []byte) {
+	key = make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// headerMAC computes the HMAC over the header up to and including the "---".
+func headerMAC(fileKey, header []byte) []byte {
+	h := hmac.New(sha256.New, hkdfSHA256(fileKey, nil, "header"))
+	h.Write(header)
+	return h.Sum(nil)
+}
+
+// wrapX25519 creates a new X25519 recipient stanza, which wraps the file key
+// for the given public key.
+func wrapX25519(fileKey []byte, peer *[32]byte) (s *stanza, err error) {
+
+	ephemeral := new([32]byte)
+	if _, err = io.ReadFull(rand.Reader, ephemeral[:]); err != nil {
+		return
+	}
+	share := new([32]byte)
+	curve25519.ScalarBaseMult(share, ephemeral)
+
+	shared := new([32]byte)
+	curve25519.ScalarMult(shared, ephemeral, peer)
+
+	salt := append(share[:], peer[:]...)
+	aead, err := chacha20poly1305.New(hkdfSHA256(shared[:], salt, x25519Label))
+	if err != nil {
+		return
+	}
+
+	return &stanza{
+		Type: "X25519",
+		Args: []string{base64.EncodeToString(share[:])},
+		Body: aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil),
+	}, nil
+
+}
+
+// unwrapX25519 tries to recover the file key from an X25519 stanza.
+func unwrapX25519(s *stanza, private *[32]byte) (fileKey []byte, err error) {
+
+	if len(s.Args) != 1 {
+		return nil, errors.New("age: invalid X25519 recipient stanza")
+	}
+	share, err := base64.DecodeString(s.Args[0])
+	if err != nil || len(share) != 32 {
+		return nil, errors.New("age: invalid X25519 ephemeral share")
+	}
+
+	public := new([32]byte)
+	curve25519.ScalarBaseMult(public, private)
+
+	ephemeral := new([32]byte)
+	copy(ephemeral[:], share)
+	shared := new([32]byte)
+	curve25519.ScalarMult(shared, private, ephemeral)
+	if *shared == [32]byte{} {
+		return nil, errors.New("age: low order X25519 point")
+	}
+
+	salt := append(share, public[:]...)
+	aead, err := chacha20poly1305.New(hkdfSHA256(shared[:], salt, x25519Label))
+	if err != nil {
+		return
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), s.Body, nil)
+
+}
+
+// writeHeader generates a new file key, wraps it for the peer and writes
+// the complete and authenticated header to the writer.
+func writeHeader(w io.Writer, peer *[32]byte) (fileKey []byte, err error) {
+
+	fileKey = make([]byte, 16)
+	if _, err = io.ReadFull(rand.Reader, fileKey); err != nil {
+		return
+	}
+
+	s, err := wrapX25519(fileKey, peer)
+	if err != nil {
+		return
+	}
+
+	buf := bytes.NewBufferString(Version + "\n")
+	s.marshal(buf)
+	buf.WriteString("---")
+	mac := headerMAC(fileKey, buf.Bytes())
+	buf.WriteString(" " + base64.EncodeToString(mac) + "\n")
+
+	_, err = w.Write(buf.Bytes())
+	return
+
+}
+
+// readHeader parses the header from a buffered reader, tries to unwrap the file
+// key from any X25519 stanza and verifies the header MAC.
+func readHeader(r *bufio.Reader, private *[32]byte) (fileKey []byte, err error) {
+
+	// the raw header up to the mac is kept for verification
+	raw := new(bytes.Buffer)
+	line := func() (string, error) {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", fmt.Errorf("age: failed to read header: %s", err)
+		}
+		raw.WriteString(l)
+		return strings.TrimSuffix(l, "\n"), nil
+	}
+
+	l, err := line()
+	if err != nil {
+		return
+	}
+	if l != Version {
+		return nil, errors.New("age: unknown format version")
+	}
+
+	var stanzas []*stanza
+	var mac []byte
+	for {
+		if l, err = line(); err != nil {
+			return
+		}
+
+		// footer with the header mac
+		if strings.HasPrefix(l, "--- ") {
+			if mac, err = base64.DecodeString(l[4:]); err != nil {
+				return nil, errors.New("age: malformed header mac")
+			}
+			// the mac covers everything up to and including "---"
+			raw.Truncate(raw.Len() - len(l) - 1 + 3)
+			break
+		}
+
+		// everything else must be a recipient stanza
+		if !strings.HasPrefix(l, "-> ") {
+			return nil, errors.New("age: malformed recipient stanza")
+		}
+		args := strings.Split(l[3:], " ")
+		s := &stanza{Type: args[0], Args: args[1:]}
+		for {
+			if l, err = line(); err != nil {
+				return
+			}
+			b, e := base64.DecodeString(l)
+			if e != nil || len(l) > columns {
+				return nil, errors.New("age: malformed stanza body")
+			}
+			s.Body = append(s.Body, b...)
+			if len(l) < columns {
+				break
+			}
+		}
+		stanzas = append(stanzas, s)
+	}
+
+	// try all X25519 stanzas, ignoring any others
+	for _, s := range stanzas {
+		if s.Type != "X25519" {
+			continue
+		}
+		if fileKey, err = unwrapX25519(s, private); err == nil {
+			break
+		}
+	}
+	if fileKey == nil {
+		return nil, errors.New("age: no identity matched any of the recipients")
+	}
+
+	if !hmac.Equal(mac, headerMAC(fileKey, raw.Bytes())) {
+		return nil, errors.New("age: bad header mac")
+	}
+	return
+
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package age
+
+import (
+	"errors"
+	"strings"
+)
+
+// Human-readable parts of the bech32 encoded age keys.
+const (
+	RecipientPrefix = "age"
+	IdentityPrefix  = "AGE-SECRET-KEY-"
+)
+
+// EncodeRecipient encodes a Curve25519 public key as an age recipient, i.e. "age1...".
+func EncodeRecipient(public *[32]byte) string {
+	s, err := encodeBech32(RecipientPrefix, public[:])
+	if err != nil {
+		// 32 bytes of input always fit
+		panic(err)
+	}
+	return s
+}
+
+// EncodeIdentity encodes a Curve25519 private key as an age identity, i.e. "AGE-SECRET-KEY-1...".
+func EncodeIdentity(private *[32]byte) string {
+	s, err := encodeBech32(IdentityPrefix, private[:])
+	if err != nil {
+		// 32 bytes of input always fit
+		panic(err)
+	}
+	return s
+}
+
+// ParseRecipient decodes an "age1..." recipient string to a Curve25519 public key.
+func ParseRecipient(s string) (*[32]byte, error) {
+	return parseKey(s, RecipientPrefix)
+}
+
+// ParseIdentity decodes an "AGE-SECRET-KEY-1..." identity string to a Curve25519 private key.
+func ParseIdentity(s string) (*[32]byte, error) {
+	return parseKey(s, IdentityPrefix)
+}
+
+// encoded length of 32 bytes of data plus checksum after the separator
+const keylen = 1 + 52 + 6
+
+// IsRecipient reports whether the string looks like an age recipient.
+func IsRecipient(s string) bool {
+	return len(s) == len(RecipientPrefix)+keylen && strings.HasPrefix(s, RecipientPrefix+"1")
+}
+
+// IsIdentity reports whether the string looks like an age identity.
+func IsIdentity(s string) bool {
+	return len(s) == len(IdentityPrefix)+keylen && strings.HasPrefix(s, IdentityPrefix+"1")
+}
+
+// decode a bech32 string with the expected prefix to a 32 byte key
+func parseKey(s, prefix string) (key *[32]byte, err error) {
+
+	hrp, data, err := decodeBech32(s)
+	if err != nil {
+		return
+	}
+
+	if hrp != strings.ToLower(prefix) {
+		return nil, errors.New("age: unexpected key type: " + hrp)
+	}
+	if len(data) != 32 {
+		return nil, errors.New("age: key must be 32 bytes")
+	}
+
+	key = new([32]byte)
+	copy(key[:], data)
+	return
+
+}
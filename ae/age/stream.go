@@ -0,0 +1,177 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package age
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ChunkSize is the fixed plaintext size of all but the last chunk of the payload.
+const ChunkSize = 64 * 1024
+
+// the STREAM nonce is an 11 byte big-endian counter and a final flag
+type streamNonce [chacha20poly1305.NonceSize]byte
+
+func (n *streamNonce) next() (err error) {
+	for i := len(n) - 2; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			return
+		}
+	}
+	return errors.New("age: stream nonce counter wrapped")
+}
+
+func (n *streamNonce) setFinal() {
+	n[len(n)-1] = 0x01
+}
+
+type streamWriter struct {
+	aead  cipher.AEAD
+	nonce streamNonce
+	buf   []byte
+	w     io.Writer
+	err   error
+}
+
+func newStreamWriter(w io.Writer, key []byte) (*streamWriter, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &streamWriter{aead: aead, w: w,
+		buf: make([]byte, 0, ChunkSize+aead.Overhead())}, nil
+}
+
+func (sw *streamWriter) Write(data []byte) (n int, err error) {
+
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	defer func() {
+		if err != nil {
+			sw.err = err
+		}
+	}()
+
+	for len(data) > 0 {
+
+		// only seal a full chunk when more data follows, because
+		// the last chunk must be sealed with the final flag
+		if len(sw.buf) == ChunkSize {
+			if err = sw.seal(false); err != nil {
+				return
+			}
+		}
+
+		nb := copy(sw.buf[len(sw.buf):ChunkSize], data)
+		sw.buf = sw.buf[:len(sw.buf)+nb]
+		data = data[nb:]
+		n += nb
+
+	}
+	return
+
+}
+
+func (sw *streamWriter) seal(final bool) (err error) {
+	if final {
+		sw.nonce.setFinal()
+	}
+	ct := sw.aead.Seal(sw.buf[:0], sw.nonce[:], sw.buf, nil)
+	if _, err = sw.w.Write(ct); err != nil {
+		return
+	}
+	sw.buf = sw.buf[:0]
+	return sw.nonce.next()
+}
+
+func (sw *streamWriter) Close() (err error) {
+	if sw.err != nil {
+		return sw.err
+	}
+	sw.err = errors.New("age: write on closed stream")
+	return sw.seal(true)
+}
+
+type streamReader struct {
+	aead  cipher.AEAD
+	nonce streamNonce
+	buf   []byte
+	plain []byte
+	r     *bufio.Reader
+	err   error
+	first bool
+}
+
+func newStreamReader(r *bufio.Reader, key []byte) (*streamReader, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{aead: aead, r: r, first: true,
+		buf: make([]byte, ChunkSize+aead.Overhead())}, nil
+}
+
+func (sr *streamReader) Read(p []byte) (n int, err error) {
+
+	if len(sr.plain) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.err = sr.open(); len(sr.plain) == 0 && sr.err != nil {
+			return 0, sr.err
+		}
+	}
+
+	n = copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return
+
+}
+
+// open reads, authenticates and decrypts the next chunk. It returns io.EOF
+// after the final chunk has been opened successfully.
+func (sr *streamReader) open() (err error) {
+
+	n, err := io.ReadFull(sr.r, sr.buf)
+	if err == io.EOF || (err == nil || err == io.ErrUnexpectedEOF) && n < sr.aead.Overhead() {
+		return errors.New("age: truncated ciphertext")
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return
+	}
+
+	// a short chunk or a full chunk at the end of input is the final one
+	final := err == io.ErrUnexpectedEOF
+	if !final {
+		if _, e := sr.r.Peek(1); e == io.EOF {
+			final = true
+		}
+	}
+	if final {
+		sr.nonce.setFinal()
+	}
+
+	sr.plain, err = sr.aead.Open(sr.buf[:0], sr.nonce[:], sr.buf[:n], nil)
+	if err != nil {
+		return errors.New("age: failed to authenticate chunk")
+	}
+	// an empty final chunk is only allowed for an empty payload
+	if final && len(sr.plain) == 0 && !sr.first {
+		return errors.New("age: last chunk is empty")
+	}
+	sr.first = false
+
+	if final {
+		return io.EOF
+	}
+	return sr.nonce.next()
+
+}
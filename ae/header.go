@@ -11,6 +11,7 @@ import (
 	"io"
 
 	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
 )
 
 // Header is the struct that is serialized at the beginning of encrypted
@@ -25,15 +26,45 @@ type Header struct {
 	Ephemeral [32]byte
 }
 
+// HybridHeader is the header variant used for hybrid recipients. In addition to
+// the ephemeral Curve25519 public key it holds an ML-KEM-768 ciphertext.
+type HybridHeader struct {
+	Magic      [8]byte
+	Salt       [8]byte
+	Ephemeral  [32]byte
+	Ciphertext [keyderivation.KEMCiphertextSize]byte
+}
+
 // Magic is the magic bytes string that is used to identify aenker files.
 // The two bytes after 'aenker' are the first two bytes of its Blake2b hash:
 //  >>> hashlib.blake2b(b'aenker').digest()[:2]
 //  b'\xe7\x9e'
 const Magic = "aenker\xe7\x9e"
 
+// HybridMagic identifies files encrypted for a hybrid recipient. Similarly:
+//  >>> hashlib.blake2b(b'aenker hybrid').digest()[:2]
+//  b'es'
+const HybridMagic = "aenker\x65\x73"
+
 // Keyinfo is used as context info for HKDF during key derivation.
 const Keyinfo = "aenker elliptic"
 
+// HybridKeyinfo is used as context info for HKDF during hybrid key derivation.
+const HybridKeyinfo = "aenker hybrid"
+
+// serialize writes the header struct to a writer and returns the written bytes
+func serialize(writer io.Writer, header interface{}) (head []byte, err error) {
+
+	// create a small buffer to hold the written header
+	buf := bytes.NewBuffer(make([]byte, 0, binary.Size(header)))
+
+	// write header to writer and a buffer
+	tee := io.MultiWriter(buf, writer)
+	err = binary.Write(tee, binary.BigEndian, header)
+	return buf.Bytes(), err
+
+}
+
 func writeNewHeader(writer io.Writer, peer *[32]byte) (key, head []byte, err error) {
 
 	// create new header struct and copy magic bytes
@@ -57,46 +88,178 @@ func writeNewHeader(writer io.Writer, peer *[32]byte) (key, head []byte, err err
 	// replace ephemeral with its public key
 	header.Ephemeral = *keyderivation.Public(&header.Ephemeral)
 
-	// create a small buffer to hold the written header
-	buf := bytes.NewBuffer(make([]byte, 0, 48))
-
-	// write header to writer and a buffer
-	tee := io.MultiWriter(buf, writer)
-	err = binary.Write(tee, binary.BigEndian, header)
+	// write header and return derived key
+	head, err = serialize(writer, header)
 	if err != nil {
 		key = nil
+	}
+	return
+
+}
+
+func writeNewHybridHeader(writer io.Writer, peer *[32]byte, kempub []byte) (key, head []byte, err error) {
+
+	// create new header struct and copy magic bytes
+	header := &HybridHeader{}
+	copy(header.Magic[:], []byte(HybridMagic))
+
+	// new random salt
+	_, err = io.ReadFull(rand.Reader, header.Salt[:])
+	if err != nil {
 		return
 	}
 
-	// return derived key and written header
-	return key, buf.Bytes(), err
+	// new ephemeral secret key
+	_, err = io.ReadFull(rand.Reader, header.Ephemeral[:])
+	if err != nil {
+		return
+	}
 
+	// encapsulate a second shared secret
+	kemshared, ct, err := keyderivation.Encapsulate(kempub)
+	if err != nil {
+		return
+	}
+	defer securebuf.Wipe(kemshared)
+	copy(header.Ciphertext[:], ct)
+
+	// derive shared key for chunkstream from both secrets
+	key = keyderivation.Hybrid(&header.Ephemeral, peer, kemshared, header.Salt[:], HybridKeyinfo)
+
+	// replace ephemeral with its public key
+	header.Ephemeral = *keyderivation.Public(&header.Ephemeral)
+
+	// write header and return derived key
+	head, err = serialize(writer, header)
+	if err != nil {
+		key = nil
+	}
+	return
+
+}
+
+// headerInfo holds the fields of either header variant that are needed for key derivation.
+type headerInfo struct {
+	magic      string
+	salt       []byte
+	ephemeral  *[32]byte
+	ciphertext []byte
+	stanzas    []byte // recipient stanzas with a wrapped data key
+	mac        []byte
+	params     params
 }
 
-func openHeader(reader io.Reader, private *[32]byte) (key, head []byte, err error) {
+// readHeader reads either header variant and returns its fields and serialization.
+func readHeader(reader io.Reader) (info *headerInfo, head []byte, err error) {
 
 	// create a small buffer to hold the read header
-	buf := bytes.NewBuffer(make([]byte, 0, 48))
+	buf := bytes.NewBuffer(make([]byte, 0, binary.Size(HybridHeader{})))
+	tee := io.TeeReader(reader, buf)
 
-	// create new header struct
-	header := &Header{}
+	// read magic bytes first to decide on the header variant,
+	// public data so no constant-time implementation
+	magic := make([]byte, 8)
+	if _, err = io.ReadFull(tee, magic); err != nil {
+		return
+	}
+	info = &headerInfo{magic: string(magic), ephemeral: new([32]byte)}
+	switch info.magic {
 
-	// read, buffer and decode header
-	tee := io.TeeReader(reader, buf)
-	err = binary.Read(tee, binary.BigEndian, header)
+	case Magic:
+		// read remaining header
+		rest := make([]byte, binary.Size(Header{})-len(magic))
+		if _, err = io.ReadFull(tee, rest); err != nil {
+			return nil, nil, err
+		}
+		info.salt = rest[:8]
+		copy(info.ephemeral[:], rest[8:])
+
+	case HybridMagic:
+		// read remaining header
+		rest := make([]byte, binary.Size(HybridHeader{})-len(magic))
+		if _, err = io.ReadFull(tee, rest); err != nil {
+			return nil, nil, err
+		}
+		info.salt = rest[:8]
+		copy(info.ephemeral[:], rest[8:40])
+		info.ciphertext = rest[40:]
+
+	case ParamsMagic:
+		// the parameters are followed by another header
+		if err = info.params.read(tee); err != nil {
+			return nil, nil, err
+		}
+		inner, rest, err := readHeader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if inner.params != (params{}) {
+			return nil, nil, errors.New("nested parameter header")
+		}
+		inner.params = info.params
+		return inner, append(buf.Bytes(), rest...), nil
+
+	case MultiMagic:
+		// only magic and salt are associated data
+		if err = readMultiHeader(tee, info); err != nil {
+			return nil, nil, err
+		}
+		return info, buf.Bytes()[:16], nil
+
+	default:
+		return nil, nil, errors.New("unknown magic bytes")
+	}
+
+	return info, buf.Bytes(), nil
+
+}
+
+// Recipients reads only the header of a file and returns the number of recipients, for
+// whom it was encrypted. The recipients themselves cannot be identified from the header.
+func Recipients(r io.Reader) (count int, err error) {
+	info, _, err := readHeader(r)
 	if err != nil {
 		return
 	}
+	if info.magic == MultiMagic {
+		return len(info.stanzas) / stanzaSize, nil
+	}
+	return 1, nil
+}
 
-	// check magic bytes, public data so no constant-time implementation
-	if bytes.Compare(header.Magic[:], []byte(Magic)) != 0 {
-		err = errors.New("unknown magic bytes")
+// deriveKey derives the chunkstream key with a private key. The kemseed may be nil
+// if only classic files should be opened.
+func (info *headerInfo) deriveKey(private *[32]byte, kemseed []byte) (key []byte, err error) {
+
+	if info.magic == Magic {
+		return keyderivation.Elliptic(private, info.ephemeral, info.salt, Keyinfo), nil
+	}
+	if info.magic == MultiMagic {
+		return info.multiKey(privateShared(private))
 	}
 
-	// derive shared key for chunkstream
-	key = keyderivation.Elliptic(private, &header.Ephemeral, header.Salt[:], Keyinfo)
+	if kemseed == nil {
+		return nil, errors.New("file is encrypted for a hybrid key")
+	}
+	kemshared, err := keyderivation.Decapsulate(kemseed, info.ciphertext)
+	if err != nil {
+		return
+	}
+	defer securebuf.Wipe(kemshared)
+	return keyderivation.Hybrid(private, info.ephemeral, kemshared, info.salt, HybridKeyinfo), nil
+
+}
+
+// openHeader reads either header variant and derives the chunkstream key. The
+// kemseed may be nil if only classic files should be opened.
+func openHeader(reader io.Reader, private *[32]byte, kemseed []byte) (key, head []byte, err error) {
+
+	info, head, err := readHeader(reader)
+	if err != nil {
+		return
+	}
 
-	// return derived key and read header
-	return key, buf.Bytes(), err
+	key, err = info.deriveKey(private, kemseed)
+	return key, head, err
 
 }
@@ -0,0 +1,160 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package ae
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+// plaintext sizes around the chunksize
+var sizes = []int{0, 1, Chunksize - 2, Chunksize - 1, 5000}
+
+// newKey returns a random private key and its public key
+func newKey() (private, public *[32]byte) {
+	private = new([32]byte)
+	rand.Read(private[:])
+	return private, keyderivation.Public(private)
+}
+
+// seal encrypts plain with a new writer
+func seal(t *testing.T, plain []byte, writer func(w io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	w, err := writer(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// open decrypts a file completely
+func open(file []byte, reader func(r io.Reader) (io.Reader, error)) ([]byte, error) {
+	r, err := reader(bytes.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// roundtrip checks that files of all sizes decrypt with the reader and at random offsets
+func roundtrip(t *testing.T, writer func(w io.Writer) (io.WriteCloser, error), reader func(r io.Reader) (io.Reader, error),
+	readerAt func(r io.ReaderAt, size int64) (*chunkstream.ReaderAt, error)) {
+	t.Helper()
+	for _, size := range sizes {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		file := seal(t, plain, writer)
+		if opened, err := open(file, reader); err != nil || !bytes.Equal(opened, plain) {
+			t.Errorf("size %d: reader failed: %v", size, err)
+		}
+		if readerAt == nil {
+			continue
+		}
+		ra, err := readerAt(bytes.NewReader(file), int64(len(file)))
+		if err != nil {
+			t.Errorf("size %d: readerat failed: %v", size, err)
+			continue
+		}
+		if opened, err := ioutil.ReadAll(io.NewSectionReader(ra, 0, ra.Size())); err != nil || !bytes.Equal(opened, plain) {
+			t.Errorf("size %d: readerat returned wrong plaintext: %v", size, err)
+		}
+		ra.Destroy()
+	}
+}
+
+// tamper checks that flipping a bit anywhere in the first n bytes of a file fails
+func tamper(t *testing.T, file []byte, n int, reader func(r io.Reader) (io.Reader, error)) {
+	t.Helper()
+	for i := 0; i < n && i < len(file); i++ {
+		file[i] ^= 0x10
+		if _, err := open(file, reader); err == nil {
+			t.Errorf("flipped bit at %d was not detected", i)
+		}
+		file[i] ^= 0x10
+	}
+	if _, err := open(file[:len(file)-1], reader); err == nil {
+		t.Errorf("truncation was not detected")
+	}
+}
+
+func TestClassic(t *testing.T) {
+
+	private, public := newKey()
+	writer := func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w, public) }
+	reader := func(r io.Reader) (io.Reader, error) { return NewReader(r, private) }
+	readerAt := func(r io.ReaderAt, size int64) (*chunkstream.ReaderAt, error) { return NewReaderAt(r, size, private) }
+	roundtrip(t, writer, reader, readerAt)
+
+	file := seal(t, []byte("classic"), writer)
+	if !bytes.HasPrefix(file, []byte(Magic)) {
+		t.Fatalf("wrong magic: %q", file[:8])
+	}
+	tamper(t, file, 64, reader)
+
+	// wrong keys fail with the first chunk
+	other, _ := newKey()
+	if _, err := open(file, func(r io.Reader) (io.Reader, error) { return NewReader(r, other) }); err == nil {
+		t.Error("opened with the wrong key")
+	}
+	if _, err := open(file, func(r io.Reader) (io.Reader, error) { return NewReaderAny(r, other, private) }); err != nil {
+		t.Errorf("NewReaderAny failed: %s", err)
+	}
+
+}
+
+func TestHybrid(t *testing.T) {
+
+	private, public := newKey()
+	kemseed := make([]byte, keyderivation.KEMSeedSize)
+	rand.Read(kemseed)
+	kempub, err := keyderivation.KEMPublic(kemseed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := func(w io.Writer) (io.WriteCloser, error) { return NewHybridWriter(w, public, kempub) }
+	reader := func(r io.Reader) (io.Reader, error) { return NewHybridReader(r, private, kemseed) }
+	readerAt := func(r io.ReaderAt, size int64) (*chunkstream.ReaderAt, error) {
+		return NewHybridReaderAt(r, size, private, kemseed)
+	}
+	roundtrip(t, writer, reader, readerAt)
+
+	// flipping a bit in the ML-KEM ciphertext yields a different shared secret
+	file := seal(t, []byte("hybrid"), writer)
+	if !bytes.HasPrefix(file, []byte(HybridMagic)) {
+		t.Fatalf("wrong magic: %q", file[:8])
+	}
+	tamper(t, file, 64, reader)
+	for _, i := range []int{48, 48 + keyderivation.KEMCiphertextSize - 1} {
+		file[i] ^= 0x01
+		if _, err := open(file, reader); err == nil {
+			t.Errorf("flipped bit in ciphertext at %d was not detected", i)
+		}
+		file[i] ^= 0x01
+	}
+
+	// the curve25519 part alone does not open hybrid files but classic ones
+	if _, err := open(file, func(r io.Reader) (io.Reader, error) { return NewReader(r, private) }); err == nil ||
+		!strings.Contains(err.Error(), "hybrid") {
+		t.Errorf("expected hybrid key error, got %v", err)
+	}
+	classic := seal(t, []byte("classic"), func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w, public) })
+	if opened, err := open(classic, reader); err != nil || string(opened) != "classic" {
+		t.Errorf("hybrid reader did not open a classic file: %v", err)
+	}
+
+}
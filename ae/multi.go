@@ -0,0 +1,301 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package ae
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// MultiMagic identifies files with a random data key, which is wrapped for one or more
+// recipients in the header. Similarly to Magic:
+//  >>> hashlib.blake2b(b'aenker multi').digest()[:2]
+//  b'\x941'
+const MultiMagic = "aenker\x94\x31"
+
+// Context info strings for HKDF in files with a wrapped data key.
+const (
+	MultiKeyinfo  = "aenker multi"        // chunkstream key from the data key
+	MultiMACinfo  = "aenker multi header" // header MAC key from the data key
+	MultiWrapinfo = "aenker wrap"         // wrapping key from a Diffie-Hellman shared secret
+)
+
+// Sizes of the parts of a header with a wrapped data key.
+const (
+	tagSize     = 16                // Poly1305 authentication tag
+	stanzaSize  = 32 + 32 + tagSize // ephemeral and wrapped data key
+	multiMACLen = 32
+)
+
+// MultiHeader is the header of files with a wrapped data key. It is followed by
+// Count recipient stanzas and a MAC over the entire header. Only Magic and Salt are
+// used as associated data in the chunks, so the stanzas can be rewritten without
+// touching the encrypted data.
+type MultiHeader struct {
+	Magic [8]byte
+	Salt  [8]byte
+	Count uint16
+}
+
+// Stanza wraps the data key for a single recipient.
+type Stanza struct {
+	Ephemeral [32]byte
+	Wrapped   [32 + tagSize]byte
+}
+
+// NewMultiWriter encrypts for one or more Curve25519 public keys. A random data key is
+// used for the chunks and wrapped for each of the recipients in the header. Any one of
+// their private keys opens the file with NewReader.
+func NewMultiWriter(w io.Writer, public ...*[32]byte) (cw io.WriteCloser, err error) {
+	return newMultiWriter(w, newParams(), public)
+}
+
+// newMultiWriter encrypts for the recipients with the given parameters of the chunks
+func newMultiWriter(w io.Writer, p params, public []*[32]byte) (cw io.WriteCloser, err error) {
+
+	// random data key and salt
+	datakey := securebuf.New(32)
+	defer datakey.Destroy()
+	salt := make([]byte, 8)
+	if _, err = io.ReadFull(rand.Reader, datakey.Bytes()); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return
+	}
+
+	prefix, err := p.write(w)
+	if err != nil {
+		return
+	}
+	head, err := writeMultiHeader(w, datakey.Bytes(), salt, public)
+	if err != nil {
+		return
+	}
+
+	key := keyderivation.HKDF(datakey.Bytes(), salt, MultiKeyinfo)
+	defer securebuf.Wipe(key)
+	return newChunkWriter(w, key, append(prefix, head...))
+
+}
+
+// ErrDowngrade is returned by Rekey for files that are encrypted for a hybrid key, since
+// the multi-recipient header of the result only uses X25519.
+var ErrDowngrade = errors.New("file is encrypted for a hybrid key, rekeying would remove its post-quantum protection")
+
+// Rekey copies an encrypted file from r to w for a new set of recipients. If the file
+// has a wrapped data key, only the header is rewritten and the encrypted data is copied
+// unchanged. Otherwise, or if reencrypt is true, the file is decrypted and encrypted
+// again with a new data key. Hybrid files are opened if a kemseed is given, but only if
+// downgrade is true, otherwise ErrDowngrade is returned. Returns true if only the header
+// was rewritten.
+func Rekey(w io.Writer, r io.Reader, private *[32]byte, kemseed []byte, reencrypt, downgrade bool, public ...*[32]byte) (headeronly bool, err error) {
+
+	if len(public) == 0 {
+		return false, errors.New("no recipients given")
+	}
+
+	info, head, err := readHeader(r)
+	if err != nil {
+		return
+	}
+	if info.magic == HybridMagic && !downgrade {
+		return false, ErrDowngrade
+	}
+
+	// rewrite only the header
+	if info.magic == MultiMagic && !reencrypt {
+		datakey, err := info.unwrap(privateShared(private))
+		if err != nil {
+			return false, err
+		}
+		defer securebuf.Wipe(datakey)
+		if _, err = info.params.write(w); err != nil {
+			return false, err
+		}
+		if _, err = writeMultiHeader(w, datakey, info.salt, public); err != nil {
+			return false, err
+		}
+		_, err = io.Copy(w, r)
+		return true, err
+	}
+
+	// otherwise stream through a new writer
+	key, err := info.deriveKey(private, kemseed)
+	if err != nil {
+		return
+	}
+	cr, err := newChunkReader(r, key, head, Repair)
+	securebuf.Wipe(key)
+	if err != nil {
+		return
+	}
+	defer cr.(chunkstream.Destroyer).Destroy()
+	cw, err := newMultiWriter(w, info.params, public)
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(cw, cr); err != nil {
+		cw.(chunkstream.Destroyer).Destroy()
+		return
+	}
+	return false, cw.Close()
+
+}
+
+// writeMultiHeader wraps the data key for all recipients, writes the header with its
+// MAC and returns the associated data for the chunks
+func writeMultiHeader(w io.Writer, datakey, salt []byte, public []*[32]byte) (head []byte, err error) {
+
+	if len(public) == 0 || len(public) > 0xffff {
+		return nil, errors.New("invalid number of recipients")
+	}
+
+	header := &MultiHeader{Count: uint16(len(public))}
+	copy(header.Magic[:], MultiMagic)
+	copy(header.Salt[:], salt)
+
+	var buf bytes.Buffer
+	if err = binary.Write(&buf, binary.BigEndian, header); err != nil {
+		return
+	}
+
+	// wrap data key for each recipient with a new ephemeral key
+	for _, peer := range public {
+		ephemeral := securebuf.New(32)
+		if _, err = io.ReadFull(rand.Reader, ephemeral.Bytes()); err != nil {
+			ephemeral.Destroy()
+			return
+		}
+		shared := new([32]byte)
+		curve25519.ScalarMult(shared, ephemeral.Key(), peer)
+		stanza := Stanza{Ephemeral: *keyderivation.Public(ephemeral.Key())}
+		ephemeral.Destroy()
+		if err = wrap(stanza.Wrapped[:0], shared, salt, stanza.Ephemeral[:], datakey); err != nil {
+			return
+		}
+		buf.Write(stanza.Ephemeral[:])
+		buf.Write(stanza.Wrapped[:])
+	}
+
+	// authenticate the entire header
+	buf.Write(multiMAC(datakey, salt, buf.Bytes()))
+
+	if _, err = w.Write(buf.Bytes()); err != nil {
+		return
+	}
+	return buf.Bytes()[:16], nil
+
+}
+
+// wrap encrypts the data key with a key derived from a shared secret and wipes the latter
+func wrap(dst []byte, shared *[32]byte, salt, ephemeral, datakey []byte) error {
+	key := keyderivation.HKDF(shared[:], salt, MultiWrapinfo)
+	securebuf.Wipe(shared[:])
+	defer securebuf.Wipe(key)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return err
+	}
+	aead.Seal(dst, make([]byte, aead.NonceSize()), datakey, ephemeral)
+	return nil
+}
+
+// multiMAC computes the header MAC with a key derived from the data key
+func multiMAC(datakey, salt, header []byte) []byte {
+	key := keyderivation.HKDF(datakey, salt, MultiMACinfo)
+	defer securebuf.Wipe(key)
+	mac, _ := blake2b.New256(key)
+	mac.Write(header)
+	return mac.Sum(nil)
+}
+
+// readMultiHeader reads the rest of a header with a wrapped data key after the magic bytes
+func readMultiHeader(reader io.Reader, info *headerInfo) (err error) {
+
+	rest := make([]byte, 10)
+	if _, err = io.ReadFull(reader, rest); err != nil {
+		return
+	}
+	info.salt = rest[:8]
+	count := int(binary.BigEndian.Uint16(rest[8:]))
+	if count == 0 {
+		return errors.New("no recipients in header")
+	}
+
+	info.stanzas = make([]byte, count*stanzaSize)
+	if _, err = io.ReadFull(reader, info.stanzas); err != nil {
+		return
+	}
+	info.mac = make([]byte, multiMACLen)
+	_, err = io.ReadFull(reader, info.mac)
+	return
+
+}
+
+// privateShared returns a SharedFunc for a private key
+func privateShared(private *[32]byte) SharedFunc {
+	return func(ephemeral *[32]byte) (*[32]byte, error) {
+		shared := new([32]byte)
+		curve25519.ScalarMult(shared, private, ephemeral)
+		return shared, nil
+	}
+}
+
+// multiKey unwraps the data key and derives the chunkstream key
+func (info *headerInfo) multiKey(shared SharedFunc) (key []byte, err error) {
+	datakey, err := info.unwrap(shared)
+	if err != nil {
+		return
+	}
+	defer securebuf.Wipe(datakey)
+	return keyderivation.HKDF(datakey, info.salt, MultiKeyinfo), nil
+}
+
+// unwrap tries to open each stanza with the shared secret and returns the data key
+// after verifying the header MAC
+func (info *headerInfo) unwrap(shared SharedFunc) (datakey []byte, err error) {
+
+	for s := info.stanzas; len(s) >= stanzaSize; s = s[stanzaSize:] {
+		ephemeral := (*[32]byte)(s[:32])
+		secret, err := shared(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		key := keyderivation.HKDF(secret[:], info.salt, MultiWrapinfo)
+		securebuf.Wipe(secret[:])
+		aead, err := chacha20poly1305.New(key)
+		securebuf.Wipe(key)
+		if err != nil {
+			return nil, err
+		}
+		datakey, err = aead.Open(nil, make([]byte, aead.NonceSize()), s[32:stanzaSize], ephemeral[:])
+		if err != nil {
+			continue
+		}
+
+		// verify the header
+		header := append(append([]byte(info.magic), info.salt...), 0, 0)
+		binary.BigEndian.PutUint16(header[16:], uint16(len(info.stanzas)/stanzaSize))
+		header = append(header, info.stanzas...)
+		if subtle.ConstantTimeCompare(multiMAC(datakey, info.salt, header), info.mac) != 1 {
+			securebuf.Wipe(datakey)
+			return nil, errors.New("header authentication failed")
+		}
+		return datakey, nil
+	}
+	return nil, errors.New("the file is not encrypted for this key")
+
+}
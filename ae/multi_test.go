@@ -0,0 +1,138 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package ae
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+// size of a header with n recipients
+func multiSize(n int) int {
+	return 18 + n*stanzaSize + multiMACLen
+}
+
+func TestMulti(t *testing.T) {
+
+	privates, publics := make([]*[32]byte, 3), make([]*[32]byte, 3)
+	for i := range privates {
+		privates[i], publics[i] = newKey()
+	}
+	writer := func(w io.Writer) (io.WriteCloser, error) { return NewMultiWriter(w, publics...) }
+
+	// every recipient opens the file
+	for _, private := range privates {
+		reader := func(r io.Reader) (io.Reader, error) { return NewReader(r, private) }
+		readerAt := func(r io.ReaderAt, size int64) (*chunkstream.ReaderAt, error) { return NewReaderAt(r, size, private) }
+		roundtrip(t, writer, reader, readerAt)
+	}
+
+	file := seal(t, []byte("multi"), writer)
+	if !bytes.HasPrefix(file, []byte(MultiMagic)) {
+		t.Fatalf("wrong magic: %q", file[:8])
+	}
+	if n, err := Recipients(bytes.NewReader(file)); err != nil || n != 3 {
+		t.Errorf("wrong number of recipients: %d, %v", n, err)
+	}
+	reader := func(r io.Reader) (io.Reader, error) { return NewReader(r, privates[0]) }
+	tamper(t, file, multiSize(3)+32, reader)
+
+	// the MAC covers the stanzas of other recipients
+	for _, i := range []int{17, 18 + stanzaSize, 18 + 3*stanzaSize} {
+		file[i] ^= 0x01
+		_, err := open(file, reader)
+		if err == nil || !strings.Contains(err.Error(), "header authentication failed") {
+			t.Errorf("flipped bit at %d: expected header authentication error, got %v", i, err)
+		}
+		file[i] ^= 0x01
+	}
+
+	other, _ := newKey()
+	if _, err := open(file, func(r io.Reader) (io.Reader, error) { return NewReader(r, other) }); err == nil ||
+		!strings.Contains(err.Error(), "not encrypted for this key") {
+		t.Errorf("expected error for another key, got %v", err)
+	}
+	shared := []SharedFunc{privateShared(other), privateShared(privates[2])}
+	if opened, err := open(file, func(r io.Reader) (io.Reader, error) { return NewReaderShared(r, shared...) }); err != nil ||
+		string(opened) != "multi" {
+		t.Errorf("NewReaderShared failed: %v", err)
+	}
+
+}
+
+func TestRekey(t *testing.T) {
+
+	alice, alicepub := newKey()
+	bob, bobpub := newKey()
+	carol, carolpub := newKey()
+	plain := bytes.Repeat([]byte("rekey"), 1000)
+	opens := func(file []byte, private *[32]byte) bool {
+		opened, err := open(file, func(r io.Reader) (io.Reader, error) { return NewReader(r, private) })
+		return err == nil && bytes.Equal(opened, plain)
+	}
+	rekey := func(file []byte, reencrypt bool, public ...*[32]byte) ([]byte, bool) {
+		out := new(bytes.Buffer)
+		headeronly, err := Rekey(out, bytes.NewReader(file), alice, nil, reencrypt, false, public...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes(), headeronly
+	}
+
+	// only the header is rewritten and the chunks are unchanged
+	file := seal(t, plain, func(w io.Writer) (io.WriteCloser, error) { return NewMultiWriter(w, alicepub, bobpub) })
+	rekeyed, headeronly := rekey(file, false, alicepub, carolpub)
+	if !headeronly || !bytes.Equal(rekeyed[multiSize(2):], file[multiSize(2):]) {
+		t.Error("header-only rekey changed the chunks")
+	}
+	if !opens(rekeyed, alice) || !opens(rekeyed, carol) || opens(rekeyed, bob) {
+		t.Error("wrong recipients after header-only rekey")
+	}
+
+	// re-encryption uses a new data key
+	rekeyed, headeronly = rekey(file, true, carolpub)
+	if headeronly || bytes.Equal(rekeyed[16:], file[16:]) {
+		t.Error("re-encryption did not change the file")
+	}
+	if !opens(rekeyed, carol) || opens(rekeyed, alice) {
+		t.Error("wrong recipients after re-encryption")
+	}
+
+	// classic files are always re-encrypted
+	file = seal(t, plain, func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w, alicepub) })
+	rekeyed, headeronly = rekey(file, false, bobpub)
+	if headeronly || !bytes.HasPrefix(rekeyed, []byte(MultiMagic)) || !opens(rekeyed, bob) {
+		t.Error("classic file was not re-encrypted for the new recipient")
+	}
+
+	// the old key must open the file
+	if _, err := Rekey(new(bytes.Buffer), bytes.NewReader(file), carol, nil, false, false, bobpub); err == nil {
+		t.Error("rekeyed with the wrong key")
+	}
+
+	// hybrid files are only downgraded on request
+	kemseed := make([]byte, keyderivation.KEMSeedSize)
+	rand.Read(kemseed)
+	kempub, err := keyderivation.KEMPublic(kemseed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file = seal(t, plain, func(w io.Writer) (io.WriteCloser, error) { return NewHybridWriter(w, alicepub, kempub) })
+	for _, reencrypt := range []bool{false, true} {
+		if _, err = Rekey(new(bytes.Buffer), bytes.NewReader(file), alice, kemseed, reencrypt, false, bobpub); err != ErrDowngrade {
+			t.Errorf("hybrid file was rekeyed without downgrade: %v", err)
+		}
+	}
+	out := new(bytes.Buffer)
+	if _, err = Rekey(out, bytes.NewReader(file), alice, kemseed, false, true, bobpub); err != nil || !opens(out.Bytes(), bob) {
+		t.Errorf("downgrade failed: %v", err)
+	}
+
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package ae
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/erasure"
+)
+
+// ParamsMagic starts the header of files, whose chunks differ from the default. It is
+// followed by a byte of flags, the number of data and parity chunks per group as single
+// bytes, optionally the chunksize as a 32 bit big-endian integer and then by one of the
+// other headers. All of it is used as associated data. Similarly to Magic:
+//  >>> hashlib.blake2b(b'aenker params').digest()[:2]
+//  b'L\xee'
+const ParamsMagic = "aenker\x4c\xee"
+
+// flags in the parameter header, unknown flags are rejected
+const (
+	flagFramed    = 1 << iota // framed chunks from chunkstream.NewFramedWriter
+	flagChunksize             // a chunksize other than DefaultChunksize follows
+)
+
+// limits of the chunksize and the size of a group of parity chunks, which is buffered
+const (
+	minChunksize = 64
+	maxChunksize = 16 << 20
+	maxGroup     = 256 << 20
+)
+
+// DataChunks and ParityChunks add a parity layer to new files, see chunkstream.Parity.
+// Every group of DataChunks chunks is followed by ParityChunks parity chunks, which can
+// rebuild as many damaged chunks of the group. Both are recorded in the header, so they
+// need not be known when opening the file. Zero disables parity.
+var DataChunks, ParityChunks = 0, 0
+
+// Repair enables the repair of damaged chunks in files with parity if it is not nil. It
+// is called with the index of every chunk that was rebuilt.
+var Repair func(index uint64)
+
+// params are the parameters of the chunks in a file
+type params struct {
+	framed       bool
+	data, parity int // data and parity chunks per group
+	chunksize    int // zero for DefaultChunksize
+}
+
+// newParams returns the parameters for new files
+func newParams() params {
+	p := params{framed: Framed, data: DataChunks, parity: ParityChunks}
+	if Chunksize != DefaultChunksize {
+		p.chunksize = Chunksize
+	}
+	return p
+}
+
+// size returns the chunksize
+func (p params) size() int {
+	if p.chunksize == 0 {
+		return DefaultChunksize
+	}
+	return p.chunksize
+}
+
+// Parameters reads only the header of a file and returns the parameters of its chunks,
+// which can be assigned to Framed, DataChunks, ParityChunks and Chunksize to write another
+// file with the same chunks.
+func Parameters(r io.Reader) (framed bool, data, parity, chunksize int, err error) {
+	info, _, err := readHeader(r)
+	if err != nil {
+		return
+	}
+	p := info.params
+	return p.framed, p.data, p.parity, p.size(), nil
+}
+
+// check the parameters
+func (p params) check() (err error) {
+	if p.chunksize != 0 && (p.chunksize < minChunksize || p.chunksize > maxChunksize) {
+		return fmt.Errorf("chunksize must be between %d bytes and %d MiB, got %d", minChunksize, maxChunksize>>20, p.chunksize)
+	}
+	if p.data != 0 || p.parity != 0 {
+		if (p.data+p.parity)*p.size() > maxGroup {
+			return fmt.Errorf("groups of parity chunks must be smaller than %d MiB", maxGroup>>20)
+		}
+		if _, err = erasure.New(p.data, p.parity); err != nil {
+			return
+		}
+		if p.framed {
+			return errors.New("framed chunks cannot have parity")
+		}
+	}
+	return
+}
+
+// write writes the parameter header if any parameter differs from the default
+func (p params) write(w io.Writer) (head []byte, err error) {
+	if err = p.check(); err != nil || p == (params{}) {
+		return
+	}
+	var flags byte
+	if p.framed {
+		flags |= flagFramed
+	}
+	if p.chunksize != 0 {
+		flags |= flagChunksize
+	}
+	head = append([]byte(ParamsMagic), flags, byte(p.data), byte(p.parity))
+	if p.chunksize != 0 {
+		head = binary.BigEndian.AppendUint32(head, uint32(p.chunksize))
+	}
+	_, err = w.Write(head)
+	return
+}
+
+// read reads the parameters after the magic bytes
+func (p *params) read(r io.Reader) (err error) {
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(r, buf[:3]); err != nil {
+		return
+	}
+	if buf[0]&^(flagFramed|flagChunksize) != 0 {
+		return errors.New("unknown flags in parameter header")
+	}
+	p.framed = buf[0]&flagFramed != 0
+	p.data, p.parity = int(buf[1]), int(buf[2])
+	if buf[0]&flagChunksize != 0 {
+		if _, err = io.ReadFull(r, buf[:4]); err != nil {
+			return
+		}
+		if p.chunksize = int(binary.BigEndian.Uint32(buf[:4])); p.chunksize == DefaultChunksize {
+			return errors.New("default chunksize in parameter header")
+		}
+	}
+	return p.check()
+}
+
+// parseParams returns the parameters from the associated data of a file, which starts
+// with the header. Any errors were caught when reading the header.
+func parseParams(head []byte) (p params) {
+	if len(head) > len(ParamsMagic)+3 && string(head[:len(ParamsMagic)]) == ParamsMagic {
+		p.read(bytes.NewReader(head[len(ParamsMagic):]))
+	}
+	return
+}
+
+// chunkOptions returns the options of the chunkstream for a file with the given header.
+// Chunks are repaired if report is not nil.
+func chunkOptions(head []byte, report func(index uint64)) []chunkstream.Option {
+	opts := []chunkstream.Option{chunkstream.UniqueKey()}
+	if p := parseParams(head); p.data > 0 {
+		opts = append(opts, chunkstream.Parity(p.data, p.parity))
+		if report != nil {
+			opts = append(opts, chunkstream.Repair(report))
+		}
+	}
+	return opts
+}
+
+// firstGroup returns the maximum length of the first chunk or, with parity, the first
+// group of chunks, where every parity chunk is followed by a CRC-32. Framed chunks are
+// prefixed with their length.
+func firstGroup(head []byte, overhead int) int {
+	p := parseParams(head)
+	size := p.size() + overhead
+	switch {
+	case p.framed:
+		return 4 + size
+	case p.data > 0:
+		return p.data*size + p.parity*(size+4)
+	}
+	return size
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package ae
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/ansemjo/aenker/chunkstream"
+)
+
+// withParity enables parity for new files until the returned function is called
+func withParity(data, parity int) func() {
+	DataChunks, ParityChunks = data, parity
+	return func() { DataChunks, ParityChunks = 0, 0 }
+}
+
+func TestParity(t *testing.T) {
+
+	defer withParity(4, 2)()
+	private, public := newKey()
+	other, otherpub := newKey()
+	reader := func(r io.Reader) (io.Reader, error) { return NewReader(r, private) }
+	readerAt := func(r io.ReaderAt, size int64) (*chunkstream.ReaderAt, error) { return NewReaderAt(r, size, private) }
+
+	// all writers record the scheme in front of their header
+	for name, writer := range map[string]func(w io.Writer) (io.WriteCloser, error){
+		"classic": func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w, public) },
+		"multi":   func(w io.Writer) (io.WriteCloser, error) { return NewMultiWriter(w, otherpub, public) },
+	} {
+		roundtrip(t, writer, reader, readerAt)
+		file := seal(t, []byte(name), writer)
+		if !bytes.HasPrefix(file, append([]byte(ParamsMagic), 0, 4, 2)) {
+			t.Errorf("%s: wrong parameter header: %q", name, file[:11])
+		}
+		tamper(t, file, 11, reader)
+	}
+
+	// nested and invalid schemes are rejected
+	file := seal(t, []byte("parity"), func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w, public) })
+	nested := append(append([]byte(ParamsMagic), 0, 4, 2), file...)
+	if _, err := open(nested, reader); err == nil {
+		t.Error("nested parameter header accepted")
+	}
+	for _, scheme := range [][3]byte{{0, 0, 2}, {0, 4, 0}, {0, 200, 100}, {flagFramed, 4, 2}, {0x80, 4, 2}} {
+		invalid := append(append([]byte(ParamsMagic), scheme[:]...), file[11:]...)
+		if _, err := open(invalid, reader); err == nil {
+			t.Errorf("invalid parameters %v accepted", scheme)
+		}
+	}
+
+	// damaged chunks are only repaired if Repair is set
+	plain := make([]byte, 20*Chunksize)
+	rand.Read(plain)
+	file = seal(t, plain, func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w, public) })
+	file[11+48+100] ^= 0x01
+	if _, err := open(file, reader); err == nil {
+		t.Error("damaged chunk was not detected")
+	}
+	var repaired []uint64
+	Repair = func(index uint64) { repaired = append(repaired, index) }
+	defer func() { Repair = nil }()
+	if opened, err := open(file, reader); err != nil || !bytes.Equal(opened, plain) {
+		t.Errorf("damaged chunk was not repaired: %v", err)
+	}
+	if len(repaired) != 1 || repaired[0] != 0 {
+		t.Errorf("wrong chunks reported as repaired: %v", repaired)
+	}
+
+	// rekeying keeps the scheme, even if parity is disabled meanwhile
+	Repair = nil
+	DataChunks, ParityChunks = 0, 0
+	for _, reencrypt := range []bool{false, true} {
+		multi := seal(t, plain, func(w io.Writer) (io.WriteCloser, error) {
+			return newMultiWriter(w, params{data: 4, parity: 2}, []*[32]byte{public})
+		})
+		out := new(bytes.Buffer)
+		if _, err := Rekey(out, bytes.NewReader(multi), private, nil, reencrypt, false, otherpub); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(out.Bytes(), append([]byte(ParamsMagic), 0, 4, 2)) {
+			t.Errorf("reencrypt %v: parameter header was lost", reencrypt)
+		}
+		opened, err := open(out.Bytes(), func(r io.Reader) (io.Reader, error) { return NewReader(r, other) })
+		if err != nil || !bytes.Equal(opened, plain) {
+			t.Errorf("reencrypt %v: rekeyed file does not open: %v", reencrypt, err)
+		}
+	}
+
+}
+
+func TestFramed(t *testing.T) {
+
+	Framed = true
+	defer func() { Framed = false }()
+	private, public := newKey()
+	other, otherpub := newKey()
+	reader := func(r io.Reader) (io.Reader, error) { return NewReader(r, private) }
+	trial := func(r io.Reader) (io.Reader, error) { return NewReaderAny(r, other, private) }
+	shared := func(r io.Reader) (io.Reader, error) {
+		return NewReaderShared(r, privateShared(other), privateShared(private))
+	}
+
+	for name, writer := range map[string]func(w io.Writer) (io.WriteCloser, error){
+		"classic": func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w, public) },
+		"multi":   func(w io.Writer) (io.WriteCloser, error) { return NewMultiWriter(w, otherpub, public) },
+	} {
+		roundtrip(t, writer, reader, nil)
+		roundtrip(t, writer, trial, nil)
+		roundtrip(t, writer, shared, nil)
+
+		// flushed and empty frames
+		buf := new(bytes.Buffer)
+		w, err := writer(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range []string{"", "framed\n", "", "chunks\n"} {
+			w.Write([]byte(line))
+			if err = w.(chunkstream.FlushWriteCloser).Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+		file := buf.Bytes()
+		if !bytes.HasPrefix(file, append([]byte(ParamsMagic), flagFramed, 0, 0)) {
+			t.Errorf("%s: wrong parameter header: %q", name, file[:11])
+		}
+		for _, r := range []func(r io.Reader) (io.Reader, error){reader, trial, shared} {
+			if opened, err := open(file, r); err != nil || string(opened) != "framed\nchunks\n" {
+				t.Errorf("%s: flushed file: %q, %v", name, opened, err)
+			}
+		}
+		salvaged := new(bytes.Buffer)
+		if lost, err := Salvage(salvaged, bytes.NewReader(file), private, nil, true); err != nil || len(lost) != 0 || salvaged.String() != "framed\nchunks\n" {
+			t.Errorf("%s: salvaged %q, lost %v: %v", name, salvaged, lost, err)
+		}
+		tamper(t, file, 11, reader)
+
+		// random access is refused clearly
+		if _, err := NewReaderAt(bytes.NewReader(file), int64(len(file)), private); err != ErrFramed {
+			t.Errorf("%s: expected error for random access, got %v", name, err)
+		}
+	}
+
+}
+
+func TestChunksize(t *testing.T) {
+
+	Chunksize = 4096
+	defer func() { Chunksize = DefaultChunksize }()
+	private, public := newKey()
+	_, otherpub := newKey()
+	reader := func(r io.Reader) (io.Reader, error) { return NewReader(r, private) }
+	readerAt := func(r io.ReaderAt, size int64) (*chunkstream.ReaderAt, error) { return NewReaderAt(r, size, private) }
+	prefix := append([]byte(ParamsMagic), flagChunksize, 0, 0, 0, 0, 0x10, 0)
+
+	for name, writer := range map[string]func(w io.Writer) (io.WriteCloser, error){
+		"classic": func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w, public) },
+		"multi":   func(w io.Writer) (io.WriteCloser, error) { return NewMultiWriter(w, otherpub, public) },
+	} {
+		roundtrip(t, writer, reader, readerAt)
+		file := seal(t, []byte(name), writer)
+		if !bytes.HasPrefix(file, prefix) {
+			t.Errorf("%s: wrong parameter header: %q", name, file[:15])
+		}
+		if framed, data, parity, size, err := Parameters(bytes.NewReader(file)); err != nil || framed || data != 0 || parity != 0 || size != 4096 {
+			t.Errorf("%s: wrong parameters: %v %d %d %d %v", name, framed, data, parity, size, err)
+		}
+		tamper(t, file, 15, reader)
+
+		// readers use the chunksize of the file
+		plain := make([]byte, 3*Chunksize)
+		rand.Read(plain)
+		file = seal(t, plain, writer)
+		Chunksize = DefaultChunksize
+		if opened, err := open(file, reader); err != nil || !bytes.Equal(opened, plain) {
+			t.Errorf("%s: file does not open with another chunksize: %v", name, err)
+		}
+		Chunksize = 4096
+	}
+
+	// the default and invalid chunksizes are rejected
+	file := seal(t, []byte("chunksize"), func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w, public) })
+	for _, size := range [][4]byte{{0, 0, 0x07, 0xc0}, {0, 0, 0, 0}, {0, 0, 0, 63}, {0xff, 0xff, 0xff, 0xff}} {
+		invalid := append(append(append([]byte(ParamsMagic), flagChunksize, 0, 0), size[:]...), file[15:]...)
+		if _, err := open(invalid, reader); err == nil {
+			t.Errorf("invalid chunksize %v accepted", size)
+		}
+	}
+	Chunksize = 32
+	if _, err := NewWriter(new(bytes.Buffer), public); err == nil {
+		t.Error("writer accepted a chunksize below the minimum")
+	}
+
+}
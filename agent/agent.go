@@ -0,0 +1,272 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package agent implements a key agent, which holds private keys in locked memory
+// and serves Curve25519 shared secrets over a Unix socket, similar to ssh-agent.
+// The private keys themselves never leave the agent; clients only receive the
+// result of the Diffie-Hellman exchange with the ephemeral key of a file header.
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/curve25519"
+)
+
+// SockEnv is the environment variable, which holds the path to the agent's socket.
+const SockEnv = "AENKER_AUTH_SOCK"
+
+// Identity is a key held by the agent, as listed to clients.
+type Identity struct {
+	Public  [32]byte
+	Comment string
+}
+
+// entry is a private key held by the agent
+type entry struct {
+	private *securebuf.Buffer
+	Identity
+}
+
+// Agent holds private keys and answers requests from clients. Use New to create one.
+type Agent struct {
+	mu     sync.Mutex
+	keys   []*entry
+	locked []byte // salted hash of the passphrase if locked
+	salt   []byte
+
+	// failed attempts to unlock and the time of the next allowed attempt
+	failures int
+	retry    time.Time
+}
+
+// unlockDelay is the delay after a failed attempt to unlock, which doubles with every
+// further failure up to maxUnlockDelay
+var unlockDelay, maxUnlockDelay = time.Second, time.Minute
+
+// hashPassphrase hashes the passphrase of a locked agent with Argon2id
+func hashPassphrase(passphrase, salt []byte) []byte {
+	return argon2.IDKey(passphrase, salt, 3, 64*1024, 4, 32)
+}
+
+// New returns an empty agent.
+func New() *Agent {
+	return &Agent{}
+}
+
+// Add adds a copy of the private key, which is locked in memory if possible. Adding
+// the same key again only updates its comment.
+func (a *Agent) Add(private *[32]byte, comment string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked != nil {
+		return errors.New("agent is locked")
+	}
+	pub := keyderivation.Public(private)
+	for _, e := range a.keys {
+		if e.Public == *pub {
+			e.Comment = comment
+			return nil
+		}
+	}
+	e := &entry{private: securebuf.New(32), Identity: Identity{Public: *pub, Comment: comment}}
+	copy(e.private.Bytes(), private[:])
+	a.keys = append(a.keys, e)
+	return nil
+}
+
+// List returns the identities of all keys, or none if the agent is locked.
+func (a *Agent) List() []Identity {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked != nil {
+		return nil
+	}
+	ids := make([]Identity, len(a.keys))
+	for i, e := range a.keys {
+		ids[i] = e.Identity
+	}
+	return ids
+}
+
+// Remove removes and wipes the key with the given public key, or all keys if it is nil.
+func (a *Agent) Remove(public *[32]byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked != nil {
+		return errors.New("agent is locked")
+	}
+	kept := a.keys[:0]
+	for _, e := range a.keys {
+		if public == nil || e.Public == *public {
+			e.private.Destroy()
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if len(kept) == len(a.keys) {
+		return errors.New("key not found")
+	}
+	a.keys = kept
+	return nil
+}
+
+// Lock locks the agent with a passphrase. While locked, no keys are listed and no
+// shared secrets are computed.
+func (a *Agent) Lock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked != nil {
+		return errors.New("agent is already locked")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	a.locked, a.salt = hashPassphrase(passphrase, salt), salt
+	a.failures, a.retry = 0, time.Time{}
+	return nil
+}
+
+// Unlock unlocks the agent with the passphrase that it was locked with. After a wrong
+// passphrase, further attempts are refused for a delay that doubles with every failure.
+func (a *Agent) Unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked == nil {
+		return errors.New("agent is not locked")
+	}
+	if wait := time.Until(a.retry); wait > 0 {
+		return fmt.Errorf("too many attempts, try again in %s", wait.Round(time.Second))
+	}
+	if subtle.ConstantTimeCompare(hashPassphrase(passphrase, a.salt), a.locked) != 1 {
+		delay := maxUnlockDelay
+		if a.failures < 16 && unlockDelay<<a.failures < maxUnlockDelay {
+			delay = unlockDelay << a.failures
+		}
+		a.failures++
+		a.retry = time.Now().Add(delay)
+		return errors.New("incorrect passphrase")
+	}
+	a.locked, a.salt = nil, nil
+	a.failures, a.retry = 0, time.Time{}
+	return nil
+}
+
+// Shared performs Diffie-Hellman with the private key to the given public key and
+// the peer's public key, e.g. the ephemeral key in a file header.
+func (a *Agent) Shared(public, peer *[32]byte) (shared *[32]byte, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked != nil {
+		return nil, errors.New("agent is locked")
+	}
+	for _, e := range a.keys {
+		if e.Public == *public {
+			shared = new([32]byte)
+			curve25519.ScalarMult(shared, e.private.Key(), peer)
+			return
+		}
+	}
+	return nil, errors.New("key not found")
+}
+
+// Serve accepts connections on the listener and answers their requests until
+// the listener is closed.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+// serveConn answers requests on a single connection until it is closed
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		typ, body, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		reply, err := a.handle(typ, body)
+		securebuf.Wipe(body)
+		if err != nil {
+			err = writeMessage(conn, msgFailure, []byte(err.Error()))
+		} else {
+			err = writeMessage(conn, msgSuccess, reply)
+			securebuf.Wipe(reply)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle decodes a single request and returns the body of the reply
+func (a *Agent) handle(typ byte, body []byte) (reply []byte, err error) {
+	switch typ {
+
+	case msgAdd:
+		if len(body) < 32 {
+			return nil, errors.New("invalid add request")
+		}
+		private := securebuf.Copy(body[:32])
+		defer private.Destroy()
+		return nil, a.Add(private.Key(), string(body[32:]))
+
+	case msgList:
+		var buf bytes.Buffer
+		for _, id := range a.List() {
+			buf.Write(id.Public[:])
+			binary.Write(&buf, binary.BigEndian, uint16(len(id.Comment)))
+			buf.WriteString(id.Comment)
+		}
+		return buf.Bytes(), nil
+
+	case msgRemove:
+		if len(body) == 0 {
+			return nil, a.Remove(nil)
+		}
+		if len(body) != 32 {
+			return nil, errors.New("invalid remove request")
+		}
+		public := new([32]byte)
+		copy(public[:], body)
+		return nil, a.Remove(public)
+
+	case msgLock:
+		return nil, a.Lock(body)
+
+	case msgUnlock:
+		return nil, a.Unlock(body)
+
+	case msgShared:
+		if len(body) != 64 {
+			return nil, errors.New("invalid shared secret request")
+		}
+		public, peer := new([32]byte), new([32]byte)
+		copy(public[:], body[:32])
+		copy(peer[:], body[32:])
+		shared, err := a.Shared(public, peer)
+		if err != nil {
+			return nil, err
+		}
+		return shared[:], nil // wiped by serveConn after sending
+
+	}
+	return nil, errors.New("unknown request")
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansemjo/aenker/keyderivation"
+	"golang.org/x/crypto/curve25519"
+)
+
+// newKey returns a random private key and its public key
+func newKey() (private, public *[32]byte) {
+	private = new([32]byte)
+	rand.Read(private[:])
+	return private, keyderivation.Public(private)
+}
+
+func TestProtocol(t *testing.T) {
+
+	var buf bytes.Buffer
+	body := []byte("hello agent")
+	if err := writeMessage(&buf, msgAdd, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMessage(&buf, msgList, nil); err != nil {
+		t.Fatal(err)
+	}
+	if typ, got, err := readMessage(&buf); err != nil || typ != msgAdd || string(got) != "hello agent" {
+		t.Errorf("first message: %d %q: %v", typ, got, err)
+	}
+	if typ, got, err := readMessage(&buf); err != nil || typ != msgList || len(got) != 0 {
+		t.Errorf("empty message: %d %q: %v", typ, got, err)
+	}
+
+	// oversized and truncated messages are rejected
+	if _, _, err := readMessage(bytes.NewReader([]byte{msgAdd, 0, 1, 0, 1})); err == nil {
+		t.Error("oversized message was accepted")
+	}
+	if _, _, err := readMessage(bytes.NewReader([]byte{msgAdd, 0, 0, 0, 4, 'a'})); err == nil {
+		t.Error("truncated message was accepted")
+	}
+
+	// unknown and malformed requests fail
+	a := New()
+	for _, req := range []struct {
+		typ  byte
+		body []byte
+	}{{0xff, nil}, {msgAdd, make([]byte, 31)}, {msgRemove, make([]byte, 5)}, {msgShared, make([]byte, 63)}} {
+		if _, err := a.handle(req.typ, req.body); err == nil {
+			t.Errorf("request %d with %d bytes was accepted", req.typ, len(req.body))
+		}
+	}
+
+}
+
+func TestLock(t *testing.T) {
+
+	defer func(d time.Duration) { unlockDelay = d }(unlockDelay)
+	unlockDelay = 50 * time.Millisecond
+
+	a := New()
+	private, public := newKey()
+	_, peer := newKey()
+	if err := a.Add(private, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Lock([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is served while locked
+	if len(a.List()) != 0 {
+		t.Error("keys listed while locked")
+	}
+	if _, err := a.Shared(public, peer); err == nil {
+		t.Error("shared secret computed while locked")
+	}
+	if err := a.Add(private, "again"); err == nil {
+		t.Error("key added while locked")
+	}
+	if err := a.Lock([]byte("other")); err == nil {
+		t.Error("locked twice")
+	}
+
+	// the passphrase is salted
+	first := append([]byte(nil), a.locked...)
+	if bytes.Equal(first, hashPassphrase([]byte("secret"), make([]byte, 16))) {
+		t.Error("passphrase hash is not salted")
+	}
+
+	// attempts after a wrong passphrase are refused for a while
+	if err := a.Unlock([]byte("wrong")); err == nil {
+		t.Fatal("unlocked with a wrong passphrase")
+	}
+	if err := a.Unlock([]byte("secret")); err == nil || !strings.Contains(err.Error(), "too many attempts") {
+		t.Errorf("attempt right after a failure: %v", err)
+	}
+	time.Sleep(unlockDelay)
+	if err := a.Unlock([]byte("wrong")); err == nil {
+		t.Fatal("unlocked with a wrong passphrase")
+	}
+	if wait := time.Until(a.retry); wait <= unlockDelay {
+		t.Errorf("delay did not grow: %s", wait)
+	}
+	time.Sleep(2 * unlockDelay)
+	if err := a.Unlock([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.List()) != 1 {
+		t.Error("keys not listed after unlocking")
+	}
+	if err := a.Unlock([]byte("secret")); err == nil {
+		t.Error("unlocked twice")
+	}
+
+	// a new lock gets a new salt
+	a.Lock([]byte("secret"))
+	if bytes.Equal(first, a.locked) {
+		t.Error("salt was reused")
+	}
+
+}
+
+func TestClient(t *testing.T) {
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := Listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if stat, err := os.Stat(socket); err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("socket is accessible to others: %v", stat.Mode())
+	}
+	go New().Serve(l)
+
+	c, err := Dial(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	private, public := newKey()
+	other, otherpub := newKey()
+	_, peer := newKey()
+	if err = c.Add(private, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Add(other, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Add(private, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := c.List()
+	if err != nil || len(ids) != 2 || ids[0].Public != *public || ids[0].Comment != "renamed" || ids[1].Public != *otherpub {
+		t.Fatalf("wrong identities %v: %v", ids, err)
+	}
+
+	// the shared secret matches a local Diffie-Hellman
+	shared, err := c.Shared(public, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := new([32]byte)
+	curve25519.ScalarMult(expect, private, peer)
+	if *shared != *expect {
+		t.Error("wrong shared secret")
+	}
+
+	// removed and locked keys are gone
+	if err = c.Remove(public); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Shared(public, peer); err == nil {
+		t.Error("shared secret with a removed key")
+	}
+	if err = c.Lock([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Shared(otherpub, peer); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("shared secret while locked: %v", err)
+	}
+	if err = c.Unlock([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.RemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err = c.List(); err != nil || len(ids) != 0 {
+		t.Errorf("keys left after removing all: %v", ids)
+	}
+
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// Client is a connection to a running agent.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Dial connects to the agent listening on the socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// DialEnv connects to the agent whose socket is given in the SockEnv variable.
+func DialEnv() (*Client, error) {
+	path := os.Getenv(SockEnv)
+	if path == "" {
+		return nil, errors.New(SockEnv + " is not set")
+	}
+	return Dial(path)
+}
+
+// Close closes the connection to the agent.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// call sends a request and returns the body of a successful reply
+func (c *Client) call(typ byte, body []byte) (reply []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = writeMessage(c.conn, typ, body); err != nil {
+		return
+	}
+	typ, reply, err = readMessage(c.conn)
+	if err != nil {
+		return
+	}
+	if typ != msgSuccess {
+		return nil, errors.New("agent: " + string(reply))
+	}
+	return
+}
+
+// Add sends a private key to the agent.
+func (c *Client) Add(private *[32]byte, comment string) error {
+	body := append(append(make([]byte, 0, 32+len(comment)), private[:]...), comment...)
+	defer securebuf.Wipe(body)
+	_, err := c.call(msgAdd, body)
+	return err
+}
+
+// List returns the identities of all keys held by the agent.
+func (c *Client) List() (ids []Identity, err error) {
+	reply, err := c.call(msgList, nil)
+	if err != nil {
+		return
+	}
+	for len(reply) > 0 {
+		if len(reply) < 34 {
+			return nil, errors.New("agent: invalid list reply")
+		}
+		var id Identity
+		copy(id.Public[:], reply)
+		length := int(binary.BigEndian.Uint16(reply[32:]))
+		reply = reply[34:]
+		if len(reply) < length {
+			return nil, errors.New("agent: invalid list reply")
+		}
+		id.Comment, reply = string(reply[:length]), reply[length:]
+		ids = append(ids, id)
+	}
+	return
+}
+
+// Remove removes the key with the given public key from the agent.
+func (c *Client) Remove(public *[32]byte) error {
+	_, err := c.call(msgRemove, public[:])
+	return err
+}
+
+// RemoveAll removes all keys from the agent.
+func (c *Client) RemoveAll() error {
+	_, err := c.call(msgRemove, nil)
+	return err
+}
+
+// Lock locks the agent with a passphrase.
+func (c *Client) Lock(passphrase []byte) error {
+	_, err := c.call(msgLock, passphrase)
+	return err
+}
+
+// Unlock unlocks the agent with a passphrase.
+func (c *Client) Unlock(passphrase []byte) error {
+	_, err := c.call(msgUnlock, passphrase)
+	return err
+}
+
+// Shared asks the agent to perform Diffie-Hellman with the private key to the
+// public key and the peer's public key.
+func (c *Client) Shared(public, peer *[32]byte) (shared *[32]byte, err error) {
+	reply, err := c.call(msgShared, append(public[:len(public):len(public)], peer[:]...))
+	if err != nil {
+		return
+	}
+	if len(reply) != 32 {
+		return nil, errors.New("agent: invalid shared secret reply")
+	}
+	shared = new([32]byte)
+	copy(shared[:], reply)
+	securebuf.Wipe(reply)
+	return
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+//go:build !unix
+
+package agent
+
+import (
+	"net"
+	"os"
+)
+
+// Listen creates the socket at path and restricts it to the current user. There is no
+// umask on this platform, so the permissions are only changed after the socket exists.
+func Listen(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
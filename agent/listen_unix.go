@@ -0,0 +1,20 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+//go:build unix
+
+package agent
+
+import (
+	"net"
+	"syscall"
+)
+
+// Listen creates the socket at path, which is only accessible to the current user from
+// the start. The umask is changed while the socket is created, so this should not be
+// called while other goroutines create files.
+func Listen(path string) (net.Listener, error) {
+	mask := syscall.Umask(0177)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
+}
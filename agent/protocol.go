@@ -0,0 +1,54 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// Message types of the agent protocol. Every message is framed as a single type
+// byte, followed by the length of the body as a big-endian uint32 and the body.
+const (
+	msgFailure byte = iota + 1
+	msgSuccess
+	msgAdd    // private key || comment
+	msgList   // empty
+	msgRemove // public key, or empty to remove all keys
+	msgLock   // passphrase
+	msgUnlock // passphrase
+	msgShared // public key || peer public key
+)
+
+// maxMessage is the largest accepted message body
+const maxMessage = 64 * 1024
+
+// writeMessage writes a framed message and wipes its copy of the body
+func writeMessage(w io.Writer, typ byte, body []byte) (err error) {
+	msg := make([]byte, 5, 5+len(body))
+	msg[0] = typ
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(body)))
+	msg = append(msg, body...)
+	defer securebuf.Wipe(msg)
+	_, err = w.Write(msg)
+	return
+}
+
+// readMessage reads a framed message
+func readMessage(r io.Reader) (typ byte, body []byte, err error) {
+	head := make([]byte, 5)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return
+	}
+	length := binary.BigEndian.Uint32(head[1:])
+	if length > maxMessage {
+		return 0, nil, errors.New("agent: message too large")
+	}
+	body = make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return head[0], body, err
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package archive implements an encrypted container for many files with random access
+// to single entries. Every entry is stored as an independent chunkstream segment with
+// its own key and an encrypted index at the end records the name, mode, size and
+// position of each entry. Listing an archive or extracting a single file only reads the
+// header, the index and the segments that are needed.
+//
+// The layout of an archive is:
+//  Header | segment 0 | segment 1 | ... | index segment | uint64 BE offset of index
+//
+// A master key is derived from an anonymous Diffie-Hellman exchange like in ae, from
+// which the key of each segment is derived with HKDF. The serialized header is the
+// associated data of every chunk.
+package archive
+
+import (
+	"io/fs"
+	"strconv"
+	"time"
+
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+// Magic identifies aenker archives. Similarly to ae.Magic:
+//  >>> hashlib.blake2b(b'aenker archive').digest()[:2]
+//  b'M>'
+const Magic = "aenker\x4d\x3e"
+
+// Context info strings for HKDF in archives.
+const (
+	Keyinfo   = "aenker archive"        // master key from the Diffie-Hellman shared secret
+	Indexinfo = "aenker archive index"  // key of the index segment
+	Entryinfo = "aenker archive entry " // key of entry segments, followed by the decimal entry number
+)
+
+// Header is serialized at the beginning of archives. Unlike ae files, the chunksize is
+// recorded, since random access depends on it.
+type Header struct {
+	Magic     [8]byte
+	Salt      [8]byte
+	Ephemeral [32]byte
+	Chunksize uint32
+}
+
+// trailerSize is the length of the index offset at the end of an archive.
+const trailerSize = 8
+
+// Entry describes a file or directory in an archive. Directories have no segment.
+type Entry struct {
+	Name    string      `json:"name"`    // slash-separated path, see fs.ValidPath
+	Mode    fs.FileMode `json:"mode"`    // file mode and permissions
+	ModTime time.Time   `json:"modtime"` // modification time
+	Size    int64       `json:"size"`    // size of the plaintext
+	Offset  int64       `json:"offset"`  // position of the segment in the archive
+	Length  int64       `json:"length"`  // size of the segment in the archive
+}
+
+// segmentKey derives the key of an entry segment or of the index for n < 0
+func segmentKey(master, salt []byte, n int) []byte {
+	if n < 0 {
+		return keyderivation.HKDF(master, salt, Indexinfo)
+	}
+	return keyderivation.HKDF(master, salt, Entryinfo+strconv.Itoa(n))
+}
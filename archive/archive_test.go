@@ -0,0 +1,96 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package archive
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+func TestArchive(t *testing.T) {
+
+	private := new([32]byte)
+	rand.Read(private[:])
+	large := make([]byte, 10000)
+	rand.Read(large)
+
+	// create an archive with a small chunksize
+	var buf bytes.Buffer
+	aw, err := NewWriter(&buf, keyderivation.Public(private), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, add := range []struct {
+		name string
+		mode fs.FileMode
+		data []byte
+	}{
+		{"docs", fs.ModeDir | 0755, nil},
+		{"docs/readme.txt", 0644, []byte("Hello, World!")},
+		{"docs/empty", 0600, []byte{}},
+		{"large.bin", 0644, large},
+	} {
+		if err = aw.Add(add.name, add.mode, now, bytes.NewReader(add.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if aw.Add("../escape", 0644, now, strings.NewReader("x")) == nil {
+		t.Error("invalid name was accepted")
+	}
+	if aw.Add("large.bin", 0644, now, strings.NewReader("x")) == nil {
+		t.Error("duplicate name was accepted")
+	}
+	if err = aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// open and read a single entry
+	data := buf.Bytes()
+	ar, err := NewReader(bytes.NewReader(data), int64(len(data)), private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Destroy()
+	if len(ar.Entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(ar.Entries))
+	}
+	n, ok := ar.Lookup("large.bin")
+	if !ok || ar.Entries[n].Size != int64(len(large)) {
+		t.Fatal("large.bin not found in index")
+	}
+	r, err := ar.Open(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, large) {
+		t.Error("content of large.bin differs")
+	}
+
+	// segments cannot be swapped
+	e := &ar.Entries[1]
+	r, _ = ar.segment(3, e.Offset, e.Length)
+	if _, err = io.ReadAll(r); err == nil {
+		t.Error("opened segment with the key of another entry")
+	}
+
+	// wrong keys cannot open the index
+	other := new([32]byte)
+	rand.Read(other[:])
+	if _, err = NewReader(bytes.NewReader(data), int64(len(data)), other); err == nil {
+		t.Error("opened archive with the wrong key")
+	}
+
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package archive
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ansemjo/aenker/chunkstream"
+)
+
+// FS gives access to the entries of an archive through the io/fs interfaces, so it can
+// be used with http.FS, template.ParseFS and similar functions. Files are decrypted
+// lazily, only the chunks that are read are decrypted. Directories, which are only
+// implied by the names of other entries, are listed as well.
+type FS struct {
+	ar    *Reader
+	files map[string]*fileInfo
+	dirs  map[string][]fs.DirEntry
+}
+
+// check interface implementations
+var (
+	_ fs.ReadDirFS   = (*FS)(nil)
+	_ fs.StatFS      = (*FS)(nil)
+	_ io.ReadSeeker  = (*file)(nil)
+	_ io.ReaderAt    = (*file)(nil)
+	_ fs.ReadDirFile = (*dir)(nil)
+)
+
+// FS returns a file system with all entries of the archive. It remains usable until
+// the Reader is destroyed.
+func (ar *Reader) FS() *FS {
+
+	fsys := &FS{
+		ar:    ar,
+		files: map[string]*fileInfo{".": {name: ".", n: -1, mode: fs.ModeDir | 0555}},
+		dirs:  make(map[string][]fs.DirEntry),
+	}
+
+	// add all entries and their implied parent directories
+	var add func(name string, info *fileInfo) bool
+	add = func(name string, info *fileInfo) bool {
+		if existing, ok := fsys.files[name]; ok {
+			if existing.n < 0 && info.n >= 0 && existing.IsDir() && info.IsDir() {
+				*existing = *info // explicit directory replaces an implied one
+			}
+			return existing.IsDir()
+		}
+		parent := path.Dir(name)
+		if _, ok := fsys.files[parent]; !ok {
+			if !add(parent, &fileInfo{name: parent, n: -1, mode: fs.ModeDir | 0555}) {
+				return false
+			}
+		} else if !fsys.files[parent].IsDir() {
+			return false
+		}
+		fsys.files[name] = info
+		fsys.dirs[parent] = append(fsys.dirs[parent], info)
+		return info.IsDir()
+	}
+	for n := range ar.Entries {
+		e := &ar.Entries[n]
+		add(e.Name, &fileInfo{name: e.Name, n: n, size: e.Size, mode: e.Mode, modtime: e.ModTime})
+	}
+	for _, entries := range fsys.dirs {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+	return fsys
+
+}
+
+// Open opens a file or directory. Files implement io.ReadSeeker and io.ReaderAt.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	info, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dir{info: info, entries: fsys.dirs[name]}, nil
+	}
+	ra, err := fsys.ar.OpenAt(info.n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{info: info, ra: ra}, nil
+}
+
+// ReadDir returns the sorted entries of a directory.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return append([]fs.DirEntry(nil), fsys.dirs[name]...), nil
+}
+
+// Stat returns information about a file or directory without decrypting it.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// lookup checks a name and returns its information
+func (fsys *FS) lookup(op, name string) (*fileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	info, ok := fsys.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+// fileInfo describes an entry or an implied directory, which has no entry number
+type fileInfo struct {
+	name    string
+	n       int
+	size    int64
+	mode    fs.FileMode
+	modtime time.Time
+}
+
+func (fi *fileInfo) Name() string               { return path.Base(fi.name) }
+func (fi *fileInfo) Size() int64                { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode          { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time         { return fi.modtime }
+func (fi *fileInfo) IsDir() bool                { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}           { return nil }
+func (fi *fileInfo) Type() fs.FileMode          { return fi.mode.Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// file is an opened regular file
+type file struct {
+	info   *fileInfo
+	ra     *chunkstream.ReaderAt
+	offset int64
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (n int, err error) {
+	if f.ra == nil {
+		return 0, fs.ErrClosed
+	}
+	n, err = f.ra.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+func (f *file) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.ra == nil {
+		return 0, fs.ErrClosed
+	}
+	return f.ra.ReadAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.ra == nil {
+		return 0, fs.ErrClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	case io.SeekStart:
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close wipes the key and any decrypted plaintext of the file.
+func (f *file) Close() error {
+	if f.ra == nil {
+		return fs.ErrClosed
+	}
+	f.ra.Destroy()
+	f.ra = nil
+	return nil
+}
+
+// dir is an opened directory
+type dir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return append([]fs.DirEntry(nil), rest...), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	d.offset += count
+	return append([]fs.DirEntry(nil), rest[:count]...), nil
+}
+
+func (d *dir) Close() error {
+	return nil
+}
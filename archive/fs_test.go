@@ -0,0 +1,69 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package archive
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+func TestFS(t *testing.T) {
+
+	private := new([32]byte)
+	rand.Read(private[:])
+	large := make([]byte, 5000)
+	rand.Read(large)
+
+	// archive with an explicit and an implied directory
+	var buf bytes.Buffer
+	aw, err := NewWriter(&buf, keyderivation.Public(private), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	aw.Add("static", fs.ModeDir|0755, now, nil)
+	aw.Add("static/index.html", 0644, now, bytes.NewReader([]byte("<h1>Hello</h1>")))
+	aw.Add("static/img/large.bin", 0644, now, bytes.NewReader(large))
+	aw.Add("empty.txt", 0644, now, bytes.NewReader(nil))
+	if err = aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	ar, err := NewReader(bytes.NewReader(data), int64(len(data)), private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Destroy()
+	fsys := ar.FS()
+
+	if err = fstest.TestFS(fsys, "static/index.html", "static/img/large.bin", "empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// random access across chunk boundaries
+	f, err := fsys.Open("static/img/large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.(io.Seeker).Seek(4000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 500)
+	if _, err = io.ReadFull(f, part); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, large[4000:4500]) {
+		t.Error("wrong content after seeking")
+	}
+
+}
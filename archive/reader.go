@@ -0,0 +1,167 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// Reader gives random access to the entries of an archive.
+type Reader struct {
+	Entries []Entry // in the order they were added
+
+	r      io.ReaderAt
+	master *securebuf.Buffer
+	salt   []byte
+	head   []byte
+	size   int
+	names  map[string]int
+}
+
+// NewReader opens an archive of the given size with a private key. Only the header and
+// the index are read and decrypted.
+func NewReader(r io.ReaderAt, size int64, private *[32]byte) (ar *Reader, err error) {
+
+	// read and check the header
+	header := new(Header)
+	headsize := int64(binary.Size(header))
+	if size < headsize+trailerSize {
+		return nil, errors.New("archive: file too short")
+	}
+	head := make([]byte, headsize)
+	if _, err = r.ReadAt(head, 0); err != nil {
+		return
+	}
+	if err = binary.Read(bytes.NewReader(head), binary.BigEndian, header); err != nil {
+		return
+	}
+	if string(header.Magic[:]) != Magic {
+		return nil, errors.New("archive: unknown magic bytes")
+	}
+	if header.Chunksize < 2 || header.Chunksize > 1<<30 {
+		return nil, errors.New("archive: invalid chunksize")
+	}
+
+	ar = &Reader{
+		r:      r,
+		master: securebuf.Copy(keyderivation.Elliptic(private, &header.Ephemeral, header.Salt[:], Keyinfo)),
+		salt:   header.Salt[:],
+		head:   head,
+		size:   int(header.Chunksize),
+		names:  make(map[string]int),
+	}
+	if err = ar.readIndex(size); err != nil {
+		ar.Destroy()
+		return nil, err
+	}
+	return
+
+}
+
+// Lookup returns the number of the entry with the given name.
+func (ar *Reader) Lookup(name string) (n int, ok bool) {
+	n, ok = ar.names[name]
+	return
+}
+
+// Open returns a Reader for the decrypted content of entry n. Directories are empty.
+// The returned Reader implements chunkstream.Destroyer.
+func (ar *Reader) Open(n int) (io.Reader, error) {
+	if n < 0 || n >= len(ar.Entries) {
+		return nil, errors.New("archive: no such entry")
+	}
+	entry := &ar.Entries[n]
+	if entry.Mode.IsDir() {
+		return nil, fmt.Errorf("archive: %s is a directory", entry.Name)
+	}
+	return ar.segment(n, entry.Offset, entry.Length)
+}
+
+// OpenAt returns a chunkstream.ReaderAt for random access to the content of entry n.
+func (ar *Reader) OpenAt(n int) (*chunkstream.ReaderAt, error) {
+	if n < 0 || n >= len(ar.Entries) {
+		return nil, errors.New("archive: no such entry")
+	}
+	entry := &ar.Entries[n]
+	if entry.Mode.IsDir() {
+		return nil, fmt.Errorf("archive: %s is a directory", entry.Name)
+	}
+	if ar.master.Bytes() == nil {
+		return nil, errors.New("archive: reader is destroyed")
+	}
+	key := segmentKey(ar.master.Bytes(), ar.salt, n)
+	defer securebuf.Wipe(key)
+	ra, err := chunkstream.NewReaderAt(io.NewSectionReader(ar.r, entry.Offset, entry.Length), entry.Length, key, ar.head, ar.size, chunkstream.UniqueKey())
+	if err == nil && ra.Size() != entry.Size {
+		ra.Destroy()
+		return nil, fmt.Errorf("archive: size of %s does not match the index", entry.Name)
+	}
+	return ra, err
+}
+
+// Destroy wipes the master key. No more entries can be opened afterwards.
+func (ar *Reader) Destroy() {
+	ar.master.Destroy()
+}
+
+// segment opens the chunkstream of entry n, or the index for n < 0, at the given position
+func (ar *Reader) segment(n int, offset, length int64) (io.Reader, error) {
+	if ar.master.Bytes() == nil {
+		return nil, errors.New("archive: reader is destroyed")
+	}
+	key := segmentKey(ar.master.Bytes(), ar.salt, n)
+	defer securebuf.Wipe(key)
+	return chunkstream.NewReader(io.NewSectionReader(ar.r, offset, length), key, ar.head, ar.size, chunkstream.UniqueKey())
+}
+
+// readIndex locates the index with the trailer, decrypts it and checks all entries
+func (ar *Reader) readIndex(size int64) (err error) {
+
+	trailer := make([]byte, trailerSize)
+	if _, err = ar.r.ReadAt(trailer, size-trailerSize); err != nil {
+		return
+	}
+	offset := int64(binary.BigEndian.Uint64(trailer))
+	if offset < int64(len(ar.head)) || offset > size-trailerSize {
+		return errors.New("archive: invalid index offset")
+	}
+
+	reader, err := ar.segment(-1, offset, size-trailerSize-offset)
+	if err != nil {
+		return
+	}
+	index, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("archive: index: %s", err)
+	}
+	if err = json.Unmarshal(index, &ar.Entries); err != nil {
+		return fmt.Errorf("archive: index: %s", err)
+	}
+
+	// the index is authenticated but check it anyway
+	for n, entry := range ar.Entries {
+		if !fs.ValidPath(entry.Name) || entry.Name == "." {
+			return fmt.Errorf("archive: invalid name %q", entry.Name)
+		}
+		if _, dup := ar.names[entry.Name]; dup {
+			return fmt.Errorf("archive: duplicate name %q", entry.Name)
+		}
+		if entry.Offset < int64(len(ar.head)) || entry.Length < 0 || entry.Offset+entry.Length > offset {
+			return fmt.Errorf("archive: invalid position of %q", entry.Name)
+		}
+		ar.names[entry.Name] = n
+	}
+	return
+
+}
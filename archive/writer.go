@@ -0,0 +1,172 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package archive
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// Writer creates an archive for a Curve25519 public key.
+type Writer struct {
+	w       *countWriter
+	master  *securebuf.Buffer
+	salt    []byte
+	head    []byte
+	size    int
+	entries []Entry
+	names   map[string]bool
+	err     error
+}
+
+// NewWriter writes the header of a new archive for the public key with the given
+// chunksize. Add entries and call Close to write the index.
+func NewWriter(w io.Writer, public *[32]byte, chunksize int) (aw *Writer, err error) {
+
+	if chunksize < 2 || chunksize > 1<<30 {
+		return nil, errors.New("invalid chunksize")
+	}
+
+	// random ephemeral key and salt
+	header := &Header{Chunksize: uint32(chunksize)}
+	copy(header.Magic[:], Magic)
+	ephemeral := securebuf.New(32)
+	defer ephemeral.Destroy()
+	if _, err = io.ReadFull(rand.Reader, ephemeral.Bytes()); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(rand.Reader, header.Salt[:]); err != nil {
+		return
+	}
+	header.Ephemeral = *keyderivation.Public(ephemeral.Key())
+
+	var buf bytes.Buffer
+	if err = binary.Write(&buf, binary.BigEndian, header); err != nil {
+		return
+	}
+	aw = &Writer{
+		w:      &countWriter{w: w},
+		master: securebuf.Copy(keyderivation.Elliptic(ephemeral.Key(), public, header.Salt[:], Keyinfo)),
+		salt:   header.Salt[:],
+		head:   buf.Bytes(),
+		size:   chunksize,
+		names:  make(map[string]bool),
+	}
+	if _, err = aw.w.Write(aw.head); err != nil {
+		aw.Destroy()
+		return nil, err
+	}
+	return
+
+}
+
+// Add appends an entry and encrypts the content read from r, which may be nil for
+// directories. The name must be a valid slash-separated path and unique in the archive.
+func (aw *Writer) Add(name string, mode fs.FileMode, modtime time.Time, r io.Reader) (err error) {
+
+	if aw.err != nil {
+		return aw.err
+	}
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	if aw.names[name] {
+		return fmt.Errorf("duplicate name %q", name)
+	}
+	entry := Entry{Name: name, Mode: mode, ModTime: modtime.UTC(), Offset: aw.w.n}
+
+	// directories have no content
+	if mode.IsDir() {
+		aw.entries = append(aw.entries, entry)
+		aw.names[name] = true
+		return
+	}
+	if !mode.IsRegular() {
+		return fmt.Errorf("%s: unsupported file type %s", name, mode.Type())
+	}
+
+	// encrypt content in a new segment
+	entry.Size, err = aw.segment(len(aw.entries), r)
+	if err != nil {
+		aw.err = err
+		return
+	}
+	entry.Length = aw.w.n - entry.Offset
+	aw.entries = append(aw.entries, entry)
+	aw.names[name] = true
+	return
+
+}
+
+// Close writes the encrypted index and the trailer. It does not close the underlying writer.
+func (aw *Writer) Close() (err error) {
+
+	if aw.err != nil {
+		return aw.err
+	}
+	defer aw.Destroy()
+
+	index, err := json.Marshal(aw.entries)
+	if err != nil {
+		return
+	}
+	offset := aw.w.n
+	if _, err = aw.segment(-1, bytes.NewReader(index)); err != nil {
+		return
+	}
+	trailer := make([]byte, trailerSize)
+	binary.BigEndian.PutUint64(trailer, uint64(offset))
+	_, err = aw.w.Write(trailer)
+	return
+
+}
+
+// Destroy wipes the master key. The archive is incomplete unless Close was called.
+func (aw *Writer) Destroy() {
+	aw.master.Destroy()
+	if aw.err == nil {
+		aw.err = errors.New("archive: writer is closed")
+	}
+}
+
+// segment encrypts all data from r with the key of entry n and returns its length. The
+// key is unique to the entry, so no stream ID is needed.
+func (aw *Writer) segment(n int, r io.Reader) (size int64, err error) {
+	key := segmentKey(aw.master.Bytes(), aw.salt, n)
+	cw, err := chunkstream.NewWriter(aw.w, key, aw.head, aw.size, chunkstream.UniqueKey())
+	securebuf.Wipe(key)
+	if err != nil {
+		return
+	}
+	if r != nil {
+		if size, err = io.Copy(cw, r); err != nil {
+			cw.(chunkstream.Destroyer).Destroy()
+			return
+		}
+	}
+	return size, cw.Close()
+}
+
+// countWriter counts the bytes written
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package channel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+func newKey() *[32]byte {
+	key := new([32]byte)
+	rand.Read(key[:])
+	return key
+}
+
+// handshake runs both sides over a TCP loopback connection
+func handshake(t *testing.T, client, server *Config) (c, s *Conn, cerr, serr error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			serr = err
+			return
+		}
+		if s, serr = Server(conn, server); serr != nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, cerr = Client(conn, client); cerr != nil {
+		conn.Close()
+	}
+	<-done
+	return
+}
+
+func TestHandshake(t *testing.T) {
+
+	alice, bob, eve := newKey(), newKey(), newKey()
+	bobpub := keyderivation.Public(bob)
+
+	// anonymous initiator
+	c, s, cerr, serr := handshake(t, &Config{Remote: bobpub}, &Config{Static: bob})
+	if cerr != nil || serr != nil {
+		t.Fatalf("NK: %v, %v", cerr, serr)
+	}
+	if s.RemoteStatic() != nil || !bytes.Equal(c.RemoteStatic()[:], bobpub[:]) {
+		t.Error("NK: wrong remote static keys")
+	}
+	c.Close()
+	s.Close()
+
+	// authenticated initiator in the list of peers
+	alicepub := keyderivation.Public(alice)
+	c, s, cerr, serr = handshake(t,
+		&Config{Static: alice, Remote: bobpub},
+		&Config{Static: bob, Peers: []*[32]byte{alicepub}})
+	if cerr != nil || serr != nil {
+		t.Fatalf("KK: %v, %v", cerr, serr)
+	}
+	if !bytes.Equal(s.RemoteStatic()[:], alicepub[:]) {
+		t.Error("KK: wrong remote static key")
+	}
+	c.Close()
+	s.Close()
+
+	// anonymous or unknown initiators are rejected if peers are given
+	for _, static := range []*[32]byte{nil, eve} {
+		_, _, cerr, serr = handshake(t,
+			&Config{Static: static, Remote: bobpub},
+			&Config{Static: bob, Peers: []*[32]byte{alicepub}})
+		if cerr == nil || serr == nil {
+			t.Error("initiator should be rejected")
+		}
+	}
+
+	// wrong responder key
+	_, _, cerr, serr = handshake(t,
+		&Config{Static: alice, Remote: keyderivation.Public(eve)},
+		&Config{Static: bob})
+	if cerr == nil || serr == nil {
+		t.Error("handshake with the wrong responder key should fail")
+	}
+
+}
+
+func TestConn(t *testing.T) {
+
+	bob := newKey()
+	c, s, cerr, serr := handshake(t, &Config{Static: newKey(), Remote: keyderivation.Public(bob)}, &Config{Static: bob})
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	defer s.Close()
+
+	// data in both directions, larger than a single record
+	plain := make([]byte, 3*MaxRecord+123)
+	rand.Read(plain)
+	go func() {
+		c.Write(plain)
+		c.CloseWrite()
+	}()
+	received, err := io.ReadAll(s)
+	if err != nil || !bytes.Equal(received, plain) {
+		t.Fatalf("received %d bytes: %v", len(received), err)
+	}
+	go func() {
+		s.Write([]byte("hello"))
+		s.Close()
+	}()
+	received, err = io.ReadAll(c)
+	if err != nil || string(received) != "hello" {
+		t.Fatalf("received %q: %v", received, err)
+	}
+	if _, err = c.Write([]byte("more")); err == nil {
+		t.Error("write after CloseWrite should fail")
+	}
+
+	// both keys are wiped by Close
+	c.Close()
+	if _, err = c.Read(make([]byte, 1)); err == nil || err == io.EOF {
+		t.Errorf("read after Close: %v", err)
+	}
+
+}
+
+func TestTruncated(t *testing.T) {
+
+	bob := newKey()
+	c, s, cerr, serr := handshake(t, &Config{Remote: keyderivation.Public(bob)}, &Config{Static: bob})
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	defer s.Close()
+
+	// close the underlying connection without a final record
+	c.Write([]byte("partial"))
+	c.conn.(net.Conn).Close()
+	received, err := io.ReadAll(s)
+	if err != ErrTruncated || string(received) != "partial" {
+		t.Errorf("received %q: %v", received, err)
+	}
+
+}
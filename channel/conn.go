@@ -0,0 +1,204 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package channel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// MaxRecord is the maximum length of plaintext in a single record. Larger writes are
+// split into multiple records.
+const MaxRecord = 16384
+
+// ErrTruncated is returned when the connection ends without a final record.
+var ErrTruncated = chunkstream.ErrTruncated
+
+// Conn is an encrypted connection. Every Write is sent immediately in one or more
+// records, so it is suitable for interactive use. Read returns io.EOF only after the
+// peer sent a final record with CloseWrite or Close.
+type Conn struct {
+	conn   io.ReadWriter
+	remote *[32]byte
+
+	rmu sync.Mutex
+	r   io.Reader
+
+	wmu    sync.Mutex
+	w      chunkstream.FlushWriteCloser
+	closed bool
+}
+
+// newConn derives both direction keys from the concatenated handshake secrets. The
+// records are framed chunks of chunkstream without additional associated data. The
+// keys are unique to the connection, so no stream ID is needed.
+func newConn(conn io.ReadWriter, secrets [][]byte, salt []byte, sendinfo, recvinfo string) (c *Conn, err error) {
+
+	ikm := securebuf.New(32 * len(secrets))
+	defer ikm.Destroy()
+	for i, s := range secrets {
+		copy(ikm.Bytes()[32*i:], s)
+		securebuf.Wipe(s)
+	}
+
+	c = &Conn{conn: conn}
+	sendkey := keyderivation.HKDF(ikm.Bytes(), salt, sendinfo)
+	defer securebuf.Wipe(sendkey)
+	if c.w, err = chunkstream.NewFramedWriter(conn, sendkey, nil, MaxRecord+1, chunkstream.UniqueKey()); err != nil {
+		return nil, err
+	}
+	recvkey := keyderivation.HKDF(ikm.Bytes(), salt, recvinfo)
+	defer securebuf.Wipe(recvkey)
+	if c.r, err = chunkstream.NewFramedReader(conn, recvkey, nil, MaxRecord+1, chunkstream.UniqueKey()); err != nil {
+		c.w.(chunkstream.Destroyer).Destroy()
+		return nil, err
+	}
+	return
+
+}
+
+// confirm exchanges records with the transcript hash to make sure both sides derived the
+// same keys. The initiator sends first, so this works on unbuffered connections like
+// net.Pipe, too.
+func (c *Conn) confirm(transcript []byte, initiator bool) (err error) {
+	if initiator {
+		if _, err = c.Write(transcript); err != nil {
+			return
+		}
+	}
+	peer := make([]byte, len(transcript))
+	if _, err = io.ReadFull(c, peer); err != nil || !bytes.Equal(peer, transcript) {
+		return errHandshake
+	}
+	if !initiator {
+		_, err = c.Write(transcript)
+	}
+	return
+}
+
+// RemoteStatic returns the static public key of the peer or nil for an anonymous
+// initiator.
+func (c *Conn) RemoteStatic() *[32]byte {
+	return c.remote
+}
+
+// Read reads decrypted data from the connection.
+func (c *Conn) Read(p []byte) (n int, err error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	return c.r.Read(p)
+}
+
+// Write encrypts and sends data immediately.
+func (c *Conn) Write(p []byte) (n int, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if n, err = c.w.Write(p); err != nil {
+		return
+	}
+	return n, c.w.Flush()
+}
+
+// CloseWrite sends the final record, after which the peer reads io.EOF. If the
+// underlying connection supports it, its writing side is shut down as well.
+func (c *Conn) CloseWrite() (err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if err = c.w.Close(); err != nil {
+		return
+	}
+	if cw, ok := c.conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return
+}
+
+// Close sends the final record if that did not happen yet, closes the underlying
+// connection and wipes the keys of both directions. The receiving key is wiped as soon
+// as a pending Read returns.
+func (c *Conn) Close() (err error) {
+	err = c.CloseWrite()
+	if cl, ok := c.conn.(io.Closer); ok {
+		if e := cl.Close(); err == nil {
+			err = e
+		}
+	}
+	c.destroy()
+	return
+}
+
+// destroy wipes the keys of both directions, the receiving key only once a pending
+// Read returned
+func (c *Conn) destroy() {
+	c.wmu.Lock()
+	c.w.(chunkstream.Destroyer).Destroy()
+	c.wmu.Unlock()
+	destroy := func() {
+		c.r.(chunkstream.Destroyer).Destroy()
+		c.rmu.Unlock()
+	}
+	if c.rmu.TryLock() {
+		destroy()
+	} else {
+		go func() {
+			c.rmu.Lock()
+			destroy()
+		}()
+	}
+}
+
+// LocalAddr returns the local address of an underlying net.Conn or nil.
+func (c *Conn) LocalAddr() net.Addr {
+	if nc, ok := c.conn.(net.Conn); ok {
+		return nc.LocalAddr()
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote address of an underlying net.Conn or nil.
+func (c *Conn) RemoteAddr() net.Addr {
+	if nc, ok := c.conn.(net.Conn); ok {
+		return nc.RemoteAddr()
+	}
+	return nil
+}
+
+// SetDeadline sets the deadlines of an underlying net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if nc, ok := c.conn.(net.Conn); ok {
+		return nc.SetDeadline(t)
+	}
+	return errors.New("channel: deadlines not supported")
+}
+
+// SetReadDeadline sets the read deadline of an underlying net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if nc, ok := c.conn.(net.Conn); ok {
+		return nc.SetReadDeadline(t)
+	}
+	return errors.New("channel: deadlines not supported")
+}
+
+// SetWriteDeadline sets the write deadline of an underlying net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if nc, ok := c.conn.(net.Conn); ok {
+		return nc.SetWriteDeadline(t)
+	}
+	return errors.New("channel: deadlines not supported")
+}
+
+// make sure the interface is implemented
+var _ net.Conn = (*Conn)(nil)
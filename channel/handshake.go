@@ -0,0 +1,289 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package channel provides an encrypted and authenticated channel over any net.Conn,
+// e.g. a TCP connection between two hosts, using the same Curve25519 keys as ae.
+//
+// A handshake similar to the NK and KK patterns of the Noise protocol framework derives
+// a separate key for each direction. The initiator always knows the static public key
+// of the responder. If it has a static key itself, it is sent encrypted in the first
+// message and mixed into the keys as well, so both sides are authenticated (KK).
+// Otherwise only the responder is authenticated (NK).
+//
+// The handshake messages are:
+//  -> Magic | mode | ephemeral | [sealed static]
+//  <- ephemeral
+//
+// Afterwards, the initiator and then the responder send a record with the hash of both
+// messages to confirm the keys. The records are the framed chunks of chunkstream, so the
+// end of the stream is authenticated.
+package channel
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"io"
+
+	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Magic starts the first handshake message. Similarly to ae.Magic:
+//  >>> hashlib.blake2b(b'aenker pipe').digest()[:2]
+//  b'\xc3\xcf'
+const Magic = "aenker\xc3\xcf"
+
+// Handshake modes in the first message.
+const (
+	ModeNK byte = 1 // anonymous initiator
+	ModeKK byte = 2 // initiator with a static key
+)
+
+// Context info strings for HKDF in the handshake.
+const (
+	Staticinfo    = "aenker pipe static"    // key to seal the initiator's static key
+	Initiatorinfo = "aenker pipe initiator" // key from initiator to responder
+	Responderinfo = "aenker pipe responder" // key from responder to initiator
+)
+
+// Config holds the keys for a handshake.
+type Config struct {
+	// Static is your own private key. It is required for the responder and optional
+	// for the initiator, which is anonymous without it.
+	Static *[32]byte
+
+	// Remote is the static public key of the responder and required for the initiator.
+	Remote *[32]byte
+
+	// Peers are the static public keys of initiators, which the responder accepts. If it
+	// is empty, any initiator is accepted, including anonymous ones.
+	Peers []*[32]byte
+}
+
+// errHandshake hides the reason for a failed handshake from the peer
+var errHandshake = errors.New("channel: handshake failed")
+
+// sealedStatic is the length of the sealed static key in the first message
+const sealedStatic = 32 + 16
+
+// Client performs the handshake as the initiator on conn and returns the encrypted
+// connection after the keys were confirmed by both sides.
+func Client(conn io.ReadWriter, config *Config) (c *Conn, err error) {
+
+	if config.Remote == nil {
+		return nil, errors.New("channel: the responder's public key is required")
+	}
+
+	ephemeral, public, err := newEphemeral()
+	if err != nil {
+		return
+	}
+	defer ephemeral.Destroy()
+
+	// first message with the optionally sealed static key
+	mode := ModeNK
+	if config.Static != nil {
+		mode = ModeKK
+	}
+	msg := append(append([]byte(Magic), mode), public[:]...)
+	es, err := dh(ephemeral.Key(), config.Remote)
+	if err != nil {
+		return
+	}
+	defer securebuf.Wipe(es)
+	if mode == ModeKK {
+		aead, err := staticAEAD(es, msg)
+		if err != nil {
+			return nil, err
+		}
+		msg = aead.Seal(msg, make([]byte, aead.NonceSize()), keyderivation.Public(config.Static)[:], msg)
+	}
+	if _, err = conn.Write(msg); err != nil {
+		return
+	}
+
+	// read the responder's ephemeral key, the connection is closed if it rejected us
+	remote := new([32]byte)
+	if _, err = io.ReadFull(conn, remote[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errHandshake
+		}
+		return
+	}
+	secrets := [][]byte{es}
+	ee, err := dh(ephemeral.Key(), remote)
+	if err != nil {
+		return
+	}
+	secrets = append(secrets, ee)
+	if mode == ModeKK {
+		ss, err := dh(config.Static, config.Remote)
+		if err != nil {
+			return nil, err
+		}
+		se, err := dh(config.Static, remote)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, ss, se)
+	}
+
+	hash := transcript(msg, remote[:])
+	c, err = newConn(conn, secrets, hash, Initiatorinfo, Responderinfo)
+	if err != nil {
+		return
+	}
+	c.remote = config.Remote
+	if err = c.confirm(hash, true); err != nil {
+		c.destroy()
+		return nil, err
+	}
+	return c, nil
+
+}
+
+// Server performs the handshake as the responder on conn and returns the encrypted
+// connection after the keys were confirmed by both sides.
+func Server(conn io.ReadWriter, config *Config) (c *Conn, err error) {
+
+	if config.Static == nil {
+		return nil, errors.New("channel: the responder's private key is required")
+	}
+
+	// read the first message
+	msg := make([]byte, len(Magic)+1+32)
+	if _, err = io.ReadFull(conn, msg); err != nil {
+		return
+	}
+	if string(msg[:len(Magic)]) != Magic {
+		return nil, errors.New("channel: unknown magic bytes")
+	}
+	mode := msg[len(Magic)]
+	if mode != ModeNK && mode != ModeKK {
+		return nil, errors.New("channel: unknown handshake mode")
+	}
+	remote := new([32]byte)
+	copy(remote[:], msg[len(Magic)+1:])
+	es, err := dh(config.Static, remote)
+	if err != nil {
+		return
+	}
+	defer securebuf.Wipe(es)
+
+	// open and check the initiator's static key
+	var static *[32]byte
+	if mode == ModeKK {
+		sealed := make([]byte, sealedStatic)
+		if _, err = io.ReadFull(conn, sealed); err != nil {
+			return
+		}
+		aead, err := staticAEAD(es, msg)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed, msg)
+		if err != nil {
+			return nil, errHandshake
+		}
+		static = new([32]byte)
+		copy(static[:], plain)
+		msg = append(msg, sealed...)
+	}
+	if len(config.Peers) > 0 && !allowed(static, config.Peers) {
+		return nil, errors.New("channel: initiator is not an allowed peer")
+	}
+
+	// reply with an ephemeral key
+	ephemeral, public, err := newEphemeral()
+	if err != nil {
+		return
+	}
+	defer ephemeral.Destroy()
+	if _, err = conn.Write(public[:]); err != nil {
+		return
+	}
+	secrets := [][]byte{es}
+	ee, err := dh(ephemeral.Key(), remote)
+	if err != nil {
+		return
+	}
+	secrets = append(secrets, ee)
+	if mode == ModeKK {
+		ss, err := dh(config.Static, static)
+		if err != nil {
+			return nil, err
+		}
+		se, err := dh(ephemeral.Key(), static)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, ss, se)
+	}
+
+	hash := transcript(msg, public[:])
+	c, err = newConn(conn, secrets, hash, Responderinfo, Initiatorinfo)
+	if err != nil {
+		return
+	}
+	c.remote = static
+	if err = c.confirm(hash, false); err != nil {
+		c.destroy()
+		return nil, err
+	}
+	return c, nil
+
+}
+
+// newEphemeral generates a random ephemeral keypair
+func newEphemeral() (private *securebuf.Buffer, public *[32]byte, err error) {
+	private = securebuf.New(32)
+	if _, err = io.ReadFull(rand.Reader, private.Bytes()); err != nil {
+		private.Destroy()
+		return nil, nil, err
+	}
+	return private, keyderivation.Public(private.Key()), nil
+}
+
+// dh performs a Diffie-Hellman exchange and rejects low-order points
+func dh(private, public *[32]byte) ([]byte, error) {
+	shared := new([32]byte)
+	curve25519.ScalarMult(shared, private, public)
+	if subtle.ConstantTimeCompare(shared[:], make([]byte, 32)) == 1 {
+		return nil, errHandshake
+	}
+	return shared[:], nil
+}
+
+// staticAEAD derives the cipher to seal the initiator's static key
+func staticAEAD(es, msg []byte) (cipher.AEAD, error) {
+	key := keyderivation.HKDF(es, msg, Staticinfo)
+	defer securebuf.Wipe(key)
+	return chunkstream.NewAEAD(key)
+}
+
+// transcript hashes both handshake messages to bind the keys to them
+func transcript(first, second []byte) []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write(first)
+	h.Write(second)
+	return h.Sum(nil)
+}
+
+// allowed checks if a static key is in a list of peers
+func allowed(static *[32]byte, peers []*[32]byte) bool {
+	if static == nil {
+		return false
+	}
+	for _, peer := range peers {
+		if bytes.Equal(static[:], peer[:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,13 +4,17 @@
 // Package chunkstream provides chunked readers and writers; You probably want the ae package.
 //
 // You probably want ae.NewWriter() and ae.NewReader() rather than these chunkStreamer
-// wrappers. The AEAD is used with a simple nonce counter, so you MUST to provide a
-// unique key if you use this package directly.
+// wrappers. The AEAD is used with a simple nonce counter, so every stream is bound to a
+// stream ID, which is random by default. Pass UniqueKey() only if you provide a unique
+// key for every stream yourself.
 package chunkstream
 
 import (
 	"crypto/cipher"
+	"errors"
 
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
@@ -29,11 +33,53 @@ var NewAEAD func([]byte) (cipher.AEAD, error) = chacha20poly1305.New
 func init() {
 }
 
+// Destroyer is implemented by the Readers and Writers of this package. Destroy wipes
+// the key and any buffered plaintext, after which the Reader or Writer returns errors.
+// Writers are destroyed automatically on Close and Readers after the final chunk or
+// an error. Note that the AEAD implementation may hold its own copy of the key, which
+// is dropped but cannot be wiped.
+type Destroyer interface {
+	Destroy()
+}
+
+// errDestroyed is returned by Readers and Writers after Destroy
+var errDestroyed = errors.New("chunkstream: destroyed")
+
+// errExhausted is returned before the nonce counter would wrap around
+var errExhausted = errors.New("chunkstream: nonce counter exhausted")
+
+// RekeyInterval is the number of chunks after which the key is replaced by a new one,
+// which is derived from the previous key with HKDF and the info string Ratchetinfo. The
+// previous key is wiped, so a key that is compromised later cannot decrypt the chunks
+// before it. With the default chunksize of the ae package, every key encrypts about 2 GB.
+//
+// Like the chunksize, the same value must be used for reading and writing. Streams that
+// were written without rekeying are detected by readers at the first boundary. Assign
+// zero before calling NewWriter to disable rekeying.
+var RekeyInterval uint64 = 1 << 20
+
+// Ratchetinfo is the HKDF info string to derive the next key in a stream.
+const Ratchetinfo = "aenker chunkstream ratchet"
+
+// ratchet is the rekeying state of a chunkCipherer
+type ratchet int
+
+const (
+	ratchetUnknown ratchet = iota // readers decide at the first boundary
+	ratchetOn                     // keys are replaced every interval
+	ratchetOff                    // older streams without rekeying
+)
+
 // chunkCipherer is the cryptographic core of a chunked Reader or Writer.
 type chunkCipherer struct {
-	cipher cipher.AEAD
-	ctr    *nonceCounter
-	info   []byte
+	cipher   cipher.AEAD
+	key      *securebuf.Buffer // key of the current epoch
+	epoch    uint64
+	base     *securebuf.Buffer // initial key, only kept for random access
+	ratchet  ratchet
+	interval uint64
+	ctr      *nonceCounter
+	info     []byte
 }
 
 // NewChunkCipherer instantiates a new AEAD cipher and returns it in a ChunkCipherer
@@ -41,25 +87,164 @@ type chunkCipherer struct {
 // that will be incremented on every call to Seal() or Open().
 // A ChunkCipherer should only ever be used to only seal or only open, as both functions
 // share the same NonceCounter.
-func newChunkCipherer(key, info []byte) (*chunkCipherer, error) {
+// The key and info are derived from the stream ID first.
+func newChunkCipherer(key, info []byte, s *stream) (*chunkCipherer, error) {
+
+	derived, info := s.derive(key, info)
+	if !s.unique {
+		defer securebuf.Wipe(derived)
+	}
+	cc := &chunkCipherer{info: info, interval: RekeyInterval}
+	if err := cc.install(securebuf.Copy(append([]byte(nil), derived...)), 0); err != nil {
+		return nil, err
+	}
+	cc.ctr = newNonceCounter(cc.cipher.NonceSize())
+	return cc, nil
+
+}
 
-	cc := &chunkCipherer{info: info}
-	var err error
+func (cc *chunkCipherer) Seal(plain []byte) (ciphertext []byte, err error) {
+	return cc.sealAD(plain[:0], plain, cc.info)
+}
+
+func (cc *chunkCipherer) Open(ciphertext []byte) (plaintext []byte, err error) {
+	return cc.openAD(ciphertext[:0], ciphertext, cc.info)
+}
 
-	cc.cipher, err = NewAEAD(key)
+// sealAD encrypts a chunk with the next nonce and the given associated data.
+func (cc *chunkCipherer) sealAD(dst, plain, ad []byte) ([]byte, error) {
+	if cc.boundary(cc.ctr.ctr) {
+		if err := cc.seek(cc.epoch + 1); err != nil {
+			return nil, err
+		}
+	}
+	nonce, err := cc.ctr.Next()
 	if err != nil {
 		return nil, err
 	}
+	return cc.cipher.Seal(dst, nonce, plain, ad), nil
+}
 
-	cc.ctr = newNonceCounter(cc.cipher.NonceSize())
-	return cc, err
+// openAD decrypts a chunk with the next nonce and the given associated data.
+func (cc *chunkCipherer) openAD(dst, ciphertext, ad []byte) (plain []byte, err error) {
+	index := cc.ctr.ctr
+	nonce, err := cc.ctr.Next()
+	if err != nil {
+		return
+	}
+	if cc.boundary(index) {
+		if cc.ratchet == ratchetUnknown {
+			return cc.trial(dst, ciphertext, nonce, ad, index/cc.interval)
+		}
+		if err = cc.seek(cc.epoch + 1); err != nil {
+			return
+		}
+	}
+	return cc.cipher.Open(dst, nonce, ciphertext, ad)
+}
 
+// OpenAt opens the chunk with the given index without changing the NonceCounter. The
+// initial key is kept to derive the key of any epoch, so there is no forward secrecy.
+func (cc *chunkCipherer) OpenAt(ciphertext []byte, index uint64) (plaintext []byte, err error) {
+	return cc.openAtAD(ciphertext, index, cc.info)
 }
 
-func (cc *chunkCipherer) Seal(plain []byte) (ciphertext []byte) {
-	return cc.cipher.Seal(plain[:0], cc.ctr.Next(), plain, cc.info)
+// openAtAD works like OpenAt with different associated data, e.g. for framed chunks
+func (cc *chunkCipherer) openAtAD(ciphertext []byte, index uint64, ad []byte) (plaintext []byte, err error) {
+	if cc.cipher == nil {
+		return nil, errDestroyed
+	}
+	if cc.base == nil {
+		cc.base = securebuf.Copy(append([]byte(nil), cc.key.Bytes()...))
+	}
+	nonce := cc.ctr.At(index)
+	epoch := uint64(0)
+	if cc.interval > 0 && cc.ratchet != ratchetOff {
+		epoch = index / cc.interval
+	}
+	if epoch > 0 && cc.ratchet == ratchetUnknown {
+		return cc.trial(ciphertext[:0], ciphertext, nonce, ad, epoch)
+	}
+	if err = cc.seek(epoch); err != nil {
+		return
+	}
+	return cc.cipher.Open(ciphertext[:0], nonce, ciphertext, ad)
 }
 
-func (cc *chunkCipherer) Open(ciphertext []byte) (plaintext []byte, err error) {
-	return cc.cipher.Open(ciphertext[:0], cc.ctr.Next(), ciphertext, cc.info)
+// Destroy wipes the keys and drops the AEAD.
+func (cc *chunkCipherer) Destroy() {
+	cc.key.Destroy()
+	cc.base.Destroy()
+	cc.cipher = nil
+}
+
+// boundary checks if the key must be replaced before the chunk with this index
+func (cc *chunkCipherer) boundary(index uint64) bool {
+	return cc.interval > 0 && cc.ratchet != ratchetOff && index > 0 && index%cc.interval == 0
+}
+
+// install replaces the current key and cipher and wipes the previous key
+func (cc *chunkCipherer) install(key *securebuf.Buffer, epoch uint64) (err error) {
+	aead, err := NewAEAD(key.Bytes())
+	if err != nil {
+		key.Destroy()
+		return
+	}
+	cc.key.Destroy()
+	cc.key, cc.cipher, cc.epoch = key, aead, epoch
+	return
+}
+
+// derive returns the key of a later epoch without changing the current one
+func (cc *chunkCipherer) derive(epoch uint64) *securebuf.Buffer {
+	key := securebuf.Copy(append([]byte(nil), cc.key.Bytes()...))
+	for e := cc.epoch; e < epoch; e++ {
+		next := securebuf.Copy(keyderivation.HKDF(key.Bytes(), nil, Ratchetinfo))
+		key.Destroy()
+		key = next
+	}
+	return key
+}
+
+// seek installs the key of any epoch, starting over from the initial key if needed
+func (cc *chunkCipherer) seek(epoch uint64) (err error) {
+	if cc.cipher == nil {
+		return errDestroyed
+	}
+	if epoch < cc.epoch {
+		if err = cc.install(securebuf.Copy(append([]byte(nil), cc.base.Bytes()...)), 0); err != nil {
+			return
+		}
+	}
+	if epoch > cc.epoch {
+		err = cc.install(cc.derive(epoch), epoch)
+	}
+	return
+}
+
+// trial decides if a stream uses rekeying by opening a chunk with the key of its epoch
+// and otherwise with the initial key, which is still current before the decision
+func (cc *chunkCipherer) trial(dst, ciphertext, nonce, ad []byte, epoch uint64) (plain []byte, err error) {
+	if cc.cipher == nil {
+		return nil, errDestroyed
+	}
+	key := cc.derive(epoch)
+	aead, err := NewAEAD(key.Bytes())
+	if err != nil {
+		key.Destroy()
+		return
+	}
+	// a failed Open may overwrite its destination, so try on a copy first
+	if plain, err = aead.Open(nil, nonce, ciphertext, ad); err == nil {
+		cc.ratchet = ratchetOn
+		if err = cc.install(key, epoch); err != nil {
+			return
+		}
+		return append(dst, plain...), nil
+	}
+	key.Destroy()
+	if plain, err = cc.cipher.Open(dst, nonce, ciphertext, ad); err == nil {
+		cc.ratchet = ratchetOff
+	}
+	return
 }
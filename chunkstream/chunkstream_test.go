@@ -0,0 +1,514 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package chunkstream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSmallReads(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 1000)
+	rand.Read(plain)
+
+	// the final chunk is larger than any single read
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, nil, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf, key, nil, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, plain) {
+		t.Errorf("read %d of %d bytes", len(out), len(plain))
+	}
+
+}
+
+func TestReaderAt(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 1000)
+	rand.Read(plain)
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key, nil, 100)
+	w.Write(plain)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ct := buf.Bytes()
+
+	ra, err := NewReaderAt(bytes.NewReader(ct), int64(len(ct)), key, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ra.Size() != int64(len(plain)) {
+		t.Fatalf("wrong size %d", ra.Size())
+	}
+	p := make([]byte, 300)
+	if _, err = ra.ReadAt(p, 250); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, plain[250:550]) {
+		t.Error("wrong plaintext at offset")
+	}
+
+	// dropping whole chunks is detected
+	short := ct[:len(ct)-(100+16)]
+	if _, err = NewReaderAt(bytes.NewReader(short), int64(len(short)), key, nil, 100); err == nil {
+		t.Error("truncated ciphertext was accepted")
+	}
+
+	// reads fail after Destroy
+	ra.Destroy()
+	if _, err = ra.ReadAt(p, 250); err != errDestroyed {
+		t.Errorf("expected errDestroyed, got %v", err)
+	}
+
+}
+
+func TestFramed(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 1000)
+	rand.Read(plain)
+
+	var buf bytes.Buffer
+	w, err := NewFramedWriter(&buf, key, []byte("info"), 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flushed data can be read before the writer is closed
+	w.Write(plain[:10])
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewFramedReader(bytes.NewReader(buf.Bytes()), key, []byte("info"), 256)
+	head := make([]byte, 10)
+	if _, err = io.ReadFull(r, head); err != nil || !bytes.Equal(head, plain[:10]) {
+		t.Fatalf("flushed chunk: %v", err)
+	}
+	if _, err = r.Read(head); err != ErrTruncated {
+		t.Errorf("expected truncation, got %v", err)
+	}
+
+	// empty flushes are skipped by readers
+	w.Flush()
+	w.Write(plain[10:])
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, open := range []func(io.Reader, []byte, []byte, int, ...Option) (io.Reader, error){NewFramedReader, NewAnyReader} {
+		r, _ = open(bytes.NewReader(buf.Bytes()), key, []byte("info"), 256)
+		out, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(out, plain) {
+			t.Errorf("framed: %d bytes, %v", len(out), err)
+		}
+	}
+
+	// the length is authenticated
+	tampered := append([]byte(nil), buf.Bytes()...)
+	tampered[IDSize+3]--
+	r, _ = NewFramedReader(bytes.NewReader(tampered), key, []byte("info"), 256)
+	if _, err = io.ReadAll(r); err == nil {
+		t.Error("tampered frame length was accepted")
+	}
+
+	// fixed chunks are detected as well
+	buf.Reset()
+	fw, _ := NewWriter(&buf, key, []byte("info"), 256)
+	fw.Write(plain)
+	fw.Close()
+	r, _ = NewAnyReader(&buf, key, []byte("info"), 256)
+	out, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(out, plain) {
+		t.Errorf("fixed: %d bytes, %v", len(out), err)
+	}
+
+}
+
+func TestRekey(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 63*10+20)
+	rand.Read(plain)
+	defer func(interval uint64) { RekeyInterval = interval }(RekeyInterval)
+
+	seal := func(interval uint64) []byte {
+		RekeyInterval = interval
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, key, nil, 64)
+		w.Write(plain)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	open := func(ct []byte, interval uint64) ([]byte, error) {
+		RekeyInterval = interval
+		r, _ := NewReader(bytes.NewReader(ct), key, nil, 64)
+		return io.ReadAll(r)
+	}
+
+	// the key changes every three chunks
+	rekeyed := seal(3)
+	if out, err := open(rekeyed, 3); err != nil || !bytes.Equal(out, plain) {
+		t.Fatalf("rekeyed: %d bytes, %v", len(out), err)
+	}
+	if _, err := open(rekeyed, 0); err == nil {
+		t.Error("rekeyed stream opened without rekeying")
+	}
+	RekeyInterval = 3
+	ra, err := NewReaderAt(bytes.NewReader(rekeyed), int64(len(rekeyed)), key, nil, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, len(plain))
+	for _, off := range []int{500, 10, 300, 0} {
+		if n, err := ra.ReadAt(out[off:], int64(off)); err != nil && err != io.EOF || !bytes.Equal(out[off:off+n], plain[off:]) {
+			t.Errorf("ReadAt %d: %v", off, err)
+		}
+	}
+
+	// streams without rekeying are still opened
+	legacy := seal(0)
+	if out, err := open(legacy, 3); err != nil || !bytes.Equal(out, plain) {
+		t.Errorf("legacy: %d bytes, %v", len(out), err)
+	}
+	if _, err = NewReaderAt(bytes.NewReader(legacy), int64(len(legacy)), key, nil, 64); err != nil {
+		t.Errorf("legacy ReaderAt: %v", err)
+	}
+
+}
+
+func TestExhausted(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	w, _ := NewWriter(io.Discard, key, nil, 64)
+	w.(*chunkWriter).chipherer.ctr.ctr = ^uint64(0) - 1
+	if _, err := w.Write(make([]byte, 63)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != errExhausted {
+		t.Errorf("expected exhausted counter, got %v", err)
+	}
+
+}
+
+func TestStreamID(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 1000)
+	rand.Read(plain)
+	seal := func(opts ...Option) []byte {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key, []byte("info"), 64, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plain)
+		w.Close()
+		return buf.Bytes()
+	}
+	open := func(ct []byte, opts ...Option) ([]byte, error) {
+		r, err := NewReader(bytes.NewReader(ct), key, []byte("info"), 64, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	// random IDs by default, so the same key gives different streams
+	a, b := seal(), seal()
+	if bytes.Equal(a[IDSize:IDSize+64], b[IDSize:IDSize+64]) {
+		t.Fatal("streams with the same key are equal")
+	}
+	if out, err := open(a); err != nil || !bytes.Equal(out, plain) {
+		t.Errorf("random ID: %d bytes, %v", len(out), err)
+	}
+	ra, err := NewReaderAt(bytes.NewReader(b), int64(len(b)), key, []byte("info"), 64)
+	if err != nil || ra.Size() != int64(len(plain)) {
+		t.Errorf("random ID ReaderAt: %v", err)
+	}
+
+	// the ID is authenticated
+	a[0] ^= 1
+	if _, err = open(a); err == nil {
+		t.Error("tampered stream ID was accepted")
+	}
+
+	// caller-supplied IDs are not written and must match
+	c := seal(StreamID([]byte("one")))
+	if len(c) != len(b)-IDSize {
+		t.Errorf("stream ID was written")
+	}
+	if out, err := open(c, StreamID([]byte("one"))); err != nil || !bytes.Equal(out, plain) {
+		t.Errorf("stream ID: %d bytes, %v", len(out), err)
+	}
+	if _, err = open(c, StreamID([]byte("two"))); err == nil {
+		t.Error("opened with the wrong stream ID")
+	}
+	if _, err = open(c, UniqueKey()); err == nil {
+		t.Error("opened without the stream ID")
+	}
+
+	// unique keys have no ID at all
+	if out, err := open(seal(UniqueKey()), UniqueKey()); err != nil || !bytes.Equal(out, plain) {
+		t.Errorf("unique key: %d bytes, %v", len(out), err)
+	}
+	for _, opts := range [][]Option{{StreamID(nil)}, {StreamID([]byte{})}, {UniqueKey(), StreamID([]byte("one"))}} {
+		if _, err = NewWriter(io.Discard, key, nil, 64, opts...); err == nil {
+			t.Error("invalid options were accepted")
+		}
+	}
+
+}
+
+func TestParity(t *testing.T) {
+
+	defer func(pad bool) { PadFinal = pad }(PadFinal)
+	PadFinal = false
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 1200) // 20 chunks with a short final one
+	rand.Read(plain)
+	parity := Parity(4, 2)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, nil, 64, UniqueKey(), parity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFramedWriter(io.Discard, key, nil, 64, UniqueKey(), parity); err == nil {
+		t.Error("framed writer with parity")
+	}
+
+	var repaired []uint64
+	open := func(ct []byte, opts ...Option) ([]byte, error) {
+		repaired = nil
+		opts = append(opts, UniqueKey(), parity)
+		r, err := NewAnyReader(bytes.NewReader(ct), key, nil, 64, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+	repair := Repair(func(index uint64) { repaired = append(repaired, index) })
+	if out, err := open(buf.Bytes()); err != nil || !bytes.Equal(out, plain) {
+		t.Fatalf("parity: %d bytes, %v", len(out), err)
+	}
+	ra, err := NewReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), key, nil, 64, UniqueKey(), parity)
+	if err != nil || ra.Size() != int64(len(plain)) {
+		t.Fatalf("parity ReaderAt: %v", err)
+	}
+	out := make([]byte, 100)
+	if _, err = ra.ReadAt(out, 1000); err != nil || !bytes.Equal(out, plain[1000:1100]) {
+		t.Errorf("parity ReaderAt: %v", err)
+	}
+
+	// flip bits in two chunks of the first group, a chunk and a parity chunk of the second
+	// group and in the final chunk
+	l := layout{size: 80, data: 4, parity: 2}
+	damaged := append([]byte(nil), buf.Bytes()...)
+	for _, off := range []int64{l.offset(0) + 5, l.offset(3) + 70, l.offset(5) + 1, l.group() + 4*80 + 84 + 10, l.offset(19) + 3} {
+		damaged[off] ^= 0x10
+	}
+	if _, err = open(damaged); err == nil {
+		t.Error("damaged stream opened without repair")
+	}
+	out, err = open(damaged, repair)
+	if err != nil || !bytes.Equal(out, plain) {
+		t.Fatalf("repair: %d bytes, %v", len(out), err)
+	}
+	if fmt.Sprint(repaired) != "[0 3 5 19]" {
+		t.Errorf("repaired chunks %v", repaired)
+	}
+
+	// too many damaged chunks in a group
+	damaged[l.offset(1)] ^= 0x10
+	if out, err = open(damaged, repair); err == nil || len(out) != 0 {
+		t.Errorf("repaired too many chunks: %d bytes, %v", len(out), err)
+	}
+
+}
+
+func TestSalvage(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 600) // ten chunks of 63 bytes
+	rand.Read(plain)
+	seal := func(opts ...Option) []byte {
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, key, nil, 64, opts...)
+		w.Write(plain)
+		w.Close()
+		return buf.Bytes()
+	}
+	salvage := func(ct []byte, zerofill bool, opts ...Option) ([]byte, []Range, error) {
+		var out bytes.Buffer
+		lost, err := Salvage(&out, bytes.NewReader(ct), key, nil, 64, zerofill, opts...)
+		return out.Bytes(), lost, err
+	}
+
+	// damage chunks 2, 3 and 7
+	ct := seal(UniqueKey())
+	for _, k := range []int{2, 3, 7} {
+		ct[k*80+40] ^= 1
+	}
+	out, lost, err := salvage(ct, true, UniqueKey())
+	if err != nil || fmt.Sprint(lost) != "[{126 252} {441 504}]" {
+		t.Fatalf("lost %v: %v", lost, err)
+	}
+	expect := append([]byte(nil), plain...)
+	for _, r := range lost {
+		copy(expect[r.Start:r.End], make([]byte, r.End-r.Start))
+	}
+	if !bytes.Equal(out, expect) {
+		t.Error("zero-filled plaintext differs")
+	}
+	out, _, _ = salvage(ct, false, UniqueKey())
+	if !bytes.Equal(out, append(append(plain[:126:126], plain[252:441]...), plain[504:]...)) {
+		t.Error("salvaged plaintext differs")
+	}
+
+	// a missing final chunk and a wrong key
+	if _, lost, err = salvage(ct[:9*80], true, UniqueKey()); err != ErrTruncated || len(lost) != 2 {
+		t.Errorf("truncated: %v, %v", lost, err)
+	}
+	rand.Read(key)
+	if _, _, err = salvage(ct, true, UniqueKey()); err == nil {
+		t.Error("salvaged with the wrong key")
+	}
+
+	// groups with parity are repaired if possible and salvaged otherwise
+	parity := []Option{UniqueKey(), Parity(4, 1), Repair(nil)}
+	ct = seal(parity...)
+	for _, off := range []int{10, 4*80 + 84 + 10, 4*80 + 84 + 80 + 10} {
+		ct[off] ^= 1
+	}
+	out, lost, err = salvage(ct, true, parity...)
+	if err != nil || fmt.Sprint(lost) != "[{252 378}]" || !bytes.Equal(out[:252], plain[:252]) {
+		t.Errorf("parity: lost %v: %v", lost, err)
+	}
+
+}
+
+func TestSalvageFramed(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 115)
+	rand.Read(plain)
+
+	// frames of 10, 63, 37 and 5 bytes
+	var buf bytes.Buffer
+	w, _ := NewFramedWriter(&buf, key, nil, 64, UniqueKey())
+	w.Write(plain[:10])
+	w.Flush()
+	w.Write(plain[10:110])
+	w.Flush()
+	w.Write(plain[110:])
+	w.Close()
+	ct := buf.Bytes()
+	salvage := func(ct []byte, zerofill bool) ([]byte, []Range, error) {
+		var out bytes.Buffer
+		lost, err := SalvageFramed(&out, bytes.NewReader(ct), key, nil, 64, zerofill, UniqueKey())
+		return out.Bytes(), lost, err
+	}
+
+	// an intact stream is salvaged completely
+	if out, lost, err := salvage(ct, false); err != nil || len(lost) != 0 || !bytes.Equal(out, plain) {
+		t.Fatalf("intact: lost %v: %v", lost, err)
+	}
+
+	// a damaged frame is lost exactly
+	damaged := append([]byte(nil), ct...)
+	damaged[31+4+10] ^= 1
+	out, lost, err := salvage(damaged, true)
+	if err != nil || fmt.Sprint(lost) != "[{10 73}]" {
+		t.Fatalf("damaged: lost %v: %v", lost, err)
+	}
+	expect := append(append(append([]byte(nil), plain[:10]...), make([]byte, 63)...), plain[73:]...)
+	if !bytes.Equal(out, expect) {
+		t.Error("zero-filled plaintext differs")
+	}
+
+	// the frames after a damaged length cannot be located
+	damaged = append([]byte(nil), ct...)
+	damaged[31+4+80] = 0xff
+	if out, _, err = salvage(damaged, true); err != ErrFrameLength || !bytes.Equal(out, plain[:73]) {
+		t.Errorf("damaged length: %v", err)
+	}
+
+	// a missing final frame and fixed chunks
+	if _, _, err = salvage(ct[:len(ct)-20], true); err != ErrTruncated {
+		t.Errorf("truncated: %v", err)
+	}
+	var fixed bytes.Buffer
+	fw, _ := NewWriter(&fixed, key, nil, 64, UniqueKey())
+	fw.Write(plain)
+	fw.Close()
+	if _, _, err = salvage(fixed.Bytes(), true); err == nil {
+		t.Error("fixed chunks were salvaged as frames")
+	}
+
+}
+
+func TestSalvageFramedStreamID(t *testing.T) {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	info := []byte("salvage")
+	plain := make([]byte, 300)
+	rand.Read(plain)
+
+	// the default random stream ID is part of the additional data
+	var buf bytes.Buffer
+	w, _ := NewFramedWriter(&buf, key, info, 64)
+	w.Write(plain[:100])
+	w.Flush()
+	w.Write(plain[100:])
+	w.Close()
+
+	var out bytes.Buffer
+	lost, err := SalvageFramed(&out, bytes.NewReader(buf.Bytes()), key, info, 64, true)
+	if err != nil || len(lost) != 0 || !bytes.Equal(out.Bytes(), plain) {
+		t.Fatalf("intact: lost %v: %v", lost, err)
+	}
+	out.Reset()
+	if _, err = SalvageFramed(&out, bytes.NewReader(buf.Bytes()), key, []byte("other"), 64, true); err == nil {
+		t.Error("salvaged frames with the wrong info")
+	}
+
+}
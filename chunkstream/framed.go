@@ -0,0 +1,151 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package chunkstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// Framed chunks are prefixed with the length of their ciphertext as a 32 bit big-endian
+// integer, which is appended to the associated data of the chunk:
+//  length | sealed( data | padding marker )
+//
+// Since every chunk carries its length, a writer can seal shorter running chunks at any
+// time with Flush. This is useful for slow streams like logs or interactive protocols,
+// where data should not sit in a buffer until a chunk is full. The padding marker still
+// signals the final chunk, so truncation is detected like in the fixed chunks. Framed
+// chunks cannot be opened with NewReaderAt because their offsets are not known.
+
+// ErrTruncated is returned by Readers when the ciphertext ends before the final chunk.
+var ErrTruncated = errors.New("chunkreader: truncated ciphertext")
+
+// FlushWriteCloser is returned by NewFramedWriter.
+type FlushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// framePrefix is the length of the prefix of framed chunks
+const framePrefix = 4
+
+// NewFramedWriter works like NewWriter but writes framed chunks, which may be shorter than
+// chunksize if Flush is called. Full chunks are still written automatically and you MUST
+// call Close() when you're done to ensure the final chunk is written.
+func NewFramedWriter(w io.Writer, key, info []byte, chunksize int, opts ...Option) (FlushWriteCloser, error) {
+	if err := noParity(opts); err != nil {
+		return nil, err
+	}
+	cw, err := NewWriter(w, key, info, chunksize, opts...)
+	if err != nil {
+		return nil, err
+	}
+	cw.(*chunkWriter).framed = true
+	return cw.(*chunkWriter), nil
+}
+
+// NewFramedReader works like NewReader for chunks from NewFramedWriter. The chunksize
+// limits the length of a single chunk and must be the same as for the writer.
+func NewFramedReader(r io.Reader, key, info []byte, chunksize int, opts ...Option) (io.Reader, error) {
+	if err := noParity(opts); err != nil {
+		return nil, err
+	}
+	cr, err := NewReader(r, key, info, chunksize, opts...)
+	if err != nil {
+		return nil, err
+	}
+	cr.(*chunkReader).framed = true
+	return cr, nil
+}
+
+// NewAnyReader returns a Reader for either fixed or framed chunks. The first chunk is
+// read and authenticated as a frame and if that fails, the stream is read as fixed
+// chunks instead. Since the associated data differs, a chunk can only ever
+// authenticate in the variant it was written in. Streams with parity are always fixed.
+func NewAnyReader(r io.Reader, key, info []byte, chunksize int, opts ...Option) (io.Reader, error) {
+
+	// read a random stream ID only once and pass it on to both attempts
+	s, err := newStream(opts)
+	if err == nil {
+		err = s.read(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.id != nil {
+		opts = append(opts, StreamID(s.id))
+	}
+	if s.data > 0 {
+		return NewReader(r, key, info, chunksize, opts...)
+	}
+
+	// try to open the first chunk as a frame and keep a copy of what was read
+	peek := new(bytes.Buffer)
+	fr, err := NewFramedReader(io.TeeReader(r, peek), key, info, chunksize, opts...)
+	if err != nil {
+		return nil, err
+	}
+	cr := fr.(*chunkReader)
+	if err = cr.open(); err == nil || (err == io.EOF && cr.final) {
+		cr.reader, cr.err = r, err
+		return cr, nil
+	}
+	cr.Destroy()
+
+	return NewReader(io.MultiReader(peek, r), key, info, chunksize, opts...)
+
+}
+
+// noParity checks that the options do not add parity
+func noParity(opts []Option) error {
+	s, err := newStream(opts)
+	if err == nil && s.data > 0 {
+		err = errFramedParity
+	}
+	return err
+}
+
+// sealFrame encrypts a padded chunk and writes it with its length prefix
+func (cw *chunkWriter) sealFrame(chunk []byte) (err error) {
+	cc := cw.chipherer
+	frame := make([]byte, framePrefix, framePrefix+len(chunk)+cc.cipher.Overhead())
+	binary.BigEndian.PutUint32(frame, uint32(cap(frame)-framePrefix))
+	ad := append(append(make([]byte, 0, len(cc.info)+framePrefix), cc.info...), frame...)
+	frame, err = cc.sealAD(frame, chunk, ad)
+	securebuf.Wipe(chunk)
+	if err != nil {
+		return
+	}
+	_, err = cw.writer.Write(frame)
+	return
+}
+
+// openFrame reads, decrypts and buffers a single framed chunk
+func (cr *chunkReader) openFrame() (err error) {
+	cc := cr.chipherer
+	prefix := make([]byte, framePrefix)
+	if _, err = io.ReadFull(cr.reader, prefix); err != nil {
+		return
+	}
+	length := int(binary.BigEndian.Uint32(prefix))
+	if length <= cc.cipher.Overhead() || length > cr.chunksize {
+		return errors.New("chunkreader: invalid frame length")
+	}
+	chunk := make([]byte, length)
+	if _, err = io.ReadFull(cr.reader, chunk); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	ad := append(append(make([]byte, 0, len(cc.info)+framePrefix), cc.info...), prefix...)
+	if chunk, err = cc.openAD(chunk[:0], chunk, ad); err != nil {
+		return
+	}
+	return cr.buffer(chunk)
+}
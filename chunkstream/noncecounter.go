@@ -23,9 +23,20 @@ func newNonceCounter(size int) *nonceCounter {
 }
 
 // Next outputs the current counter value as a slice and then increments
-// the internal counter.
-func (nc *nonceCounter) Next() (nonce []byte) {
+// the internal counter. It fails instead of wrapping around to zero.
+func (nc *nonceCounter) Next() (nonce []byte, err error) {
+	if nc.ctr == ^uint64(0) {
+		return nil, errExhausted
+	}
 	binary.LittleEndian.PutUint64(nc.nonce, nc.ctr)
 	nc.ctr++
-	return nc.nonce[:nc.size]
+	return nc.nonce[:nc.size], nil
+}
+
+// At outputs the nonce for the given counter value in a new slice without changing
+// the internal counter, e.g. for random access.
+func (nc *nonceCounter) At(ctr uint64) (nonce []byte) {
+	nonce = make([]byte, nc.size)
+	binary.LittleEndian.PutUint64(nonce, ctr)
+	return
 }
@@ -0,0 +1,281 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package chunkstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/ansemjo/aenker/erasure"
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// Parity chunks protect a stream on damaged media, where a single flipped bit would
+// otherwise fail the authentication of a chunk and stop the reader. The encrypted chunks
+// are grouped and every group of data chunks is followed by its parity chunks, which are
+// computed with the Reed-Solomon code of the erasure package:
+//  chunk[0] | ... | chunk[k-1] | parity[0] | crc32 | ... | parity[m-1] | crc32
+//
+// All chunks of a group are zero-padded to the full size of an encrypted chunk, including
+// a shorter final chunk and the missing chunks of the final group, which are not written.
+// Every parity chunk is followed by the big-endian CRC-32 of its content to detect
+// damaged parity. Damaged data chunks are detected by their authentication, so up to m
+// damaged chunks of any group can be rebuilt when reading with Repair. Otherwise the
+// parity chunks are simply skipped. Parity is not available with framed chunks.
+
+// parityCRC is the length of the checksum after a parity chunk
+const parityCRC = 4
+
+// Parity adds the given number of parity chunks to every group of data chunks. The same
+// numbers must be given to the reader, e.g. by recording them in a header.
+func Parity(data, parity int) Option {
+	return func(s *stream) { s.data, s.parity = data, parity }
+}
+
+// Repair rebuilds damaged chunks from the parity chunks before they are authenticated.
+// The function is called with the index of every chunk that was rebuilt and may be nil.
+// Only Readers repair chunks, ReaderAt skips the parity chunks. The initial key is kept
+// to check the chunks of a group, so the stream has no forward secrecy while reading.
+func Repair(report func(index uint64)) Option {
+	return func(s *stream) { s.repair, s.report = true, report }
+}
+
+// layout maps chunk indices to offsets in a stream with or without parity chunks
+type layout struct {
+	size         int64 // size of an encrypted chunk
+	data, parity int64
+}
+
+// group returns the length of a complete group
+func (l layout) group() int64 {
+	return l.data*l.size + l.parity*(l.size+parityCRC)
+}
+
+// offset returns the offset of a chunk
+func (l layout) offset(index int64) int64 {
+	if l.data == 0 {
+		return index * l.size
+	}
+	return index/l.data*l.group() + index%l.data*l.size
+}
+
+// chunks returns the number of chunks in a stream of the given length and the length of
+// the final chunk, which may be shorter
+func (l layout) chunks(length int64) (n, last int64) {
+	if l.data == 0 {
+		n = (length + l.size - 1) / l.size
+		return n, length - (n-1)*l.size
+	}
+	groups, rest := length/l.group(), length%l.group()
+	if rest == 0 {
+		return groups * l.data, l.size
+	}
+	if rest -= l.parity * (l.size + parityCRC); rest <= 0 {
+		return 0, 0
+	}
+	n = (rest + l.size - 1) / l.size
+	return groups*l.data + n, rest - (n-1)*l.size
+}
+
+// parityWriter writes groups of encrypted chunks followed by their parity chunks. Every
+// Write must be exactly one chunk.
+type parityWriter struct {
+	writer io.Writer
+	code   *erasure.Code
+	shards [][]byte
+	n      int // chunks in the current group
+}
+
+func newParityWriter(w io.Writer, data, parity, size int) (*parityWriter, error) {
+	code, err := erasure.New(data, parity)
+	if err != nil {
+		return nil, err
+	}
+	pw := &parityWriter{writer: w, code: code, shards: make([][]byte, data+parity)}
+	for i := range pw.shards {
+		pw.shards[i] = make([]byte, size)
+	}
+	return pw, nil
+}
+
+func (pw *parityWriter) Write(chunk []byte) (n int, err error) {
+	copy(pw.shards[pw.n], chunk)
+	zero(pw.shards[pw.n][len(chunk):])
+	pw.n++
+	if n, err = pw.writer.Write(chunk); err != nil {
+		return
+	}
+	if pw.n == pw.code.Data {
+		err = pw.flush()
+	}
+	return
+}
+
+// flush writes the parity chunks of the current group
+func (pw *parityWriter) flush() (err error) {
+	if pw.n == 0 {
+		return
+	}
+	for i := pw.n; i < pw.code.Data; i++ {
+		zero(pw.shards[i])
+	}
+	if err = pw.code.Encode(pw.shards); err != nil {
+		return
+	}
+	sum := make([]byte, parityCRC)
+	for _, p := range pw.shards[pw.code.Data:] {
+		binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(p))
+		if _, err = pw.writer.Write(p); err != nil {
+			return
+		}
+		if _, err = pw.writer.Write(sum); err != nil {
+			return
+		}
+	}
+	pw.n = 0
+	return
+}
+
+// parityReader reads groups of chunks, optionally repairs them and returns only the
+// data chunks. A group that is shorter than a complete one ends the stream.
+type parityReader struct {
+	reader  io.Reader
+	code    *erasure.Code
+	size    int
+	raw     []byte
+	data    []byte // remaining data chunks of the current group
+	group   uint64
+	err     error
+	check   *chunkCipherer // opens chunks to detect damage if repairing
+	report  func(index uint64)
+	lenient bool // pass on groups that cannot be repaired
+}
+
+func newParityReader(r io.Reader, data, parity, size int) (*parityReader, error) {
+	code, err := erasure.New(data, parity)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, data*size+parity*(size+parityCRC))
+	return &parityReader{reader: r, code: code, size: size, raw: raw}, nil
+}
+
+func (pr *parityReader) Read(p []byte) (n int, err error) {
+	for len(pr.data) == 0 {
+		if pr.err != nil {
+			return 0, pr.err
+		}
+		pr.err = pr.next()
+	}
+	n = copy(p, pr.data)
+	pr.data = pr.data[n:]
+	return
+}
+
+// next reads and repairs the next group
+func (pr *parityReader) next() error {
+	n, err := io.ReadFull(pr.reader, pr.raw)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	length := n - pr.code.Parity*(pr.size+parityCRC)
+	if length <= 0 {
+		return ErrTruncated
+	}
+	if pr.check != nil {
+		if e := pr.repair(pr.raw[:length], pr.raw[length:n]); e != nil && !pr.lenient {
+			return e
+		}
+	}
+	pr.data = pr.raw[:length]
+	pr.group++
+	if err == io.ErrUnexpectedEOF {
+		// the final group was shorter
+		return io.EOF
+	}
+	return nil
+}
+
+// repair rebuilds the data chunks of a group, which do not authenticate
+func (pr *parityReader) repair(data, parity []byte) error {
+
+	k, size := pr.code.Data, pr.size
+	chunk := func(i int) []byte {
+		if end := (i + 1) * size; end < len(data) {
+			return data[i*size : end]
+		}
+		return data[i*size:]
+	}
+	index := func(i int) uint64 {
+		return pr.group*uint64(k) + uint64(i)
+	}
+
+	// check the data chunks, the missing ones of a final group are zero
+	d := (len(data) + size - 1) / size
+	shards := make([][]byte, k+pr.code.Parity)
+	present := make([]bool, len(shards))
+	damaged := false
+	for i := 0; i < k; i++ {
+		shards[i] = make([]byte, size)
+		if present[i] = i >= d; !present[i] {
+			copy(shards[i], chunk(i))
+			present[i] = pr.authentic(chunk(i), index(i))
+			damaged = damaged || !present[i]
+		}
+	}
+	if !damaged {
+		return nil
+	}
+
+	// check the parity chunks and rebuild
+	for i := range shards[k:] {
+		p := parity[i*(size+parityCRC) : (i+1)*(size+parityCRC)]
+		shards[k+i] = append([]byte(nil), p[:size]...)
+		present[k+i] = crc32.ChecksumIEEE(p[:size]) == binary.BigEndian.Uint32(p[size:])
+	}
+	if err := pr.code.Reconstruct(shards, present); err != nil {
+		return fmt.Errorf("chunkreader: cannot repair the group of chunk %d, too many chunks are damaged or the key is wrong", index(0))
+	}
+	for i := 0; i < d; i++ {
+		if present[i] {
+			continue
+		}
+		copy(chunk(i), shards[i])
+		if !pr.authentic(chunk(i), index(i)) {
+			return fmt.Errorf("chunkreader: cannot repair chunk %d", index(i))
+		}
+		if pr.report != nil {
+			pr.report(index(i))
+		}
+	}
+	return nil
+
+}
+
+// authentic checks if a chunk authenticates without changing it
+func (pr *parityReader) authentic(chunk []byte, index uint64) bool {
+	plain, err := pr.check.OpenAt(append([]byte(nil), chunk...), index)
+	securebuf.Wipe(plain)
+	return err == nil
+}
+
+// destroy wipes the key to check chunks
+func (pr *parityReader) destroy() {
+	if pr.check != nil {
+		pr.check.Destroy()
+	}
+}
+
+// errFramedParity is returned for framed streams with parity
+var errFramedParity = errors.New("chunkstream: parity is not available with framed chunks")
+
+// zero all bytes
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
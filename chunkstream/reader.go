@@ -5,10 +5,10 @@ package chunkstream
 
 import (
 	"bytes"
-	"errors"
 	"io"
 
 	"github.com/ansemjo/aenker/padding"
+	"github.com/ansemjo/aenker/securebuf"
 )
 
 type chunkReader struct {
@@ -18,6 +18,8 @@ type chunkReader struct {
 	reader    io.Reader
 	err       error
 	final     bool
+	framed    bool
+	parity    *parityReader
 }
 
 // NewReader instantiates a new authenticated cipher from NewAEAD with the given key and
@@ -27,28 +29,48 @@ type chunkReader struct {
 //
 // Do not increase the chunksize manually to compensate for AEAD overhead, the chunkCipherer within
 // will do that automatically. I.e. if you encrypted with chunksize=2048 you need to decrypt with
-// chunksize=2048.
-func NewReader(r io.Reader, key, info []byte, chunksize int) (io.Reader, error) {
+// chunksize=2048. The same options as for the Writer must be given.
+func NewReader(r io.Reader, key, info []byte, chunksize int, opts ...Option) (io.Reader, error) {
 
 	cr := &chunkReader{reader: r}
-	var err error
+	s, err := newStream(opts)
+	if err == nil {
+		err = s.read(r)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	cr.chipherer, err = newChunkCipherer(key, info)
+	if cr.chipherer, err = newChunkCipherer(key, info, s); err != nil {
+		return nil, err
+	}
 
 	cr.chunksize = chunksize + cr.chipherer.cipher.Overhead()
+	cr.buf = bytes.NewBuffer(make([]byte, 0, chunksize))
 
-	if err == nil {
-		cr.buf = bytes.NewBuffer(make([]byte, 0, chunksize))
+	if s.data > 0 {
+		if cr.parity, err = newParityReader(r, s.data, s.parity, cr.chunksize); err != nil {
+			return nil, err
+		}
+		cr.reader = cr.parity
+		cr.chipherer.ratchet = ratchetOn // streams with parity are new
+		if s.repair {
+			if cr.parity.check, err = newChunkCipherer(key, info, s); err != nil {
+				return nil, err
+			}
+			cr.parity.check.ratchet = ratchetOn
+			cr.parity.report = s.report
+		}
 	}
 
-	return cr, err
+	return cr, nil
 
 }
 
 func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
-	// previous errors
-	if cr.err != nil {
+	// previous errors, after the final chunk is drained
+	if cr.err != nil && (cr.err != io.EOF || cr.buf.Len() == 0) {
 		return 0, cr.err
 	}
 	// save error for future calls upon exit
@@ -58,13 +80,13 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 		}
 	}()
 
-	// decrypt more data
-	if cr.buf.Len() == 0 {
+	// decrypt more data, framed chunks may be empty
+	for cr.buf.Len() == 0 && cr.err == nil {
 		err = cr.open()
 		if err != nil {
 			// eof before the final chunk means truncated ciphertext
 			if !cr.final && (err == io.EOF || err == io.ErrUnexpectedEOF) {
-				err = errors.New("chunkreader: truncated ciphertext")
+				err = ErrTruncated
 			}
 			// any non-eof is probably some serious error
 			if err != io.EOF {
@@ -79,11 +101,38 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
 }
 
+// Destroy wipes the key and any buffered plaintext.
+func (cr *chunkReader) Destroy() {
+	cr.chipherer.Destroy()
+	if cr.parity != nil {
+		cr.parity.destroy()
+	}
+	if cr.buf != nil {
+		securebuf.Wipe(cr.buf.Bytes())
+		cr.buf.Reset()
+	}
+	cr.err = errDestroyed
+
+}
+
 func (cr *chunkReader) open() (err error) {
 
+	if cr.framed {
+		return cr.openFrame()
+	}
+
 	// TODO: direct copy to second internal buffer with io.CopyN ?
 	chunk := make([]byte, cr.chunksize)
-	_, err = io.ReadFull(cr.reader, chunk)
+	n, err := io.ReadFull(cr.reader, chunk)
+	if err == io.ErrUnexpectedEOF && n > cr.chipherer.cipher.Overhead() {
+		// a shorter final chunk without padding, which must authenticate and be final
+		chunk, err = chunk[:n], nil
+		defer func() {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+		}()
+	}
 	if err != nil {
 		return
 	}
@@ -94,10 +143,21 @@ func (cr *chunkReader) open() (err error) {
 		return
 	}
 
-	// remove padding and check if this is the last chunk
+	return cr.buffer(chunk)
+
+}
+
+// buffer removes the padding of a decrypted chunk and writes it to the internal buffer
+func (cr *chunkReader) buffer(chunk []byte) (err error) {
+
+	// remove padding and check if this is the last chunk, the key is not needed anymore
 	final := padding.Remove(&chunk)
 	if final {
 		cr.final = true
+		cr.chipherer.Destroy()
+		if cr.parity != nil {
+			cr.parity.destroy()
+		}
 		err = io.EOF
 	}
 
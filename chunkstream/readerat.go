@@ -0,0 +1,155 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package chunkstream
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ansemjo/aenker/padding"
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// ReaderAt gives random access to the plaintext of a complete stream, which was written
+// with NewWriter and is available as an io.ReaderAt, e.g. a file. Every chunk but the
+// final one holds exactly chunksize-1 bytes of plaintext, so the chunk of any offset is
+// known and only the chunks that are needed are decrypted. It is safe for concurrent use.
+type ReaderAt struct {
+	chipherer *chunkCipherer
+	reader    io.ReaderAt
+	chunksize int   // size of an encrypted chunk
+	data      int64 // plaintext in every chunk but the final one
+	layout    layout
+	chunks    int64 // number of chunks
+	last      int64 // size of the final encrypted chunk
+	size      int64 // size of the plaintext
+
+	mu     sync.Mutex
+	cache  []byte // plaintext of the last chunk that was read
+	cached int64
+}
+
+// NewReaderAt opens a stream of the given length in r with the same parameters and
+// options as NewReader. The final chunk is decrypted immediately to determine the size
+// of the plaintext, so truncated streams are detected.
+func NewReaderAt(r io.ReaderAt, length int64, key, info []byte, chunksize int, opts ...Option) (ra *ReaderAt, err error) {
+
+	s, err := newStream(opts)
+	if err != nil {
+		return nil, err
+	}
+	if r, length, err = s.readAt(r, length); err != nil {
+		return nil, err
+	}
+	ra = &ReaderAt{reader: r, cached: -1}
+	if ra.chipherer, err = newChunkCipherer(key, info, s); err != nil {
+		return nil, err
+	}
+	overhead := ra.chipherer.cipher.Overhead()
+	ra.chunksize = chunksize + overhead
+	ra.layout = layout{size: int64(ra.chunksize), data: int64(s.data), parity: int64(s.parity)}
+	if s.data > 0 {
+		ra.chipherer.ratchet = ratchetOn // streams with parity are new
+	}
+
+	// the final chunk may be shorter if it is not padded
+	if ra.chunks, ra.last = ra.layout.chunks(length); ra.chunks == 0 || ra.last <= int64(overhead) {
+		ra.Destroy()
+		return nil, errors.New("chunkreader: truncated ciphertext")
+	}
+	final, err := ra.chunk(ra.chunks - 1)
+	if err != nil {
+		ra.Destroy()
+		return nil, err
+	}
+	ra.data = int64(chunksize - 1)
+	ra.size = (ra.chunks-1)*ra.data + int64(len(final))
+	return
+
+}
+
+// Size returns the size of the plaintext.
+func (ra *ReaderAt) Size() int64 {
+	return ra.size
+}
+
+// ReadAt reads plaintext at the given offset and decrypts all chunks it overlaps.
+func (ra *ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+
+	if off < 0 {
+		return 0, errors.New("chunkreader: negative offset")
+	}
+	ra.mu.Lock()
+	destroyed := ra.chipherer.cipher == nil
+	ra.mu.Unlock()
+	if destroyed {
+		return 0, errDestroyed
+	}
+	for n < len(p) {
+		if off >= ra.size {
+			return n, io.EOF
+		}
+		ra.mu.Lock()
+		chunk, err := ra.chunk(off / ra.data)
+		if err != nil {
+			ra.mu.Unlock()
+			return n, err
+		}
+		c := copy(p[n:], chunk[off%ra.data:])
+		ra.mu.Unlock()
+		n += c
+		off += int64(c)
+	}
+	return
+
+}
+
+// Destroy wipes the key and the cached plaintext.
+func (ra *ReaderAt) Destroy() {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	ra.chipherer.Destroy()
+	securebuf.Wipe(ra.cache)
+	ra.cache, ra.cached = nil, -1
+	ra.size = 0
+}
+
+// chunk returns the plaintext of chunk k, which must be final only if it is the last
+func (ra *ReaderAt) chunk(k int64) (plain []byte, err error) {
+
+	if k == ra.cached {
+		return ra.cache, nil
+	}
+	if ra.chipherer.cipher == nil {
+		return nil, errDestroyed
+	}
+
+	ct := make([]byte, ra.chunksize)
+	if k == ra.chunks-1 {
+		ct = ct[:ra.last]
+	}
+	n, err := ra.reader.ReadAt(ct, ra.layout.offset(k))
+	if n == len(ct) {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	plain, err = ra.chipherer.OpenAt(ct, uint64(k))
+	if err != nil {
+		return
+	}
+	if final := padding.Remove(&plain); final != (k == ra.chunks-1) {
+		if final {
+			return nil, errors.New("chunkreader: unexpected final chunk")
+		}
+		return nil, errors.New("chunkreader: truncated ciphertext")
+	}
+
+	securebuf.Wipe(ra.cache)
+	ra.cache, ra.cached = plain, k
+	return
+
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package chunkstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/ansemjo/aenker/padding"
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// Range is a range of plaintext bytes from Start up to but excluding End.
+type Range struct {
+	Start, End int64
+}
+
+// Salvage decrypts a damaged stream of fixed chunks from r and writes the plaintext of
+// every chunk that authenticates to w. Since the nonce is the index of a chunk, every
+// chunk can be opened independently of the others. The plaintext of damaged chunks is
+// replaced with zeros if zerofill is true, so all offsets are preserved, or left out
+// otherwise. The ranges that were lost are returned in terms of the original plaintext.
+//
+// The end of the plaintext is only known from the final chunk. If it does not
+// authenticate, the range of its plaintext is an upper bound and ErrTruncated is
+// returned along with the lost ranges. Parity chunks are skipped and used to repair
+// damaged chunks if the Repair option is given. Only bytes that were changed can be
+// salvaged, all chunks after a lost or inserted byte are lost as well.
+func Salvage(w io.Writer, r io.Reader, key, info []byte, chunksize int, zerofill bool, opts ...Option) (lost []Range, err error) {
+
+	s, err := newStream(opts)
+	if err == nil {
+		err = s.read(r)
+	}
+	if err != nil {
+		return
+	}
+	cc, err := newChunkCipherer(key, info, s)
+	if err != nil {
+		return
+	}
+	defer cc.Destroy()
+	overhead := cc.cipher.Overhead()
+
+	if s.data > 0 {
+		pr, err := newParityReader(r, s.data, s.parity, chunksize+overhead)
+		if err != nil {
+			return nil, err
+		}
+		cc.ratchet = ratchetOn // streams with parity are new
+		pr.lenient = true
+		if s.repair {
+			if pr.check, err = newChunkCipherer(key, info, s); err != nil {
+				return nil, err
+			}
+			pr.check.ratchet = ratchetOn
+			pr.report = s.report
+		}
+		defer pr.destroy()
+		r = pr
+	}
+
+	chunk := make([]byte, chunksize+overhead)
+	size := int64(chunksize - 1)
+	final, salvaged := false, false
+	for index := uint64(0); !final; index++ {
+
+		n, err := io.ReadFull(r, chunk)
+		if err == io.EOF || (err == io.ErrUnexpectedEOF && n <= overhead) || err == ErrTruncated {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return lost, err
+		}
+		start := int64(index) * size
+
+		plain, e := cc.OpenAt(chunk[:n], index)
+		if e == nil {
+			final = padding.Remove(&plain)
+			salvaged = true
+			_, e = w.Write(plain)
+			securebuf.Wipe(plain)
+			if e != nil {
+				return lost, e
+			}
+		} else {
+			// the plaintext of a shorter final chunk is at most as long as its ciphertext
+			length := size
+			if n < len(chunk) {
+				length = int64(n - overhead - 1)
+			}
+			lost = appendRange(lost, start, start+length)
+			if zerofill {
+				if _, e = w.Write(make([]byte, length)); e != nil {
+					return lost, e
+				}
+			}
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+
+	}
+
+	if !salvaged {
+		return lost, errors.New("chunkreader: no chunk authenticates, the key may be wrong")
+	}
+	if !final {
+		return lost, ErrTruncated
+	}
+	return lost, nil
+
+}
+
+// ErrFrameLength is returned by SalvageFramed when the length prefix of a frame is
+// damaged, so the following frames cannot be located and are lost as well.
+var ErrFrameLength = errors.New("chunkreader: damaged frame length, the rest of the stream is lost")
+
+// SalvageFramed works like Salvage for framed chunks from NewFramedWriter. Frames are
+// located by their length prefix and every frame but a padded final one holds exactly
+// its length minus the overhead and padding marker of plaintext, so the lost ranges are
+// known even though the frames may be shorter than chunksize. If a length prefix itself
+// is damaged, the following frames cannot be located and the salvage stops there with
+// ErrFrameLength.
+func SalvageFramed(w io.Writer, r io.Reader, key, info []byte, chunksize int, zerofill bool, opts ...Option) (lost []Range, err error) {
+
+	if err = noParity(opts); err != nil {
+		return
+	}
+	s, err := newStream(opts)
+	if err == nil {
+		err = s.read(r)
+	}
+	if err != nil {
+		return
+	}
+	cc, err := newChunkCipherer(key, info, s)
+	if err != nil {
+		return
+	}
+	defer cc.Destroy()
+	overhead := cc.cipher.Overhead()
+
+	prefix := make([]byte, framePrefix)
+	ad := append(append(make([]byte, 0, len(cc.info)+framePrefix), cc.info...), prefix...)
+	start := int64(0)
+	final, salvaged := false, false
+	for index := uint64(0); !final; index++ {
+
+		if _, err = io.ReadFull(r, prefix); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return lost, err
+		}
+		length := int(binary.BigEndian.Uint32(prefix))
+		if length <= overhead || length > chunksize+overhead {
+			return lost, ErrFrameLength
+		}
+		chunk := make([]byte, length)
+		n, err := io.ReadFull(r, chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return lost, err
+		}
+
+		copy(ad[len(cc.info):], prefix)
+		plain, e := cc.openAtAD(chunk[:n], index, ad)
+		if e == nil {
+			final = padding.Remove(&plain)
+			salvaged = true
+			start += int64(len(plain))
+			_, e = w.Write(plain)
+			securebuf.Wipe(plain)
+			if e != nil {
+				return lost, e
+			}
+		} else {
+			// the plaintext of a padded final frame is at most this long
+			length := int64(length - overhead - 1)
+			lost = appendRange(lost, start, start+length)
+			start += length
+			if zerofill {
+				if _, e = w.Write(make([]byte, length)); e != nil {
+					return lost, e
+				}
+			}
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+
+	}
+
+	if !salvaged {
+		return lost, errors.New("chunkreader: no chunk authenticates, the key may be wrong")
+	}
+	if !final {
+		return lost, ErrTruncated
+	}
+	return lost, nil
+
+}
+
+// appendRange adds a range and merges it with the previous one if they are adjacent
+func appendRange(ranges []Range, start, end int64) []Range {
+	if n := len(ranges); n > 0 && ranges[n-1].End == start {
+		ranges[n-1].End = end
+		return ranges
+	}
+	return append(ranges, Range{start, end})
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package chunkstream
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+// Stream IDs let one key protect many streams. The nonces of a stream are a simple
+// counter, so two streams must never be encrypted with the same key directly. Instead,
+// the key of every chunk is derived from the given key with HKDF, using the stream ID as
+// salt and the info string Streaminfo, and the ID is appended to the associated data:
+//  key = HKDF(key, id, Streaminfo)
+//  ad  = info | id
+//
+// Unlike a short nonce prefix, a random ID of IDSize bytes is practically never
+// repeated. By default, writers generate a random ID and write it in front of the
+// stream, where readers expect it. Callers that know their stream IDs, e.g. sequence
+// numbers, pass them with StreamID and they are not written. Only if every key really
+// is used for a single stream, e.g. because it was derived from an ephemeral key
+// exchange, the ID can be omitted with UniqueKey.
+
+// IDSize is the length of random stream IDs.
+const IDSize = 16
+
+// Streaminfo is the HKDF info string to derive the key of a stream from its ID.
+const Streaminfo = "aenker chunkstream stream"
+
+// Option configures the stream ID or parity of a Reader or Writer.
+type Option func(*stream)
+
+// stream holds the stream ID and parity options
+type stream struct {
+	id           []byte
+	unique       bool
+	data, parity int
+	repair       bool
+	report       func(index uint64)
+}
+
+// StreamID sets an ID that is unique for the key among all streams. It is not written
+// to the stream, so the reader must be given the same ID.
+func StreamID(id []byte) Option {
+	return func(s *stream) { s.id = append([]byte{}, id...) }
+}
+
+// UniqueKey declares that the key is never used for another stream, so no stream ID
+// is needed. Reusing such a key compromises confidentiality of both streams!
+func UniqueKey() Option {
+	return func(s *stream) { s.unique = true }
+}
+
+// newStream applies the options
+func newStream(opts []Option) (*stream, error) {
+	s := &stream{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.unique && s.id != nil {
+		return nil, errors.New("chunkstream: unique key with a stream ID")
+	}
+	if s.id != nil && len(s.id) == 0 {
+		return nil, errors.New("chunkstream: empty stream ID")
+	}
+	return s, nil
+}
+
+// inband is true if a random ID is written in front of the stream
+func (s *stream) inband() bool {
+	return s.id == nil && !s.unique
+}
+
+// write generates a random ID and writes it to w if necessary
+func (s *stream) write(w io.Writer) (err error) {
+	if !s.inband() {
+		return
+	}
+	s.id = make([]byte, IDSize)
+	if _, err = io.ReadFull(rand.Reader, s.id); err != nil {
+		return
+	}
+	_, err = w.Write(s.id)
+	return
+}
+
+// read reads the ID from r if necessary
+func (s *stream) read(r io.Reader) (err error) {
+	if !s.inband() {
+		return
+	}
+	id := make([]byte, IDSize)
+	if _, err = io.ReadFull(r, id); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return
+	}
+	s.id = id
+	return
+}
+
+// readAt reads the ID from the beginning of r if necessary and returns the remaining
+// section of the stream
+func (s *stream) readAt(r io.ReaderAt, length int64) (io.ReaderAt, int64, error) {
+	if !s.inband() {
+		return r, length, nil
+	}
+	if length < IDSize {
+		return nil, 0, ErrTruncated
+	}
+	id := make([]byte, IDSize)
+	if _, err := r.ReadAt(id, 0); err != nil {
+		return nil, 0, err
+	}
+	s.id = id
+	return io.NewSectionReader(r, IDSize, length-IDSize), length - IDSize, nil
+}
+
+// derive returns the key and associated data of the stream's chunks. The derived key
+// must be wiped by the caller unless the key is unique.
+func (s *stream) derive(key, info []byte) ([]byte, []byte) {
+	if s.unique {
+		return key, info
+	}
+	return keyderivation.HKDF(key, s.id, Streaminfo), append(append([]byte(nil), info...), s.id...)
+}
@@ -5,9 +5,11 @@ package chunkstream
 
 import (
 	"bytes"
+	"errors"
 	"io"
 
 	"github.com/ansemjo/aenker/padding"
+	"github.com/ansemjo/aenker/securebuf"
 )
 
 type chunkWriter struct {
@@ -16,6 +18,8 @@ type chunkWriter struct {
 	chunksize int
 	writer    io.Writer
 	err       error
+	framed    bool
+	parity    *parityWriter
 }
 
 // NewWriter instantiates a new authenticated cipher from NewAEAD with the given key and
@@ -24,20 +28,37 @@ type chunkWriter struct {
 //
 // You MUST call Close() when you're done to ensure the final chunk is written.
 //
-// You MUST use a unique key because internally a simple incrementing counter is used as
-// a nonce, so two streams encrypted with the same key will compromise confidentiality!
-func NewWriter(w io.Writer, key, info []byte, chunksize int) (io.WriteCloser, error) {
+// Internally a simple incrementing counter is used as a nonce, so by default a random
+// stream ID is written first and the key of the chunks is derived from it. See StreamID
+// and UniqueKey in the options to change that.
+func NewWriter(w io.Writer, key, info []byte, chunksize int, opts ...Option) (io.WriteCloser, error) {
 
 	cw := &chunkWriter{chunksize: chunksize, writer: w}
-	var err error
+	s, err := newStream(opts)
+	if err == nil {
+		err = s.write(w)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	cw.chipherer, err = newChunkCipherer(key, info)
+	cw.chipherer, err = newChunkCipherer(key, info, s)
 
-	if err == nil {
-		cw.buf = bytes.NewBuffer(make([]byte, 0, chunksize))
+	if err != nil {
+		return nil, err
 	}
+	cw.chipherer.ratchet = ratchetOn // new streams are always rekeyed
+	cw.buf = bytes.NewBuffer(make([]byte, 0, chunksize))
 
-	return cw, err
+	if s.data > 0 {
+		size := chunksize + cw.chipherer.cipher.Overhead()
+		if cw.parity, err = newParityWriter(w, s.data, s.parity, size); err != nil {
+			return nil, err
+		}
+		cw.writer = cw.parity
+	}
+
+	return cw, nil
 
 }
 
@@ -83,21 +104,70 @@ func (cw *chunkWriter) Write(data []byte) (n int, err error) {
 
 }
 
+// PadFinal decides whether the final chunk is padded to the full chunksize, which hides
+// the exact length of the plaintext within a chunk. Otherwise the final chunk is only as
+// long as its data. Readers accept both, assign before calling NewWriter to change it.
+var PadFinal = true
+
 func (cw *chunkWriter) seal(final bool) (err error) {
 
 	chunk := cw.buf.Next(cw.chunksize - 1)
-	err = padding.Add(&chunk, final, cw.chunksize) // add padding to plaintext
+	capacity := cw.chunksize
+	if (final && !PadFinal) || (!final && cw.framed) {
+		capacity = len(chunk) + 1
+	}
+	err = padding.Add(&chunk, final, capacity) // add padding to plaintext
+	if err != nil {
+		return
+	}
+	if cw.framed {
+		return cw.sealFrame(chunk)
+	}
+	ct, err := cw.chipherer.Seal(chunk) // encrypt padded data, increment nonce
 	if err != nil {
 		return
 	}
-	ct := cw.chipherer.Seal(chunk) // encrypt padded data, increment nonce
-	_, err = cw.writer.Write(ct)   // write ciphertext to writer
+	_, err = cw.writer.Write(ct) // write ciphertext to writer
 	return
 
 }
 
+// Flush seals any buffered data in a shorter chunk and writes it immediately, even if
+// nothing is buffered. Only writers from NewFramedWriter can be flushed.
+func (cw *chunkWriter) Flush() (err error) {
+	if cw.err != nil {
+		return cw.err
+	}
+	if !cw.framed {
+		return errors.New("chunkwriter: only framed chunks can be flushed")
+	}
+	if err = cw.seal(false); err != nil {
+		cw.err = err
+	}
+	return
+}
+
 func (cw *chunkWriter) Close() (err error) {
-	return cw.seal(true)
+	if cw.err != nil {
+		return cw.err
+	}
+	defer cw.Destroy()
+	if err = cw.seal(true); err == nil && cw.parity != nil {
+		err = cw.parity.flush()
+	}
+	return
+}
+
+// Destroy wipes the key and any buffered plaintext. The final chunk is not written.
+func (cw *chunkWriter) Destroy() {
+	cw.chipherer.Destroy()
+	if cw.buf != nil {
+		securebuf.Wipe(cw.buf.Bytes())
+		cw.buf.Reset()
+	}
+	if cw.err == nil {
+		cw.err = errDestroyed
+	}
 }
 
 // return smaller int
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path"
 
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/securebuf"
 	"github.com/spf13/cobra"
 )
 
@@ -30,11 +32,14 @@ func init() {
 	this := RootCommand
 	cobra.EnableCommandSorting = false
 	this.Flags().SortFlags = false
+	cf.RevocationList = path.Join(path.Dir(defaultkey), "revoked")
 }
 
 // Execute is the main function. It starts the cobra commander for the RootCommand 'aenker',
 // parses arguments and flags, and finally executes the desired command.
 func Execute() {
+	// core dumps could contain private keys
+	_ = securebuf.DisableCoreDumps()
 	if err := RootCommand.Execute(); err != nil {
 		os.Exit(1)
 	}
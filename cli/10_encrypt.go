@@ -4,10 +4,13 @@
 package cli
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/ansemjo/aenker/ae"
+	"github.com/ansemjo/aenker/chunkstream"
 	cf "github.com/ansemjo/aenker/cli/cobraflags"
 	"github.com/spf13/cobra"
 )
@@ -20,6 +23,9 @@ func init() {
 func AddEncryptCommand(parent *cobra.Command) *cobra.Command {
 
 	var key *cf.Key32Flag
+	var format, padding, parity, suffix string
+	var sealed, force, flush bool
+	var chunksize int
 
 	var input *cf.FileFlag
 	var output *cf.FileFlag
@@ -29,21 +35,63 @@ func AddEncryptCommand(parent *cobra.Command) *cobra.Command {
 		Use:     "seal",
 		Aliases: []string{"encrypt", "e"},
 		Short:   "encrypt and protect a file",
-		Long:    "Encrypt a file for a recipient's public key and output authenticated ciphertext.",
-		Example: "  tar -cz * | aenker seal -p $PUBLICKEY > archive.tar.gz.ae",
+		Long: `Encrypt a file for a recipient's public key and output authenticated ciphertext.
+
+Keys which are expired, revoked or not allowed for encryption according to their
+keyfile or the revocation list are refused unless --force is given.
+
+Usually, input is buffered until a chunk is full. With --flush, the chunks are
+prefixed with their length and whatever was read is encrypted and written right
+away, so slowly growing input like a log file can be followed. Such files are
+opened like any other but cannot be served with random access.
+
+For long-term storage on media that may degrade, --parity K:M adds M parity
+chunks to every group of K chunks. Up to M damaged chunks per group can then be
+rebuilt with 'open --repair'. Files grow by about M/K.`,
+		Example: `  tar -cz * | aenker seal -p $PUBLICKEY > archive.tar.gz.ae
+  tail -f /var/log/syslog | aenker seal --flush -p $PUBLICKEY > syslog.ae
+  aenker seal --parity 16:2 -p $PUBLICKEY -i backup.tar -o backup.tar.ae`,
 
 		Args: cf.NoArgs,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return cf.CheckAll(cmd, args, key.Check, input.Open, output.Open)
+			if err := checkSealedbox(cmd, sealed, &format); err != nil {
+				return err
+			}
+			if err := checkChunksize(chunksize); err != nil {
+				return err
+			}
+			if err := checkPadding(padding); err != nil {
+				return err
+			}
+			if err := checkFlush(format, flush); err != nil {
+				return err
+			}
+			if err := checkParity(format, flush, parity); err != nil {
+				return err
+			}
+			if err := checkSuffix(cmd, suffix, true); err != nil {
+				return err
+			}
+			if err := cf.CheckAll(cmd, args, key.Check, input.Open, output.Open); err != nil {
+				return err
+			}
+			if err := key.Usable("encrypt", time.Now()); err != nil && !force {
+				return fmt.Errorf("%s (use --force to encrypt anyway)", err)
+			}
+			return nil
 		},
 
 		Run: func(cmd *cobra.Command, args []string) {
 
-			ae, err := ae.NewWriter(output.File, key.Key)
+			writer, err := newWriter(format, output.File, key)
 			fatal(err)
-			defer ae.Close()
+			defer writer.Close()
 
-			_, err = io.Copy(ae, input.File)
+			if flush {
+				err = copyFlush(writer.(chunkstream.FlushWriteCloser), input.File)
+			} else {
+				_, err = io.Copy(writer, input.File)
+			}
 			fatal(err)
 
 			return
@@ -55,6 +103,21 @@ func AddEncryptCommand(parent *cobra.Command) *cobra.Command {
 	// add required peer key flag
 	key = cf.AddKey32Flag(command, "peer", "p", "", "receiver's public key", nil)
 	command.MarkFlagRequired("peer")
+	profileFlag(command, "peer", "recipient")
+
+	// add file format flag
+	command.Flags().StringVar(&format, "format", "aenker", "output file format ("+formats+")")
+	command.Flags().BoolVar(&sealed, "sealedbox", false, "use libsodium sealed box format, same as --format sealedbox")
+	command.Flags().BoolVar(&force, "force", false, "encrypt for expired or revoked keys")
+	command.Flags().IntVar(&chunksize, "chunksize", ae.Chunksize, "chunksize of the aenker format")
+	command.Flags().StringVar(&padding, "padding", "full", "pad the final chunk: full or none")
+	command.Flags().BoolVar(&flush, "flush", false, "encrypt input as soon as it is read, e.g. from tail -f")
+	command.Flags().StringVar(&parity, "parity", "", "add parity chunks to repair damage, as data:parity chunks like 16:2")
+	command.Flags().StringVar(&suffix, "suffix", "", "derive output filename by adding this suffix to the input")
+	profileFlag(command, "chunksize", "chunksize")
+	profileFlag(command, "padding", "padding")
+	profileFlag(command, "parity", "parity")
+	profileFlag(command, "suffix", "suffix")
 
 	// add input/output flags
 	input = cf.AddFileFlag(command, "input", "i", "input file, plaintext (default: stdin)",
@@ -65,3 +128,25 @@ func AddEncryptCommand(parent *cobra.Command) *cobra.Command {
 	parent.AddCommand(command)
 	return command
 }
+
+// copyFlush copies from r to w and flushes after every read, so nothing waits in the
+// buffer of a partial chunk
+func copyFlush(w chunkstream.FlushWriteCloser, r io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -4,12 +4,17 @@
 package cli
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 
 	"github.com/ansemjo/aenker/ae"
+	"github.com/ansemjo/aenker/agent"
+	"github.com/ansemjo/aenker/chunkstream"
 	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/keyderivation"
 	"github.com/spf13/cobra"
 )
 
@@ -21,6 +26,8 @@ func init() {
 func AddDecryptCommand(parent *cobra.Command) *cobra.Command {
 
 	var key *cf.Key32Flag
+	var format, suffix string
+	var sealed, useagent, repair, salvage, skip bool
 	var input *cf.FileFlag
 	var output *cf.FileFlag
 
@@ -29,17 +36,54 @@ func AddDecryptCommand(parent *cobra.Command) *cobra.Command {
 		Use:     "open",
 		Aliases: []string{"decrypt", "d"},
 		Short:   "decrypt and authenticate a file",
-		Long:    "Decrypt a file and output authenticated plaintext.",
-		Example: "  aenker open -i archive.tar.gz.ae | tar -xz",
+		Long: `Decrypt a file and output authenticated plaintext.
+
+If ` + agent.SockEnv + ` is set and no key is given with -k, the keys held by
+the running agent are used instead.
+
+Files that were sealed with --parity can be opened from damaged media with
+--repair. Chunks that fail to authenticate are then rebuilt from the parity
+chunks of their group and reported on standard error.
+
+If a file is damaged beyond repair, --salvage decrypts every chunk on its own
+and outputs all those that authenticate. Lost ranges are filled with zeros, or
+left out with --skip, and reported on standard error. This needs the private key
+itself and the exit status is non-zero if anything was lost.`,
+		Example: `  aenker open -i archive.tar.gz.ae | tar -xz
+  aenker open --repair -i /mnt/tape/backup.tar.ae -o backup.tar
+  aenker open --salvage -i damaged.img.ae -o recovered.img`,
 
 		Args: cf.NoArgs,
 		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
 
-			// check in/out flags
+			// check format and in/out flags
+			if err = checkSealedbox(cmd, sealed, &format); err != nil {
+				return
+			}
+			if err = checkSuffix(cmd, suffix, false); err != nil {
+				return
+			}
+			if salvage && format != "aenker" {
+				return fmt.Errorf("--salvage is not supported in %s format", format)
+			}
+			if skip && !salvage {
+				return errors.New("--skip is only used with --salvage")
+			}
+			if repair {
+				ae.Repair = func(index uint64) {
+					fmt.Fprintf(os.Stderr, "Repaired chunk %d\n", index)
+				}
+			}
 			if err = cf.CheckAll(cmd, args, input.Open, output.Open); err != nil {
 				return
 			}
 
+			// use the agent unless a key is given explicitly
+			if os.Getenv(agent.SockEnv) != "" && !cmd.Flag("key").Changed && !salvage {
+				useagent = true
+				return
+			}
+
 			// check key flag
 			if err = key.Check(cmd, args); err != nil {
 				err = fmt.Errorf("key is required: %s", err)
@@ -50,10 +94,25 @@ func AddDecryptCommand(parent *cobra.Command) *cobra.Command {
 
 		Run: func(cmd *cobra.Command, args []string) {
 
-			ae, err := ae.NewReader(input.File, key.Key)
+			if salvage {
+				fatal(salvageFile(output.File, input.File, key, !skip))
+				return
+			}
+
+			var reader io.Reader
+			var err error
+			if useagent {
+				reader, err = newAgentReader(format, input.File)
+			} else {
+				reader, err = newReader(format, input.File, key)
+				key.Destroy() // the file key is derived already
+			}
 			fatal(err)
+			if d, ok := reader.(chunkstream.Destroyer); ok {
+				defer d.Destroy()
+			}
 
-			_, err = io.Copy(output.File, ae)
+			_, err = io.Copy(output.File, reader)
 			fatal(err)
 
 			return
@@ -63,7 +122,17 @@ func AddDecryptCommand(parent *cobra.Command) *cobra.Command {
 	command.Flags().SortFlags = false
 
 	// add required private key flag
-	key = cf.AddKey32Flag(command, "key", "k", defaultkey, "your private key", nil)
+	key = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "your private key", nil)
+	profileFlag(command, "key", "key")
+
+	// add file format flag
+	command.Flags().StringVar(&format, "format", "aenker", "input file format ("+formats+")")
+	command.Flags().BoolVar(&sealed, "sealedbox", false, "use libsodium sealed box format, same as --format sealedbox")
+	command.Flags().BoolVar(&repair, "repair", false, "rebuild damaged chunks of files with parity")
+	command.Flags().BoolVar(&salvage, "salvage", false, "output all chunks that authenticate and report lost ranges")
+	command.Flags().BoolVar(&skip, "skip", false, "leave out lost ranges instead of zero-filling them")
+	command.Flags().StringVar(&suffix, "suffix", "", "derive output filename by removing this suffix from the input")
+	profileFlag(command, "suffix", "suffix")
 
 	// add input/output flags
 	input = cf.AddFileFlag(command, "input", "i", "input file, ciphertext (default: stdin)",
@@ -74,3 +143,77 @@ func AddDecryptCommand(parent *cobra.Command) *cobra.Command {
 	parent.AddCommand(command)
 	return command
 }
+
+// salvageFile writes the plaintext of all chunks of a damaged file that authenticate
+// and reports the ranges of plaintext that were lost on standard error
+func salvageFile(w io.Writer, r *os.File, key *cf.Key32Flag, zerofill bool) error {
+	defer key.Destroy()
+
+	var kemseed []byte
+	if key.IsHybrid() {
+		kemseed = key.KEM
+	}
+	private, input, err := salvageKey(r, key)
+	if err != nil {
+		return err
+	}
+	lost, err := ae.Salvage(w, input, private, kemseed, zerofill)
+	if err != nil && err != chunkstream.ErrTruncated {
+		return err
+	}
+
+	var total int64
+	for _, l := range lost {
+		fmt.Fprintf(os.Stderr, "Lost bytes %d to %d (%d bytes)\n", l.Start, l.End-1, l.End-l.Start)
+		total += l.End - l.Start
+	}
+	if err == chunkstream.ErrTruncated {
+		fmt.Fprintln(os.Stderr, "The final chunk was not recovered, the end of the plaintext is missing or uncertain")
+	}
+	if len(lost) > 0 || err != nil {
+		return fmt.Errorf("file is damaged, %d bytes in %d ranges were lost", total, len(lost))
+	}
+	return nil
+
+}
+
+// salvageKey finds the private key or derived subkey for which any chunk of the file
+// authenticates. Every candidate is tried on the entire file, so input that cannot be
+// seeked is read into memory first. The returned reader is at the start of the file.
+func salvageKey(r *os.File, key *cf.Key32Flag) (private *[32]byte, input io.ReadSeeker, err error) {
+
+	if key.IsHybrid() || len(key.Derived) == 0 {
+		return key.Key, r, nil
+	}
+	input = r
+	if _, err = r.Seek(0, io.SeekCurrent); err != nil {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		input = bytes.NewReader(data)
+	}
+	start, err := input.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+
+	candidates := []*[32]byte{key.Key}
+	for _, label := range key.Derived {
+		candidates = append(candidates, keyderivation.Subkey(key.Key, label))
+	}
+	for _, private = range candidates {
+		if _, err = input.Seek(start, io.SeekStart); err != nil {
+			return
+		}
+		_, err = ae.Salvage(io.Discard, input, private, nil, false)
+		if err == nil || err == chunkstream.ErrTruncated || err == chunkstream.ErrFrameLength {
+			_, err = input.Seek(start, io.SeekStart)
+			return
+		}
+	}
+	// report the error of the master key
+	_, err = input.Seek(start, io.SeekStart)
+	return key.Key, input, err
+
+}
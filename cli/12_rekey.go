@@ -0,0 +1,122 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ansemjo/aenker/ae"
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	AddRekeyCommand(RootCommand)
+}
+
+// AddRekeyCommand adds the re-encryption subcommand to a cobra command.
+func AddRekeyCommand(parent *cobra.Command) *cobra.Command {
+
+	var key *cf.Key32Flag
+	var peers *cf.Key32ListFlag
+	var reencrypt, downgrade, force bool
+
+	command := &cobra.Command{
+		Use:   "rekey FILES...",
+		Short: "re-encrypt files for new recipients",
+		Long: `Re-encrypt files in place for a new set of recipients without writing any
+plaintext to disk. Each file is replaced atomically and keeps its name.
+
+The result uses a random data key, which is wrapped for every recipient in the
+header. If a file has such a wrapped data key already, only its header is
+rewritten. Note that a removed recipient who kept the data key of a file could
+still decrypt it, use --reencrypt to encrypt the data with a new data key.
+
+Files for hybrid X25519 + ML-KEM keys are refused, because the new header only
+uses X25519 and the result would lose its post-quantum protection. Use
+--downgrade to rekey them anyway.`,
+		Example: `  aenker rekey -k mykey -p alice.pub -p bob.pub archives/*.ae`,
+
+		Args: cobra.MinimumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err = cf.CheckAll(cmd, args, key.Check, peers.Check); err != nil {
+				return
+			}
+			for _, peer := range peers.Keys {
+				if peer.IsHybrid() {
+					return fmt.Errorf("%s: hybrid recipients are not supported", peer.File)
+				}
+				if err := peer.Usable("encrypt", time.Now()); err != nil && !force {
+					return fmt.Errorf("%s (use --force to encrypt anyway)", err)
+				}
+			}
+			return
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+
+			public := make([]*[32]byte, len(peers.Keys))
+			for i, peer := range peers.Keys {
+				public[i] = peer.Key
+			}
+
+			failed := 0
+			for _, file := range args {
+				headeronly, err := rekeyFile(file, key, reencrypt, downgrade, public)
+				switch {
+				case err != nil:
+					fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+					failed++
+				case headeronly:
+					fmt.Fprintf(os.Stderr, "%s: rewrote header\n", file)
+				default:
+					fmt.Fprintf(os.Stderr, "%s: re-encrypted\n", file)
+				}
+			}
+			key.Destroy()
+
+			if failed > 0 {
+				fatal(fmt.Errorf("failed to rekey %d of %d files", failed, len(args)))
+			}
+		},
+	}
+	command.Flags().SortFlags = false
+
+	// add private and recipient key flags
+	key = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "your private key", nil)
+	profileFlag(command, "key", "key")
+	peers = cf.AddKey32ListFlag(command, "peer", "p", "new recipient's public key (repeatable)")
+
+	command.Flags().BoolVar(&reencrypt, "reencrypt", false, "always encrypt with a new data key")
+	command.Flags().BoolVar(&downgrade, "downgrade", false, "rekey hybrid files without post-quantum protection")
+	command.Flags().BoolVar(&force, "force", false, "encrypt for expired or revoked keys")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// rekeyFile rekeys a single file through a temporary file in the same directory,
+// which replaces the original only if everything succeeded
+func rekeyFile(file string, key *cf.Key32Flag, reencrypt, downgrade bool, public []*[32]byte) (headeronly bool, err error) {
+
+	in, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	err = replaceFile(file, func(w io.Writer) (err error) {
+		headeronly, err = ae.Rekey(w, bufio.NewReader(in), key.Key, key.KEM, reencrypt, downgrade, public...)
+		return
+	})
+	if err == ae.ErrDowngrade {
+		err = fmt.Errorf("%s (use --downgrade to rekey anyway)", err)
+	}
+	return
+
+}
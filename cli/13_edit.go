@@ -0,0 +1,341 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ansemjo/aenker/ae"
+	"github.com/ansemjo/aenker/chunkstream"
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/blake2b"
+)
+
+// suffix of the sidecar file, which lists the recipients of an encrypted file
+const recipientsSuffix = ".recipients"
+
+func init() {
+	AddEditCommand(RootCommand)
+}
+
+// AddEditCommand adds the in-place editing subcommand to a cobra command.
+func AddEditCommand(parent *cobra.Command) *cobra.Command {
+
+	var key *cf.Key32Flag
+	var peers *cf.Key32ListFlag
+	var force bool
+
+	command := &cobra.Command{
+		Use:   "edit FILE",
+		Short: "edit an encrypted file in place",
+		Long: `Decrypt a file to a private temporary directory, preferably on the tmpfs in
+/dev/shm, and open it with $VISUAL or $EDITOR. When the editor exits successfully
+and the content changed, the file is encrypted again for the same recipients with
+the same framing, parity and chunksize and replaced atomically. The temporary copy
+is overwritten before it is removed and the original is left untouched if anything
+fails.
+
+The recipients of a file cannot be read from its header. Files with a single
+recipient are encrypted for your own key again. Otherwise, the public keys are
+read from a sidecar file FILE` + recipientsSuffix + ` with one key per line or they
+can be given with -p.`,
+		Example: `  aenker edit passwords.txt.ae
+  EDITOR=nano aenker edit -k mykey notes.md.ae`,
+
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err = key.Check(cmd, args); err != nil {
+				return
+			}
+			if cmd.Flag("peer").Changed {
+				err = peers.Check(cmd, args)
+			}
+			return
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			err := editFile(args[0], key, peers.Keys, force)
+			key.Destroy()
+			fatal(err)
+		},
+	}
+	command.Flags().SortFlags = false
+
+	// add private and recipient key flags
+	key = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "your private key", nil)
+	profileFlag(command, "key", "key")
+	peers = cf.AddKey32ListFlag(command, "peer", "p", "recipient's public key (repeatable, default: "+recipientsSuffix+" sidecar)")
+
+	command.Flags().BoolVar(&force, "force", false, "encrypt for expired or revoked keys")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// editFile decrypts a file to a temporary copy, runs the editor on it and replaces
+// the original if the content changed
+func editFile(file string, key *cf.Key32Flag, peers []*cf.Key32Flag, force bool) (err error) {
+
+	in, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	count, err := ae.Recipients(bufio.NewReader(in))
+	if err != nil {
+		return
+	}
+
+	// keep the framing, parity and chunksize of the original
+	if _, err = in.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	ae.Framed, ae.DataChunks, ae.ParityChunks, ae.Chunksize, err = ae.Parameters(bufio.NewReader(in))
+	if err != nil {
+		return
+	}
+
+	// recipients from flags, sidecar or header
+	sidecar := file + recipientsSuffix
+	if len(peers) == 0 {
+		if _, err := os.Stat(sidecar); err == nil {
+			if peers, err = cf.ReadKeyList(sidecar); err != nil {
+				return err
+			}
+		} else if count > 1 {
+			return fmt.Errorf("%s has %d recipients, list their public keys in %s or give them with -p",
+				file, count, sidecar)
+		}
+	}
+	for _, peer := range peers {
+		if peer.IsHybrid() && len(peers) > 1 {
+			return fmt.Errorf("%s: hybrid keys cannot be one of several recipients", peer.File)
+		}
+		if err := peer.Usable("encrypt", time.Now()); err != nil && !force {
+			return fmt.Errorf("%s (use --force to encrypt anyway)", err)
+		}
+	}
+
+	// private temporary copy
+	dir, err := privateTempDir()
+	if err != nil {
+		return
+	}
+	defer shredDir(dir)
+	plain := filepath.Join(dir, strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	tmp, err := os.OpenFile(plain, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	private, err := decryptCopy(tmp, in, key)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	before, err := hashFile(plain)
+	if err != nil {
+		return
+	}
+
+	// edit the copy
+	if err = runEditor(plain); err != nil {
+		return fmt.Errorf("editor failed, %s is unchanged: %s", file, err)
+	}
+	after, err := hashFile(plain)
+	if err != nil {
+		return
+	}
+	if bytes.Equal(before, after) {
+		fmt.Fprintf(os.Stderr, "%s: no changes\n", file)
+		return
+	}
+
+	// encrypt again and replace the original
+	err = replaceFile(file, func(w io.Writer) (err error) {
+		edited, err := os.Open(plain)
+		if err != nil {
+			return
+		}
+		defer edited.Close()
+		cw, err := editWriter(w, key, private, peers)
+		if err != nil {
+			return
+		}
+		if _, err = io.Copy(cw, edited); err != nil {
+			cw.(chunkstream.Destroyer).Destroy()
+			return
+		}
+		return cw.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("encryption failed, %s is unchanged: %s", file, err)
+	}
+	fmt.Fprintf(os.Stderr, "%s: saved\n", file)
+	return
+
+}
+
+// decryptCopy decrypts the file to out with the private key or one of its derived
+// subkeys and returns the key that succeeded
+func decryptCopy(out *os.File, in *os.File, key *cf.Key32Flag) (private *[32]byte, err error) {
+
+	candidates := []*[32]byte{key.Key}
+	if !key.IsHybrid() {
+		for _, label := range key.Derived {
+			candidates = append(candidates, keyderivation.Subkey(key.Key, label))
+		}
+	}
+
+	var first error
+	for _, private = range candidates {
+		if err = decryptTo(out, in, private, key.KEM); err == nil {
+			return private, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return nil, first
+
+}
+
+// decryptTo decrypts the entire file from the beginning with a single key
+func decryptTo(out *os.File, in *os.File, private *[32]byte, kemseed []byte) (err error) {
+	if _, err = in.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	if _, err = out.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	if err = out.Truncate(0); err != nil {
+		return
+	}
+	var reader io.Reader
+	if kemseed != nil {
+		reader, err = ae.NewHybridReader(bufio.NewReader(in), private, kemseed)
+	} else {
+		reader, err = ae.NewReader(bufio.NewReader(in), private)
+	}
+	if err != nil {
+		return
+	}
+	defer reader.(chunkstream.Destroyer).Destroy()
+	_, err = io.Copy(out, reader)
+	return
+}
+
+// editWriter encrypts for the given recipients or, if there are none, for the
+// public key of the private key that opened the file
+func editWriter(w io.Writer, key *cf.Key32Flag, private *[32]byte, peers []*cf.Key32Flag) (io.WriteCloser, error) {
+	switch len(peers) {
+	case 0:
+		public := keyderivation.Public(private)
+		if key.IsHybrid() {
+			kempub, err := keyderivation.KEMPublic(key.KEM)
+			if err != nil {
+				return nil, err
+			}
+			return ae.NewHybridWriter(w, public, kempub)
+		}
+		return ae.NewWriter(w, public)
+	case 1:
+		return newWriter("aenker", w, peers[0])
+	default:
+		public := make([]*[32]byte, len(peers))
+		for i, peer := range peers {
+			public[i] = peer.Key
+		}
+		return ae.NewMultiWriter(w, public...)
+	}
+}
+
+// privateTempDir creates a directory only accessible to the current user, preferably
+// on the tmpfs in /dev/shm so the plaintext never reaches a disk
+func privateTempDir() (dir string, err error) {
+	if stat, err := os.Stat("/dev/shm"); err == nil && stat.IsDir() {
+		if dir, err = os.MkdirTemp("/dev/shm", "aenker-edit-"); err == nil {
+			return dir, nil
+		}
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: /dev/shm is not available, the plaintext is written to %s\n", os.TempDir())
+	return os.MkdirTemp("", "aenker-edit-")
+}
+
+// runEditor runs $VISUAL or $EDITOR on a file and waits until it exits. Interrupts
+// are left to the editor, so the temporary files are always cleaned up afterwards.
+func runEditor(file string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	// the editor variable may contain arguments
+	child := exec.Command("sh", "-c", editor+` "$1"`, "sh", file)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return child.Run()
+}
+
+// hashFile computes a hash of a file's content to detect changes
+func hashFile(file string) (sum []byte, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	hash, _ := blake2b.New256(nil)
+	if _, err = io.Copy(hash, f); err != nil {
+		return
+	}
+	return hash.Sum(nil), nil
+}
+
+// shredDir overwrites all regular files in a directory with random data, including
+// backup and swap files of the editor, and removes the directory afterwards
+func shredDir(dir string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			if err := shred(path, info.Size()); err != nil {
+				fmt.Fprintf(os.Stderr, "WARNING: could not overwrite %s: %s\n", path, err)
+			}
+		}
+		return nil
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: could not remove %s: %s\n", dir, err)
+	}
+}
+
+// shred overwrites a file in place with random data
+func shred(file string, size int64) (err error) {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err = io.CopyN(f, rand.Reader, size); err != nil {
+		return
+	}
+	return f.Sync()
+}
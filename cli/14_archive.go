@@ -0,0 +1,353 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ansemjo/aenker/ae"
+	"github.com/ansemjo/aenker/archive"
+	"github.com/ansemjo/aenker/chunkstream"
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	AddArchiveCommand(RootCommand)
+}
+
+// AddArchiveCommand adds the archive container and its subcommands to a cobra command.
+func AddArchiveCommand(parent *cobra.Command) *cobra.Command {
+
+	command := &cobra.Command{
+		Use:     "archive",
+		Aliases: []string{"ar"},
+		Short:   "encrypted archives with random access to single files",
+		Long: `Pack files and directories into an encrypted archive. Unlike an encrypted
+tarball, every file is encrypted separately and an encrypted index records their
+names and positions, so listing an archive or extracting a single file only
+decrypts the index and the files that are needed.
+
+Patterns are matched against the full names in the archive like shell globs.
+Matching a directory includes everything below it.`,
+		Example: `  aenker archive create -p lGLD...AFBo= docs.aear docs/
+  aenker archive list -l docs.aear
+  aenker archive extract -C /tmp docs.aear 'docs/*.pdf'`,
+	}
+
+	AddArchiveCreateCommand(command)
+	AddArchiveListCommand(command)
+	AddArchiveExtractCommand(command)
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddArchiveCreateCommand adds the subcommand to create an archive.
+func AddArchiveCreateCommand(parent *cobra.Command) *cobra.Command {
+
+	var peer *cf.Key32Flag
+	var chunksize int
+	var force bool
+
+	command := &cobra.Command{
+		Use:   "create FILE PATHS...",
+		Short: "create an archive from files and directories",
+		Args:  cobra.MinimumNArgs(2),
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err = checkChunksize(chunksize); err != nil {
+				return
+			}
+			if err = peer.Check(cmd, args); err != nil {
+				return
+			}
+			if peer.IsHybrid() {
+				return errors.New("archives cannot be created for hybrid keys")
+			}
+			if err = peer.Usable("encrypt", time.Now()); err != nil && !force {
+				return fmt.Errorf("%s (use --force to encrypt anyway)", err)
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			fatal(createArchive(args[0], args[1:], peer.Key, chunksize, force))
+		},
+	}
+	command.Flags().SortFlags = false
+
+	// add recipient key flag
+	peer = cf.AddKey32Flag(command, "peer", "p", "", "recipient's public key", nil)
+	profileFlag(command, "peer", "recipient")
+	command.Flags().IntVar(&chunksize, "chunksize", ae.Chunksize, "chunksize of the archive")
+	profileFlag(command, "chunksize", "chunksize")
+	command.Flags().BoolVar(&force, "force", false, "overwrite FILE and encrypt for expired or revoked keys")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddArchiveListCommand adds the subcommand to list the entries of an archive.
+func AddArchiveListCommand(parent *cobra.Command) *cobra.Command {
+
+	var key *cf.Key32Flag
+	var long bool
+
+	command := &cobra.Command{
+		Use:     "list FILE [PATTERN]",
+		Aliases: []string{"ls"},
+		Short:   "list the entries of an archive",
+		Args:    cobra.RangeArgs(1, 2),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return key.Check(cmd, args)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			ar, file, err := openArchive(args[0], key)
+			fatal(err)
+			defer file.Close()
+			defer ar.Destroy()
+
+			matched, err := matchEntries(ar, args[1:])
+			fatal(err)
+			for _, n := range matched {
+				entry := &ar.Entries[n]
+				if long {
+					fmt.Printf("%s %10d %s %s\n", entry.Mode, entry.Size,
+						entry.ModTime.Local().Format("2006-01-02 15:04"), entry.Name)
+				} else {
+					fmt.Println(entry.Name)
+				}
+			}
+		},
+	}
+	command.Flags().SortFlags = false
+	key = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "your private key", nil)
+	profileFlag(command, "key", "key")
+	command.Flags().BoolVarP(&long, "long", "l", false, "show mode, size and modification time")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddArchiveExtractCommand adds the subcommand to extract entries from an archive.
+func AddArchiveExtractCommand(parent *cobra.Command) *cobra.Command {
+
+	var key *cf.Key32Flag
+	var directory string
+
+	command := &cobra.Command{
+		Use:     "extract FILE [PATTERN]",
+		Aliases: []string{"x"},
+		Short:   "extract entries from an archive",
+		Long: `Extract all or only the matching entries from an archive into the current
+directory or the one given with -C. Existing files are overwritten.`,
+		Args: cobra.RangeArgs(1, 2),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return key.Check(cmd, args)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			ar, file, err := openArchive(args[0], key)
+			fatal(err)
+			defer file.Close()
+			defer ar.Destroy()
+
+			matched, err := matchEntries(ar, args[1:])
+			fatal(err)
+			fatal(extractEntries(ar, matched, directory))
+		},
+	}
+	command.Flags().SortFlags = false
+	key = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "your private key", nil)
+	profileFlag(command, "key", "key")
+	command.Flags().StringVarP(&directory, "directory", "C", ".", "extract into this directory")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// createArchive walks all paths and adds them to a new archive file, which is removed
+// again if anything fails
+func createArchive(name string, paths []string, public *[32]byte, chunksize int, force bool) (err error) {
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(name, flags, 0644)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(name)
+		}
+	}()
+
+	out := bufio.NewWriter(file)
+	aw, err := archive.NewWriter(out, public, chunksize)
+	if err != nil {
+		return
+	}
+	defer aw.Destroy()
+
+	for _, root := range paths {
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsDir() && !info.Mode().IsRegular() {
+				fmt.Fprintf(os.Stderr, "WARNING: skipping %s: unsupported file type %s\n", p, info.Mode().Type())
+				return nil
+			}
+			entry := strings.TrimLeft(filepath.ToSlash(filepath.Clean(p)), "/")
+			if entry == "." {
+				return nil
+			}
+			if info.Mode().IsDir() {
+				return aw.Add(entry, info.Mode(), info.ModTime(), nil)
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return aw.Add(entry, info.Mode(), info.ModTime(), f)
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	if err = aw.Close(); err != nil {
+		return
+	}
+	return out.Flush()
+
+}
+
+// openArchive opens an archive file with a private key and destroys the key flag
+func openArchive(name string, key *cf.Key32Flag) (ar *archive.Reader, file *os.File, err error) {
+	defer key.Destroy()
+	if key.IsHybrid() {
+		return nil, nil, errors.New("archives cannot be opened with hybrid keys")
+	}
+	if file, err = os.Open(name); err != nil {
+		return
+	}
+	stat, err := file.Stat()
+	if err == nil {
+		ar, err = archive.NewReader(file, stat.Size(), key.Key)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return
+}
+
+// matchEntries returns the numbers of all entries matching an optional pattern,
+// including everything below matching directories
+func matchEntries(ar *archive.Reader, pattern []string) (matched []int, err error) {
+	if len(pattern) == 0 {
+		for n := range ar.Entries {
+			matched = append(matched, n)
+		}
+		return
+	}
+	glob := strings.TrimSuffix(pattern[0], "/")
+	if _, err = path.Match(glob, ""); err != nil {
+		return
+	}
+	for n, entry := range ar.Entries {
+		for name := entry.Name; name != "."; name = path.Dir(name) {
+			if ok, _ := path.Match(glob, name); ok {
+				matched = append(matched, n)
+				break
+			}
+		}
+	}
+	if len(matched) == 0 {
+		err = fmt.Errorf("no entries match %q", pattern[0])
+	}
+	return
+}
+
+// extractEntries writes entries below a directory and restores their modes and
+// modification times
+func extractEntries(ar *archive.Reader, entries []int, directory string) (err error) {
+
+	var dirs []*archive.Entry
+	for _, n := range entries {
+		entry := &ar.Entries[n]
+		target := filepath.Join(directory, filepath.FromSlash(entry.Name))
+
+		if entry.Mode.IsDir() {
+			if err = os.MkdirAll(target, 0700); err != nil {
+				return
+			}
+			dirs = append(dirs, entry)
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return
+		}
+		if err = extractFile(ar, n, target); err != nil {
+			return fmt.Errorf("%s: %s", entry.Name, err)
+		}
+	}
+
+	// restore directories last, since extracting files changes their mtime
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target := filepath.Join(directory, filepath.FromSlash(dirs[i].Name))
+		if err = os.Chmod(target, dirs[i].Mode.Perm()); err != nil {
+			return
+		}
+		if err = os.Chtimes(target, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return
+		}
+	}
+	return
+
+}
+
+// extractFile decrypts a single entry to the target file
+func extractFile(ar *archive.Reader, n int, target string) (err error) {
+	entry := &ar.Entries[n]
+	reader, err := ar.Open(n)
+	if err != nil {
+		return
+	}
+	defer reader.(chunkstream.Destroyer).Destroy()
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(file, reader); err != nil {
+		file.Close()
+		return
+	}
+	if err = file.Chmod(entry.Mode.Perm()); err != nil {
+		file.Close()
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	return os.Chtimes(target, entry.ModTime, entry.ModTime)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/gateway"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	AddServeCommand(RootCommand)
+}
+
+// AddServeCommand adds the decrypting HTTP gateway subcommand to a cobra command.
+func AddServeCommand(parent *cobra.Command) *cobra.Command {
+
+	var key *cf.Key32Flag
+	var listen, root, suffix string
+
+	command := &cobra.Command{
+		Use:   "serve",
+		Short: "serve decrypted files over HTTP",
+		Long: `Serve the decrypted content of encrypted files below a directory over HTTP.
+A request for /path/name is answered with the plaintext of path/name.ae, so the
+content type is detected from the original name. Range requests only decrypt the
+chunks that are needed and conditional requests like If-Modified-Since use the
+modification time of the encrypted file.
+
+There is no authentication, so only listen on addresses which are not reachable
+by untrusted clients or put the gateway behind a reverse proxy.`,
+		Example: "  aenker serve --listen 127.0.0.1:8080 --root /srv/blobs",
+
+		Args: cf.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if suffix == "" {
+				return errors.New("suffix cannot be empty")
+			}
+			if stat, err := os.Stat(root); err != nil || !stat.IsDir() {
+				return fmt.Errorf("root %q is not a directory", root)
+			}
+			return key.Check(cmd, args)
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handler := gateway.NewHandler(root, suffix, key.Key, key.KEM)
+			key.Destroy()
+			defer handler.Destroy()
+
+			server := &http.Server{
+				Addr:              listen,
+				Handler:           handler,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			fmt.Fprintf(os.Stderr, "Serving %s on http://%s/\n", root, listen)
+			fatal(server.ListenAndServe())
+		},
+	}
+	command.Flags().SortFlags = false
+
+	key = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "your private key", nil)
+	profileFlag(command, "key", "key")
+	command.Flags().StringVarP(&listen, "listen", "l", "127.0.0.1:8080", "listen on this address")
+	command.Flags().StringVarP(&root, "root", "r", ".", "serve files below this directory")
+	command.Flags().StringVar(&suffix, "suffix", ".ae", "suffix of the encrypted files")
+	profileFlag(command, "suffix", "suffix")
+
+	parent.AddCommand(command)
+	return command
+}
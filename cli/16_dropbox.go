@@ -0,0 +1,92 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ansemjo/aenker/ae"
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/dropbox"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	AddDropboxCommand(RootCommand)
+}
+
+// AddDropboxCommand adds the encrypting upload server subcommand to a cobra command.
+func AddDropboxCommand(parent *cobra.Command) *cobra.Command {
+
+	var peer *cf.Key32Flag
+	var listen, dir string
+	var maxsize int64
+	var chunksize int
+	var force bool
+
+	command := &cobra.Command{
+		Use:   "dropbox",
+		Short: "receive uploads and encrypt them immediately",
+		Long: `Start an HTTP server, which receives file uploads and encrypts them for a
+public key at the moment of receipt. Uploads are streamed to disk through the
+encryption, so the plaintext is never stored. Every file gets a random name and
+the uploader receives a JSON receipt with the SHA-256 hash of the ciphertext.
+
+Files can be uploaded with a browser, with a raw PUT request or as
+multipart/form-data with a POST request.`,
+		Example: `  aenker dropbox -p lGLD...AFBo= --dir /srv/inbox --max-size 512
+  curl -T report.pdf http://localhost:8080/`,
+
+		Args: cf.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err = checkChunksize(chunksize); err != nil {
+				return
+			}
+			if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+				return fmt.Errorf("%q is not a directory", dir)
+			}
+			if err = peer.Check(cmd, args); err != nil {
+				return
+			}
+			if peer.IsHybrid() && len(peer.KEM) != keyderivation.KEMPublicSize {
+				return errors.New("peer is not a hybrid public key")
+			}
+			if err = peer.Usable("encrypt", time.Now()); err != nil && !force {
+				return fmt.Errorf("%s (use --force to encrypt anyway)", err)
+			}
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handler := dropbox.NewHandler(dir, peer.Key, peer.KEM)
+			handler.MaxSize = maxsize << 20
+
+			server := &http.Server{
+				Addr:              listen,
+				Handler:           handler,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			fmt.Fprintf(os.Stderr, "Receiving uploads into %s on http://%s/\n", dir, listen)
+			fatal(server.ListenAndServe())
+		},
+	}
+	command.Flags().SortFlags = false
+
+	peer = cf.AddKey32Flag(command, "peer", "p", "", "recipient's public key", nil)
+	profileFlag(command, "peer", "recipient")
+	command.Flags().StringVarP(&listen, "listen", "l", "127.0.0.1:8080", "listen on this address")
+	command.Flags().StringVarP(&dir, "dir", "d", ".", "store encrypted uploads in this directory")
+	command.Flags().Int64Var(&maxsize, "max-size", 1024, "maximum size of an upload request in MiB, 0 for unlimited")
+	command.Flags().IntVar(&chunksize, "chunksize", ae.Chunksize, "chunksize of the aenker format")
+	profileFlag(command, "chunksize", "chunksize")
+	command.Flags().BoolVar(&force, "force", false, "encrypt for expired or revoked keys")
+
+	parent.AddCommand(command)
+	return command
+}
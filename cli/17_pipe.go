@@ -0,0 +1,196 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ansemjo/aenker/channel"
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func init() {
+	AddPipeCommand(RootCommand)
+}
+
+// AddPipeCommand adds the encrypted pipe and its subcommands to a cobra command.
+func AddPipeCommand(parent *cobra.Command) *cobra.Command {
+
+	command := &cobra.Command{
+		Use:   "pipe",
+		Short: "pipe data through an encrypted connection",
+		Long: `Connect standard input and output of two hosts through an encrypted and
+authenticated TCP connection, similar to netcat. The listening side always uses
+its private key and the connecting side must know the matching public key. If
+the connecting side uses a private key as well, both sides are authenticated and
+the listener can restrict connections to a list of peers.
+
+Data is sent as soon as it is read, so the pipe can be used interactively. The
+end of the stream is authenticated, so a connection that is cut off is reported
+as an error instead of a short read.`,
+		Example: `  aenker pipe listen :9000 > backup.tar
+  tar c ./data | aenker pipe connect -p server.pub host:9000`,
+	}
+
+	AddPipeListenCommand(command)
+	AddPipeConnectCommand(command)
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddPipeListenCommand adds the subcommand to accept a single connection.
+func AddPipeListenCommand(parent *cobra.Command) *cobra.Command {
+
+	var key *cf.Key32Flag
+	var peers *cf.Key32ListFlag
+
+	command := &cobra.Command{
+		Use:   "listen ADDR",
+		Short: "accept a single encrypted connection",
+		Long: `Listen on ADDR, accept a single connection and pipe it to standard input and
+output. Give the public keys of allowed peers with -p to reject anonymous and
+unknown peers. Otherwise the public key of an authenticated peer is printed to
+standard error.`,
+		Example: "  aenker pipe listen -p alice.pub 127.0.0.1:9000",
+
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err = key.Check(cmd, args); err != nil {
+				return
+			}
+			if cmd.Flag("peer").Changed {
+				if err = peers.Check(cmd, args); err != nil {
+					return
+				}
+				for _, peer := range peers.Keys {
+					if peer.Revoked != "" {
+						return fmt.Errorf("peer %s is revoked: %s", peer.File, peer.Revoked)
+					}
+				}
+			}
+			return
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			config := &channel.Config{Static: key.Key}
+			for _, peer := range peers.Keys {
+				config.Peers = append(config.Peers, peer.Key)
+			}
+			defer key.Destroy()
+
+			ln, err := net.Listen("tcp", args[0])
+			fatal(err)
+			fmt.Fprintf(os.Stderr, "Listening on %s\n", ln.Addr())
+			conn, err := ln.Accept()
+			ln.Close()
+			fatal(err)
+			defer conn.Close()
+
+			pipe, err := channel.Server(conn, config)
+			fatal(err)
+			if static := pipe.RemoteStatic(); static != nil {
+				fmt.Fprintf(os.Stderr, "Connection from %s with key %s\n",
+					conn.RemoteAddr(), base64(static[:]))
+			} else {
+				fmt.Fprintf(os.Stderr, "Anonymous connection from %s\n", conn.RemoteAddr())
+			}
+			fatal(pipeStdio(pipe))
+		},
+	}
+	command.Flags().SortFlags = false
+
+	key = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "your private key", nil)
+	profileFlag(command, "key", "key")
+	peers = cf.AddKey32ListFlag(command, "peer", "p", "allowed peer's public key (repeatable, default: anyone)")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddPipeConnectCommand adds the subcommand to connect to a listening pipe.
+func AddPipeConnectCommand(parent *cobra.Command) *cobra.Command {
+
+	var key, peer *cf.Key32Flag
+	var anonymous, force bool
+
+	command := &cobra.Command{
+		Use:   "connect ADDR",
+		Short: "connect to a listening pipe",
+		Long: `Connect to a pipe listening on ADDR, whose public key is given with -p, and
+pipe the connection to standard input and output. Your private key is used to
+authenticate yourself unless --anonymous is given.`,
+		Example: "  echo hello | aenker pipe connect -p server.pub host:9000",
+
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err = peer.Check(cmd, args); err != nil {
+				return
+			}
+			if peer.Key == nil {
+				return errors.New("the listener's public key is required")
+			}
+			if err = peer.Usable("encrypt", time.Now()); err != nil && !force {
+				return fmt.Errorf("%s (use --force to connect anyway)", err)
+			}
+			if !anonymous {
+				return key.Check(cmd, args)
+			}
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			config := &channel.Config{Remote: peer.Key}
+			if !anonymous {
+				config.Static = key.Key
+				defer key.Destroy()
+			}
+
+			conn, err := net.Dial("tcp", args[0])
+			fatal(err)
+			defer conn.Close()
+			pipe, err := channel.Client(conn, config)
+			fatal(err)
+			fatal(pipeStdio(pipe))
+		},
+	}
+	command.Flags().SortFlags = false
+
+	peer = cf.AddKey32Flag(command, "peer", "p", "", "listener's public key", nil)
+	key = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "your private key", nil)
+	profileFlag(command, "key", "key")
+	command.Flags().BoolVar(&anonymous, "anonymous", false, "do not authenticate with your private key")
+	command.Flags().BoolVar(&force, "force", false, "connect to expired or revoked keys")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// pipeStdio copies standard input to the connection and the connection to standard
+// output. The end of standard input is signalled to the peer with CloseWrite. After
+// the peer closed its side, remaining input is still sent unless it is a terminal.
+func pipeStdio(pipe *channel.Conn) (err error) {
+	sent := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(pipe, os.Stdin)
+		if err == nil {
+			err = pipe.CloseWrite()
+		}
+		sent <- err
+	}()
+	if _, err = io.Copy(os.Stdout, pipe); err != nil {
+		return
+	}
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return
+	}
+	return <-sent
+}
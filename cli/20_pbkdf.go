@@ -12,10 +12,14 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	cf "github.com/ansemjo/aenker/cli/cobraflags"
 	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -26,35 +30,88 @@ var initialState *terminal.State
 func init() {
 
 	// from: https://groups.google.com/d/msg/golang-nuts/kTVAbtee9UA/Y1F5MbASCQAJ
-	// remember initial terminal state
+	// remember initial terminal state, if stdin is a terminal
 	var err error
-	if initialState, err = terminal.GetState(syscall.Stdin); err != nil {
-		return
+	if initialState, err = terminal.GetState(syscall.Stdin); err == nil {
+		// and restore it on exit
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, os.Kill)
+		go func() {
+			<-c
+			_ = terminal.Restore(syscall.Stdin, initialState)
+			fmt.Print("\n")
+			os.Exit(0)
+		}()
 	}
-	// and restore it on exit
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, os.Kill)
-	go func() {
-		<-c
-		_ = terminal.Restore(syscall.Stdin, initialState)
-		fmt.Print("\n")
-		os.Exit(0)
-	}()
 
 	// AddPbkdfCommand adds the password-based key generator subcommand to a cobra command.
 	// It must be explicitly enabled with `-tags pbkdf` or you can use
 	// https://github.com/ansemjo/stdkdf instead.
 	AddPbkdfCommand = func(parent *cobra.Command) *cobra.Command {
 
-		var keyfile, salt string
+		var keyfile, salt, from string
+		var memory uint32
+		var calibrate time.Duration
+		var legacy bool
+		params := keyderivation.DefaultArgon2
 
 		command := &cobra.Command{
 			Use:   "pbkdf",
 			Short: "generate a password-derived keypair",
 			Long: `Generate and save a new Curve25519 keypair by deriving it from a password with
-Argon2i. The cost settings are predefined as time=32, memory=256MB, threads=4.`,
-			Example: "  aenker kg pbkdf -s mysaltstring -o mykey -p mykey.pub",
-			Args:    cf.NoArgs,
+Argon2id. The default cost settings are time=32, memory=256MB, threads=4 and can
+be changed with flags or calibrated to take a given duration on this machine.
+With --legacy, the fixed Argon2i settings of earlier versions are used, which are
+compatible with https://github.com/ansemjo/stdkdf.
+
+The algorithm, cost settings and salt are recorded in the header of the keyfile.
+Pass an existing keyfile with --from to derive the key again with exactly the
+same settings; the result is checked against the recorded public key. Keyfiles
+of earlier versions only recorded the salt and use the legacy settings.`,
+			Example: `  aenker kg pbkdf -s mysaltstring -f mykey
+  aenker kg pbkdf --calibrate 2s -f mykey
+  aenker kg pbkdf --legacy -s mysaltstring -f mykey
+  aenker kg pbkdf --from mykey -f restoredkey`,
+			Args: cf.NoArgs,
+			PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+
+				// read settings of an existing keyfile
+				if from != "" {
+					for _, f := range []string{"salt", "time", "memory", "threads", "argon2i", "calibrate", "legacy"} {
+						if cmd.Flag(f).Changed {
+							return fmt.Errorf("--%s conflicts with --from", f)
+						}
+					}
+					return nil
+				}
+
+				// fixed settings of earlier versions
+				if legacy {
+					for _, f := range []string{"time", "memory", "threads", "argon2i", "calibrate"} {
+						if cmd.Flag(f).Changed {
+							return fmt.Errorf("--%s conflicts with --legacy", f)
+						}
+					}
+					params = keyderivation.LegacyArgon2
+					return nil
+				}
+				params.Memory = memory * 1024
+
+				// estimate the time cost
+				if calibrate > 0 {
+					if cmd.Flag("time").Changed {
+						return errors.New("--time conflicts with --calibrate")
+					}
+					fmt.Fprintf(os.Stderr, "Calibrating for %s ... ", calibrate)
+					params = keyderivation.Calibrate(calibrate, params)
+					fmt.Fprintf(os.Stderr, "using %s\n", params)
+				}
+
+				if params.Time < 1 || params.Memory < 8*uint32(params.Threads) || params.Threads < 1 {
+					return fmt.Errorf("invalid cost settings: %s", params)
+				}
+				return
+			},
 			RunE: func(cmd *cobra.Command, args []string) (err error) {
 
 				// format returned errors
@@ -65,15 +122,32 @@ Argon2i. The cost settings are predefined as time=32, memory=256MB, threads=4.`,
 					}
 				}()
 
+				// recorded settings and public key
+				var recorded string
+				if from != "" {
+					if params, salt, recorded, err = readPbkdfHeader(from); err != nil {
+						return
+					}
+					fmt.Fprintf(os.Stderr, "Using %s salt=%q\n", params, salt)
+				}
+
 				// derive key from password
-				seckey := new([32]byte)
-				if err = getpasskey(seckey, salt, os.Stdin); err != nil {
+				buf := securebuf.New(32)
+				defer buf.Destroy()
+				seckey := buf.Key()
+				if err = getpasskey(seckey, salt, params, os.Stdin); err != nil {
 					return
 				}
 
+				// compare with recorded public key
+				if recorded != "" && recorded != base64(keyderivation.Public(seckey)[:]) {
+					return errors.New("derived key does not match the recorded public key")
+				}
+
 				// write to file and return pubkey
-				pubkey, err := writeKey(seckey, keyfile,
-					fmt.Sprintf("version: %s, salt: %s", RootCommand.Version, salt))
+				pubkey, err := writeKey(seckey, keyfile, "", nil,
+					fmt.Sprintf("version: %s", RootCommand.Version),
+					fmt.Sprintf("kdf: %s salt=%q", params, salt))
 				if err != nil {
 					return
 				}
@@ -97,7 +171,18 @@ Use the following command to encrypt files for this key:
 		command.Flags().StringVarP(&keyfile, "file", "f", defaultkey, "save key to this file")
 
 		// add the salt flag
-		command.Flags().StringVarP(&salt, "salt", "s", "aenker", "salt for argon2i key derivation")
+		command.Flags().StringVarP(&salt, "salt", "s", "aenker", "salt for argon2 key derivation")
+
+		// add the cost settings
+		command.Flags().Uint32VarP(&params.Time, "time", "t", params.Time, "argon2 time cost")
+		command.Flags().Uint32VarP(&memory, "memory", "m", params.Memory/1024, "argon2 memory cost in MiB")
+		command.Flags().Uint8Var(&params.Threads, "threads", params.Threads, "argon2 parallelism")
+		command.Flags().BoolVar(&params.Argon2i, "argon2i", false, "use legacy argon2i instead of argon2id")
+		command.Flags().DurationVar(&calibrate, "calibrate", 0, "choose time cost to take this long")
+		command.Flags().BoolVar(&legacy, "legacy", false, "use the argon2i settings of earlier versions and stdkdf")
+
+		// reuse settings of an existing keyfile
+		command.Flags().StringVar(&from, "from", "", "use settings recorded in this keyfile")
 
 		parent.AddCommand(command)
 		return command
@@ -106,7 +191,7 @@ Use the following command to encrypt files for this key:
 }
 
 // read password and derive key
-func getpasskey(key *[32]byte, salt string, reader io.Reader) (err error) {
+func getpasskey(key *[32]byte, salt string, params keyderivation.Argon2Params, reader io.Reader) (err error) {
 
 	var passwd []byte
 
@@ -134,9 +219,94 @@ func getpasskey(key *[32]byte, salt string, reader io.Reader) (err error) {
 	}
 
 	// derive key
-	k := keyderivation.Password(passwd, salt)
+	k := keyderivation.Password(passwd, salt, params)
 	copy(key[:], k)
 
 	return
 
 }
+
+// readPbkdfHeader parses the kdf settings and public key in the header of a keyfile
+// that was written by the pbkdf command. Keyfiles of earlier versions only have a
+// comment with the version and salt and use the legacy settings.
+func readPbkdfHeader(file string) (params keyderivation.Argon2Params, salt, pubkey string, err error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var found, legacy bool
+	var legacysalt string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "# your public key: ") {
+			pubkey = strings.TrimPrefix(line, "# your public key: ")
+		}
+
+		// comment "version: v0.3.2, salt: mysalt" of earlier versions
+		if strings.HasPrefix(line, "# comment: ") {
+			comment, e := strconv.Unquote(strings.TrimPrefix(line, "# comment: "))
+			if i := strings.Index(comment, ", salt: "); e == nil && strings.HasPrefix(comment, "version: ") && i >= 0 {
+				legacy, legacysalt = true, comment[i+8:]
+			}
+		}
+
+		if !strings.HasPrefix(line, "# kdf: ") {
+			continue
+		}
+		found = true
+
+		// salt is quoted and comes last
+		fields := strings.TrimPrefix(line, "# kdf: ")
+		i := strings.Index(fields, " salt=")
+		if i < 0 {
+			return params, "", "", errors.New("no salt in kdf header")
+		}
+		if salt, err = strconv.Unquote(fields[i+6:]); err != nil {
+			return
+		}
+
+		for n, field := range strings.Fields(fields[:i]) {
+			if n == 0 {
+				if field != "argon2id" && field != "argon2i" {
+					return params, "", "", fmt.Errorf("unknown kdf: %s", field)
+				}
+				params.Argon2i = field == "argon2i"
+				continue
+			}
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return params, "", "", fmt.Errorf("malformed kdf setting: %s", field)
+			}
+			v, e := strconv.ParseUint(kv[1], 10, 32)
+			if e != nil {
+				return params, "", "", e
+			}
+			switch kv[0] {
+			case "time":
+				params.Time = uint32(v)
+			case "memory":
+				params.Memory = uint32(v) * 1024
+			case "threads":
+				params.Threads = uint8(v)
+			default:
+				return params, "", "", fmt.Errorf("unknown kdf setting: %s", kv[0])
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	if !found {
+		if !legacy {
+			return params, "", "", fmt.Errorf("no kdf settings found in %s", file)
+		}
+		params, salt = keyderivation.LegacyArgon2, legacysalt
+	}
+	return
+}
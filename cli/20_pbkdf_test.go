@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build !nokeygen,pbkdf
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+func TestReadPbkdfHeader(t *testing.T) {
+
+	dir := t.TempDir()
+	key := new([32]byte)
+	key[0] = 42
+
+	// settings recorded by the pbkdf command
+	params := keyderivation.Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 2}
+	current := filepath.Join(dir, "current")
+	pubkey, err := writeKey(key, current, "", nil, "version: test",
+		"kdf: "+params.String()+` salt="my salt=\"x\""`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, salt, recorded, err := readPbkdfHeader(current)
+	if err != nil || p != params || salt != `my salt="x"` || recorded != pubkey {
+		t.Errorf("current: %s salt=%q pubkey=%q: %v", p, salt, recorded, err)
+	}
+
+	// keyfiles of earlier versions only recorded the salt in a comment
+	legacy := filepath.Join(dir, "legacy")
+	pubkey, err = writeKey(key, legacy, "version: v0.3.2, salt: my salt, with a comma", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, salt, recorded, err = readPbkdfHeader(legacy)
+	if err != nil || p != keyderivation.LegacyArgon2 || salt != "my salt, with a comma" || recorded != pubkey {
+		t.Errorf("legacy: %s salt=%q pubkey=%q: %v", p, salt, recorded, err)
+	}
+
+	// other keyfiles are rejected
+	other := filepath.Join(dir, "other")
+	if _, err = writeKey(key, other, "some comment", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = readPbkdfHeader(other); err == nil {
+		t.Error("keyfile without kdf settings was accepted")
+	}
+	broken := filepath.Join(dir, "broken")
+	os.WriteFile(broken, []byte("# kdf: scrypt time=1 salt=\"x\"\n"), 0600)
+	if _, _, _, err = readPbkdfHeader(broken); err == nil {
+		t.Error("unknown kdf was accepted")
+	}
+
+}
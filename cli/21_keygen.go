@@ -15,7 +15,9 @@ import (
 	"strconv"
 	"time"
 
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
 	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
 	"github.com/spf13/cobra"
 )
 
@@ -31,15 +33,33 @@ var AddPbkdfCommand = func(c *cobra.Command) *cobra.Command {
 // AddKeygenCommand add the key generator and pubkey converter subcommands to a cobra command.
 func AddKeygenCommand(parent *cobra.Command) *cobra.Command {
 
-	var keyfile, comment string
+	var keyfile, comment, expires string
+	var hybrid, words bool
+	var meta cf.KeyMetadata
 
 	command := &cobra.Command{
 		Use:     "keygen",
 		Aliases: []string{"kg", "gen"},
 		Short:   "generate a new key",
-		Long:    "Generate and save a new random Curve25519 keypair.",
-		Example: "  aenker kg -f mykey",
-		Args:    cobra.NoArgs,
+		Long: `Generate and save a new random Curve25519 keypair.
+
+With --hybrid, an additional ML-KEM-768 keypair is generated for post-quantum
+security. The hybrid private key can still open files that were encrypted for its
+Curve25519 part alone but its public key is much larger, so it is best distributed
+as a file.
+
+The keyfile can record an expiry date, the allowed usages and labels. Share them
+along with the public key with 'aenker pubkey --metadata', so that 'seal' can
+refuse to encrypt for expired keys.`,
+		Example: `  aenker kg -f mykey
+
+  # hybrid keypair for long-term archives
+  aenker kg --hybrid -f archivekey
+  aenker pk -k archivekey > archivekey.pub
+
+  # key for encryption only, which expires in a year
+  aenker kg --usage encrypt --expires 8760h --label backups`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
 
 			// format returned errors
@@ -50,14 +70,42 @@ func AddKeygenCommand(parent *cobra.Command) *cobra.Command {
 				}
 			}()
 
+			// parse expiry date or duration
+			if expires != "" {
+				if meta.Expires, err = parseExpiry(expires, time.Now()); err != nil {
+					return
+				}
+			}
+
+			// restore key from mnemonic words instead
+			if words {
+				key, err := readMnemonic(os.Stdin)
+				if err != nil {
+					return err
+				}
+				key.Meta = meta
+				pubkey, err := saveKey(key, keyfile, comment)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Restored key saved in %q.\nYour public key is: %s\n", keyfile, pubkey)
+				return nil
+			}
+
 			// generate new random key
-			seckey := new([32]byte)
+			buf := securebuf.New(32)
+			defer buf.Destroy()
+			seckey := buf.Key()
 			if _, err = io.ReadFull(rand.Reader, seckey[:]); err != nil {
 				return
 			}
 
+			if hybrid {
+				return keygenHybrid(seckey, keyfile, comment, &meta)
+			}
+
 			// write to file and return pubkey
-			pubkey, err := writeKey(seckey, keyfile, comment)
+			pubkey, err := writeKey(seckey, keyfile, comment, &meta)
 			if err != nil {
 				return
 			}
@@ -79,9 +127,20 @@ Use the following command to encrypt files for this key:
 	// define flags for parsing
 	command.Flags().StringVarP(&keyfile, "file", "f", defaultkey, "save key to this file")
 	command.Flags().StringVarP(&comment, "comment", "c", "", "add comment to keyfile")
+	command.Flags().BoolVar(&hybrid, "hybrid", false, "generate a hybrid X25519 + ML-KEM-768 key")
+	command.Flags().BoolVar(&words, "from-mnemonic", false, "restore key from mnemonic words on stdin")
+	command.Flags().StringVar(&expires, "expires", "", "expiry as a date (YYYY-MM-DD) or duration from now")
+	command.Flags().StringSliceVar(&meta.Usage, "usage", nil, "allowed key usages (encrypt, sign)")
+	command.Flags().StringArrayVar(&meta.Labels, "label", nil, "add a label to the keyfile")
 
 	// add subcommands
 	AddPubkeyCommand(command)
+	AddExportCommand(command)
+	AddImportCommand(command)
+	AddSplitCommand(command)
+	AddCombineCommand(command)
+	AddDeriveCommand(command)
+	AddRevokeCommand(command)
 	AddPbkdfCommand(command)
 
 	// add to parent
@@ -89,9 +148,85 @@ Use the following command to encrypt files for this key:
 	return command
 }
 
+// keygenHybrid generates the ML-KEM-768 part for a new hybrid key and saves it
+func keygenHybrid(seckey *[32]byte, keyfile, comment string, meta *cf.KeyMetadata) (err error) {
+
+	// generate new random seed
+	seed := securebuf.New(keyderivation.KEMSeedSize)
+	defer seed.Destroy()
+	if _, err = io.ReadFull(rand.Reader, seed.Bytes()); err != nil {
+		return
+	}
+	kempub, err := keyderivation.KEMPublic(seed.Bytes())
+	if err != nil {
+		return
+	}
+
+	// write concatenated keys to file
+	pubkey := base64(append(keyderivation.Public(seckey)[:], kempub...))
+	secret := concatSecret(seckey, seed.Bytes())
+	defer secret.Destroy()
+	if err = writeKeyFile(secret.Bytes(), pubkey, keyfile, comment, meta); err != nil {
+		return
+	}
+
+	// print info to stdout
+	fmt.Printf(`New hybrid key saved in %q.
+Export your public key to a file with:
+
+  aenker pubkey -k %s > %s.pub
+
+And use the following command to encrypt files for this key:
+
+  aenker seal -p %s.pub ...
+
+`, keyfile, keyfile, keyfile, keyfile)
+
+	return
+}
+
+// saveKey writes a classic or hybrid private key to a file and returns the
+// encoded public key
+func saveKey(key *cf.Key32Flag, file, comment string) (pubkey string, err error) {
+
+	if !key.IsHybrid() {
+		return writeKey(key.Key, file, comment, &key.Meta)
+	}
+
+	pub, err := publicKey(key)
+	if err != nil {
+		return
+	}
+	pubkey = base64(pub)
+	secret := concatSecret(key.Key, key.KEM)
+	defer secret.Destroy()
+	return pubkey, writeKeyFile(secret.Bytes(), pubkey, file, comment, &key.Meta)
+
+}
+
+// concatSecret concatenates a private key and the kemseed of a hybrid key in locked
+// memory, so the full secret never lands on the heap
+func concatSecret(key *[32]byte, kemseed []byte) *securebuf.Buffer {
+	buf := securebuf.New(32 + len(kemseed))
+	copy(buf.Bytes(), key[:])
+	copy(buf.Bytes()[32:], kemseed)
+	return buf
+}
+
 // writeKey is the internal function of the keygen, that writes a newly generated key
-// to a file with some metadata and comments
-func writeKey(key *[32]byte, file, comment string) (pubkey string, err error) {
+// to a file with some metadata and comments. Any extra lines are added to the header.
+func writeKey(key *[32]byte, file, comment string, meta *cf.KeyMetadata, extra ...string) (pubkey string, err error) {
+
+	// calculate public key and encode to base64
+	pubkey = base64(keyderivation.Public(key)[:])
+
+	return pubkey, writeKeyFile(key[:], pubkey, file, comment, meta, extra...)
+
+}
+
+// writeKeyFile writes the encoded secret key with a header of metadata and comments.
+// The creation date is filled in if the structured metadata does not have one yet.
+func writeKeyFile(key []byte, pubkey, file, comment string, meta *cf.KeyMetadata, extra ...string) (err error) {
 
 	// ensure directory exists
 	if err = os.MkdirAll(path.Dir(file), 0755); err != nil {
@@ -129,14 +264,12 @@ func writeKey(key *[32]byte, file, comment string) (pubkey string, err error) {
 		}
 		return "unknown"
 	}()
-	timestamp := time.Now().UTC().Format(time.RFC3339)
+	now := time.Now().UTC()
+	timestamp := now.Format(time.RFC3339)
 
 	// prepare a file header from metadata
 	header := fmt.Sprintf("# aenker secret key: %s@%s, %s\n", username, hostname, timestamp)
 
-	// calculate public key and encode to base64
-	pubkey = base64(keyderivation.Public(key)[:])
-
 	// append pubkey to header
 	header += fmt.Sprintf("# your public key: %s\n", pubkey)
 
@@ -145,12 +278,37 @@ func writeKey(key *[32]byte, file, comment string) (pubkey string, err error) {
 		header += fmt.Sprintf("# comment: %s\n", strconv.Quote(comment))
 	}
 
-	// save secret key to file
-	if _, err = kf.WriteString(header + base64(key[:]) + "\n"); err != nil {
-		return
+	// append any extra lines
+	for _, line := range extra {
+		header += fmt.Sprintf("# %s\n", line)
 	}
 
-	// return publickey
+	// append structured metadata
+	if meta == nil {
+		meta = new(cf.KeyMetadata)
+	}
+	if meta.Created.IsZero() {
+		meta.Created = now
+	}
+	header += meta.String()
+
+	// save secret key to file
+	_, err = kf.WriteString(header + base64(key) + "\n")
 	return
 
 }
+
+// parseExpiry parses either a date, a timestamp or a duration relative to now
+func parseExpiry(str string, now time.Time) (t time.Time, err error) {
+	if t, err = time.Parse("2006-01-02", str); err == nil {
+		return
+	}
+	if t, err = time.Parse(time.RFC3339, str); err == nil {
+		return
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return t, fmt.Errorf("invalid expiry %q: use YYYY-MM-DD or a duration like 720h", str)
+	}
+	return now.Add(d), nil
+}
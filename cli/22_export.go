@@ -0,0 +1,220 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build !nokeygen
+
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ansemjo/aenker/ae/age"
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/mnemonic"
+	"github.com/ansemjo/aenker/securebuf"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// AddExportCommand adds the private key exporter subcommand to a cobra command.
+func AddExportCommand(parent *cobra.Command) *cobra.Command {
+
+	var private *cf.Key32Flag
+	var agekey, words bool
+
+	command := &cobra.Command{
+		Use:   "export",
+		Short: "print private key in another encoding",
+		Long: `Print a private key in plain base64, as an age identity file or as a mnemonic
+list of words. Since the key flags also accept age identities and recipients,
+this can be used to convert keys in both directions.
+
+The mnemonic uses the English wordlist of BIP 39 and includes a checksum. It is
+meant to be printed on paper for disaster recovery and can be restored with
+"import --mnemonic". Hybrid keys result in several lines of 24 words.`,
+		Example: `  # use your key with age
+  aenker kg export --age > identity.txt
+  age -d -i identity.txt archive.tar.age
+
+  # convert an age identity to an aenker key
+  aenker kg export -k identity.txt > aenkerkey
+
+  # paper backup
+  aenker kg export --mnemonic | lpr`,
+
+		Args: cf.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if agekey && words {
+				return errors.New("--age conflicts with --mnemonic")
+			}
+			return private.Check(cmd, args)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+			switch {
+
+			case agekey:
+				if private.IsHybrid() {
+					return errors.New("hybrid keys cannot be used with age")
+				}
+				// format like the output of age-keygen
+				_, err = fmt.Printf("# created: %s\n# public key: %s\n%s\n",
+					time.Now().Format(time.RFC3339),
+					age.EncodeRecipient(keyderivation.Public(private.Key)),
+					age.EncodeIdentity(private.Key))
+
+			case words:
+				// encode each 32 bytes on a separate line
+				buf := concatSecret(private.Key, private.KEM)
+				defer buf.Destroy()
+				secret := buf.Bytes()
+				for len(secret) > 0 {
+					w, err := mnemonic.Encode(secret[:32])
+					if err != nil {
+						return err
+					}
+					if _, err = fmt.Println(strings.Join(w, " ")); err != nil {
+						return err
+					}
+					secret = secret[32:]
+				}
+
+			default:
+				secret := concatSecret(private.Key, private.KEM)
+				defer secret.Destroy()
+				_, err = fmt.Println(base64(secret.Bytes()))
+			}
+
+			return
+		},
+	}
+	command.Flags().SortFlags = false
+
+	// add the input keyfile flag
+	private = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "private key", os.Stdin)
+	profileFlag(command, "key", "key")
+
+	// export in other encodings
+	command.Flags().BoolVar(&agekey, "age", false, "print as age identity file")
+	command.Flags().BoolVar(&words, "mnemonic", false, "print as mnemonic words")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddImportCommand adds the private key importer subcommand to a cobra command.
+func AddImportCommand(parent *cobra.Command) *cobra.Command {
+
+	var keyfile string
+	var words bool
+	var private *cf.Key32Flag
+
+	command := &cobra.Command{
+		Use:   "import",
+		Short: "save private key from another encoding",
+		Long: `Read a private key and save it as a new keyfile. The key can be given in any
+encoding that the key flags accept, e.g. base64 or an age identity, or as the
+mnemonic list of words that was printed by "export --mnemonic" on stdin.`,
+		Example: `  aenker kg import --mnemonic -f restoredkey
+  aenker kg import -k identity.txt -f mykey`,
+
+		Args: cf.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if words {
+				if cmd.Flag("key").Changed {
+					return errors.New("--key conflicts with --mnemonic")
+				}
+				return nil
+			}
+			return private.Check(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+			// format returned errors
+			defer func() {
+				if err != nil {
+					err = fmt.Errorf("aenker keygen: %s", err)
+					fatal(err)
+				}
+			}()
+
+			key := private
+			if words {
+				if key, err = readMnemonic(os.Stdin); err != nil {
+					return
+				}
+			}
+			defer key.Destroy()
+
+			pubkey, err := saveKey(key, keyfile, "imported")
+			if err != nil {
+				return
+			}
+			fmt.Printf("Imported key saved in %q.\nYour public key is: %s\n", keyfile, pubkey)
+			return
+		},
+	}
+	command.Flags().SortFlags = false
+
+	// add the input key flag
+	private = cf.AddSecretKeyFlag(command, "key", "k", "", "key to import (default: stdin)", os.Stdin)
+	command.Flags().BoolVar(&words, "mnemonic", false, "read mnemonic words from stdin")
+
+	// add the output file flag
+	command.Flags().StringVarP(&keyfile, "file", "f", defaultkey, "save key to this file")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// readMnemonic reads lines of words until EOF or an empty line and decodes
+// every 24 words to 32 bytes of a private key
+func readMnemonic(reader io.Reader) (key *cf.Key32Flag, err error) {
+
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "Enter mnemonic words, finish with an empty line:")
+	}
+
+	var words []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.Fields(scanner.Text())
+		if len(line) == 0 && len(words) > 0 {
+			break
+		}
+		words = append(words, line...)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	var secret []byte
+	for len(words) > 0 {
+		if len(words) < 24 {
+			return nil, fmt.Errorf("expected groups of 24 words, %d remaining", len(words))
+		}
+		s, err := mnemonic.Decode(words[:24])
+		if err != nil {
+			return nil, err
+		}
+		secret = append(secret, s...)
+		words = words[24:]
+	}
+
+	switch len(secret) {
+	case 32, 32 + keyderivation.KEMSeedSize:
+	default:
+		securebuf.Wipe(secret)
+		return nil, fmt.Errorf("unexpected key length: %d bytes", len(secret))
+	}
+	return cf.SecretKey(securebuf.Copy(secret), "mnemonic"), nil
+
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build !nokeygen
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	b64 "encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/securebuf"
+	"github.com/ansemjo/aenker/shamir"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/blake2b"
+)
+
+// AddSplitCommand adds the secret sharing subcommand to a cobra command.
+func AddSplitCommand(parent *cobra.Command) *cobra.Command {
+
+	var private *cf.Key32Flag
+	var dir *cf.DirFlag
+	var shares, threshold int
+
+	command := &cobra.Command{
+		Use:   "split",
+		Short: "split private key into shares",
+		Long: `Split a private key into shares with Shamir's secret sharing, so that any
+threshold number of shares can restore the key while fewer shares reveal nothing
+about it. Each share is written to its own file, which is labeled with its index,
+the threshold and the fingerprint of the public key. Distribute the files to
+different people or places and restore the key with "combine".`,
+		Example: `  aenker kg split -n 5 -t 3 -d /media/usb`,
+
+		Args: cf.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return cf.CheckAll(cmd, args, private.Check, dir.Check)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+			secret := concatSecret(private.Key, private.KEM)
+			split, err := shamir.Split(secret.Bytes(), shares, threshold)
+			secret.Destroy()
+			if err != nil {
+				return
+			}
+
+			pub, err := publicKey(private)
+			if err != nil {
+				return
+			}
+			fingerprint := keyFingerprint(pub)
+
+			for _, s := range split {
+				name := path.Join(dir.Dir, fmt.Sprintf("%s.share-%d-of-%d", path.Base(private.File), s.Index, shares))
+				if err = writeShare(name, s, shares, fingerprint); err != nil {
+					return
+				}
+				fmt.Println(name)
+			}
+			return
+		},
+	}
+	command.Flags().SortFlags = false
+
+	// add the input keyfile flag
+	private = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "private key", nil)
+	profileFlag(command, "key", "key")
+
+	// add share flags
+	command.Flags().IntVarP(&shares, "shares", "n", 5, "total number of shares")
+	command.Flags().IntVarP(&threshold, "threshold", "t", 3, "number of shares needed to restore")
+	dir = cf.AddDirFlag(command, "directory", "d", ".", "output directory for the shares")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddCombineCommand adds the secret sharing restore subcommand to a cobra command.
+func AddCombineCommand(parent *cobra.Command) *cobra.Command {
+
+	var keyfile string
+
+	command := &cobra.Command{
+		Use:   "combine SHARE...",
+		Short: "restore private key from shares",
+		Long: `Restore a private key from a threshold number of shares, which were created with
+"split". The checksums of the shares are verified and the restored key is checked
+against the recorded fingerprint of the public key before saving it.`,
+		Example: `  aenker kg combine -f restored aenkerkey.share-1-of-5 aenkerkey.share-4-of-5 ...`,
+
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+			// format returned errors
+			defer func() {
+				if err != nil {
+					err = fmt.Errorf("aenker keygen: %s", err)
+					fatal(err)
+				}
+			}()
+
+			// read and check all shares
+			shares := make([]shamir.Share, len(args))
+			var fingerprint string
+			for i, name := range args {
+				var fp string
+				if shares[i], fp, err = readShare(name); err != nil {
+					return fmt.Errorf("%s: %s", name, err)
+				}
+				if fingerprint != "" && fp != fingerprint {
+					return fmt.Errorf("%s: share belongs to a different key", name)
+				}
+				fingerprint = fp
+			}
+
+			// combine and verify
+			secret, err := shamir.Combine(shares)
+			if err != nil {
+				return
+			}
+			key := cf.SecretKey(securebuf.Copy(secret), "shares")
+			defer key.Destroy()
+			pub, err := publicKey(key)
+			if err != nil {
+				return
+			}
+			if keyFingerprint(pub) != fingerprint {
+				return errors.New("restored key does not match the recorded fingerprint")
+			}
+
+			// write to file
+			pubkey, err := saveKey(key, keyfile, "restored from shares")
+			if err != nil {
+				return
+			}
+			fmt.Printf("Restored key saved in %q.\nYour public key is: %s\n", keyfile, pubkey)
+			return
+		},
+	}
+	command.Flags().SortFlags = false
+
+	// add the output file flag
+	command.Flags().StringVarP(&keyfile, "file", "f", defaultkey, "save restored key to this file")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// keyFingerprint is a short hash of a public key to identify it in shares
+func keyFingerprint(pub []byte) string {
+	sum := blake2b.Sum256(pub)
+	return hex.EncodeToString(sum[:16])
+}
+
+// shareChecksum is used to detect transcription errors and damage of shares
+func shareChecksum(s shamir.Share, fingerprint string) string {
+	h, _ := blake2b.New256(nil)
+	fmt.Fprintf(h, "%d:%d:%s:", s.Index, s.Threshold, fingerprint)
+	h.Write(s.Value)
+	return hex.EncodeToString(h.Sum(nil)[:4])
+}
+
+// writeShare writes a single share to a new labeled file
+func writeShare(name string, s shamir.Share, total int, fingerprint string) (err error) {
+
+	// create share files exclusively
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "# aenker key share %d of %d, any %d restore the key\n", s.Index, total, s.Threshold)
+	fmt.Fprintf(buf, "# created: %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(buf, "index: %d\n", s.Index)
+	fmt.Fprintf(buf, "threshold: %d\n", s.Threshold)
+	fmt.Fprintf(buf, "fingerprint: %s\n", fingerprint)
+	fmt.Fprintf(buf, "share: %s\n", base64(s.Value))
+	fmt.Fprintf(buf, "checksum: %s\n", shareChecksum(s, fingerprint))
+
+	_, err = f.Write(buf.Bytes())
+	return
+
+}
+
+// readShare parses a share file and verifies its checksum
+func readShare(name string) (s shamir.Share, fingerprint string, err error) {
+
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	// collect all fields
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return s, "", fmt.Errorf("malformed line: %q", line)
+		}
+		fields[kv[0]] = strings.TrimSpace(kv[1])
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	for _, f := range []string{"index", "threshold", "fingerprint", "share", "checksum"} {
+		if _, ok := fields[f]; !ok {
+			return s, "", fmt.Errorf("missing field %q", f)
+		}
+	}
+
+	index, err := strconv.ParseUint(fields["index"], 10, 8)
+	if err != nil {
+		return
+	}
+	threshold, err := strconv.ParseUint(fields["threshold"], 10, 8)
+	if err != nil {
+		return
+	}
+	value, err := b64.StdEncoding.DecodeString(fields["share"])
+	if err != nil {
+		return
+	}
+	s = shamir.Share{Index: byte(index), Threshold: byte(threshold), Value: value}
+	fingerprint = fields["fingerprint"]
+
+	if shareChecksum(s, fingerprint) != fields["checksum"] {
+		return s, "", errors.New("checksum mismatch, share is damaged")
+	}
+	return
+
+}
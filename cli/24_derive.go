@@ -0,0 +1,85 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build !nokeygen
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/spf13/cobra"
+)
+
+// AddDeriveCommand adds the subkey derivation subcommand to a cobra command.
+func AddDeriveCommand(parent *cobra.Command) *cobra.Command {
+
+	var private *cf.Key32Flag
+
+	command := &cobra.Command{
+		Use:   "derive LABEL",
+		Short: "derive a subkey for a label",
+		Long: `Derive a child key from your private key and a label, e.g. a project name or a
+time period, and print its public key. Distribute that public key instead of your
+main public key to scope or rotate keys.
+
+The label is appended to your keyfile, so that "open" automatically tries all
+listed subkeys when decrypting files. The subkeys themselves are never stored,
+as they can always be derived again from the private key and the label.`,
+		Example: `  aenker kg derive 2026-Q4
+  aenker pubkey --derive project/x`,
+
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return private.Check(cmd, args)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+			label := args[0]
+			if label == "" || strings.ContainsAny(label, "\r\n") {
+				return errors.New("label must be a single non-empty line")
+			}
+			if private.IsHybrid() {
+				return errors.New("subkeys cannot be derived from hybrid keys")
+			}
+
+			// append label to keyfile, unless it is listed already
+			listed := false
+			for _, l := range private.Derived {
+				listed = listed || l == label
+			}
+			if !listed {
+				if !private.IsFile() {
+					return errors.New("cannot list subkey when the key is not read from a keyfile")
+				}
+				kf, err := os.OpenFile(private.File, os.O_WRONLY|os.O_APPEND, 0600)
+				if err != nil {
+					return err
+				}
+				defer kf.Close()
+				if _, err = kf.WriteString(cf.DerivePrefix + label + "\n"); err != nil {
+					return err
+				}
+			}
+
+			// print public key of the subkey
+			pub := base64(keyderivation.Public(keyderivation.Subkey(private.Key, label))[:])
+			_, err = fmt.Printf("Subkey %q listed in %q.\nIts public key is: %s\n", label, private.File, pub)
+			return
+		},
+	}
+	command.Flags().SortFlags = false
+
+	// add the keyfile flag
+	private = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "private key", nil)
+	profileFlag(command, "key", "key")
+
+	parent.AddCommand(command)
+	return command
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build !nokeygen
+
+package cli
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/spf13/cobra"
+)
+
+func TestDeriveOpen(t *testing.T) {
+
+	dir := t.TempDir()
+	key := new([32]byte)
+	key[0] = 42
+	file := filepath.Join(dir, "key")
+	if _, err := writeKey(key, file, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// read the keyfile like the --key flag of open does
+	readKey := func() *cf.Key32Flag {
+		cmd := &cobra.Command{}
+		kf := cf.AddSecretKeyFlag(cmd, "key", "k", "", "private key", nil)
+		if err := cmd.ParseFlags([]string{"--key", file}); err != nil {
+			t.Fatal(err)
+		}
+		if err := kf.Check(cmd, nil); err != nil {
+			t.Fatal(err)
+		}
+		return kf
+	}
+
+	// seal to the public key of a subkey
+	plain := []byte("sealed to a derived public key")
+	child := keyderivation.Subkey(key, "project/x")
+	sealed := new(bytes.Buffer)
+	w, err := newWriter("aenker", sealed, &cf.Key32Flag{Key: keyderivation.Public(child)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the parent keyfile does not open it before the label is listed
+	if r, err := newReader("aenker", bytes.NewReader(sealed.Bytes()), readKey()); err == nil {
+		if _, err = io.ReadAll(r); err == nil {
+			t.Error("opened with an unlisted subkey")
+		}
+	}
+
+	// list the label with the derive command
+	root := &cobra.Command{}
+	AddDeriveCommand(root)
+	root.SetArgs([]string{"derive", "--key", file, "project/x"})
+	if err = root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	kf := readKey()
+	if len(kf.Derived) != 1 || kf.Derived[0] != "project/x" {
+		t.Fatalf("derived labels not read from keyfile: %q", kf.Derived)
+	}
+
+	// now the parent keyfile opens it
+	r, err := newReader("aenker", bytes.NewReader(sealed.Bytes()), kf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(opened, plain) {
+		t.Errorf("wrong plaintext %q: %v", opened, err)
+	}
+
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build !nokeygen
+
+package cli
+
+import (
+	"fmt"
+
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/spf13/cobra"
+)
+
+// AddRevokeCommand adds the key revocation subcommand to a cobra command.
+func AddRevokeCommand(parent *cobra.Command) *cobra.Command {
+
+	var public *cf.Key32Flag
+	var reason string
+
+	command := &cobra.Command{
+		Use:   "revoke",
+		Short: "add a public key to the revocation list",
+		Long: `Add a public key to the local revocation list, so that 'seal' refuses to encrypt
+files for it unless --force is given. Use this when a recipient's private key was
+lost or compromised.`,
+		Example: `  aenker kg revoke -p lGLD...AFBo= --reason "laptop stolen"`,
+
+		Args: cf.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return public.Check(cmd, args)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if public.Revoked != "" {
+				return fmt.Errorf("key is already revoked: %s", public.Revoked)
+			}
+			if reason == "" {
+				reason = "no reason given"
+			}
+			if err = cf.Revoke(public.Key, reason); err != nil {
+				return
+			}
+			_, err = fmt.Printf("Key revoked in %q.\n", cf.RevocationList)
+			return
+		},
+	}
+	command.Flags().SortFlags = false
+
+	// add required public key flag
+	public = cf.AddKey32Flag(command, "peer", "p", "", "public key to revoke", nil)
+	command.MarkFlagRequired("peer")
+	command.Flags().StringVar(&reason, "reason", "", "reason for the revocation")
+
+	parent.AddCommand(command)
+	return command
+}
@@ -4,9 +4,11 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/ansemjo/aenker/ae/age"
 	cf "github.com/ansemjo/aenker/cli/cobraflags"
 	"github.com/ansemjo/aenker/keyderivation"
 	"github.com/spf13/cobra"
@@ -20,6 +22,8 @@ func init() {
 func AddPubkeyCommand(parent *cobra.Command) *cobra.Command {
 
 	var private *cf.Key32Flag
+	var agekey, metadata bool
+	var derive string
 
 	command := &cobra.Command{
 		Use:     "pubkey",
@@ -28,13 +32,27 @@ func AddPubkeyCommand(parent *cobra.Command) *cobra.Command {
 		Long: `Calculate the public key of a Curve25519 private key by performing a base point
 multiplication. You could use any source of 32 random bytes as input.
 
-When called as "show" a formatted seal command will be printed.`,
+When called as "show" a formatted seal command will be printed. With --age the
+public key is printed as an age recipient instead. With --derive the public key
+of a subkey, which is derived from the private key and a label, is printed.
+
+With --metadata the public key is printed along with the expiry date, usages and
+labels from the keyfile, which can be saved and passed to 'seal -p' as a file.`,
 		Example: `  # show default key
   aenker show
 
   # new keypair from system randomness
   head -c32 /dev/urandom | base64 > mykey
-  aenker pk -k mykey > mykey.pub`,
+  aenker pk -k mykey > mykey.pub
+
+  # recipient for age users
+  aenker pk --age
+
+  # public key for a single project
+  aenker pk --derive project/x
+
+  # public keyfile with expiry date
+  aenker pk --metadata > mykey.pub`,
 
 		Args: cf.NoArgs,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
@@ -43,11 +61,32 @@ When called as "show" a formatted seal command will be printed.`,
 
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
 
+			// use a derived subkey instead
+			key := private
+			if derive != "" {
+				if private.IsHybrid() {
+					return errors.New("subkeys cannot be derived from hybrid keys")
+				}
+				key = &cf.Key32Flag{Key: keyderivation.Subkey(private.Key, derive), File: private.File}
+			}
+
 			// calculate public key
-			pub := base64(keyderivation.Public(private.Key)[:])
+			raw, err := publicKey(key)
+			if err != nil {
+				return
+			}
+			pub := base64(raw)
+			if agekey {
+				if key.IsHybrid() {
+					return errors.New("hybrid keys cannot be used with age")
+				}
+				pub = age.EncodeRecipient(keyderivation.Public(key.Key))
+			}
 
 			// write formatted seal command if called as "show"
-			if cmd.CalledAs() == "show" {
+			if metadata {
+				_, err = fmt.Printf("# aenker public key\n%s%s\n", private.Meta.String(), pub)
+			} else if cmd.CalledAs() == "show" {
 				_, err = fmt.Printf(
 					"Encrypt files to %q with:\n\n"+
 						"  aenker seal -p %s ...\n\n", private.File, pub)
@@ -61,7 +100,17 @@ When called as "show" a formatted seal command will be printed.`,
 	command.Flags().SortFlags = false
 
 	// add the input keyfile flag
-	private = cf.AddKey32Flag(command, "key", "k", defaultkey, "private key", os.Stdin)
+	private = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "private key", os.Stdin)
+	profileFlag(command, "key", "key")
+
+	// print in age encoding
+	command.Flags().BoolVar(&agekey, "age", false, "print as age recipient")
+
+	// print as public keyfile
+	command.Flags().BoolVar(&metadata, "metadata", false, "print with metadata from the keyfile")
+
+	// derive a subkey
+	command.Flags().StringVar(&derive, "derive", "", "print public key of the subkey with this label")
 
 	parent.AddCommand(command)
 	return command
@@ -0,0 +1,352 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build !windows
+
+package cli
+
+import (
+	"bufio"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ansemjo/aenker/agent"
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func init() {
+	AddAgentCommand(RootCommand)
+}
+
+// AddAgentCommand adds the key agent and its management subcommands to a cobra command.
+func AddAgentCommand(parent *cobra.Command) *cobra.Command {
+
+	var socket string
+	var foreground bool
+
+	command := &cobra.Command{
+		Use:   "agent [COMMAND [ARGS...]]",
+		Short: "hold private keys in a background agent",
+		Long: `Start a key agent, which holds private keys in locked memory and computes
+shared secrets for 'open' over a Unix socket, similar to ssh-agent. The private
+keys themselves are never handed out by the agent.
+
+Without a command, the agent is started in the background and the shell commands
+to set ` + agent.SockEnv + ` are printed. With a command, the agent only runs
+as long as that command and the variable is set in its environment. 'open' uses
+the agent whenever ` + agent.SockEnv + ` is set and no key is given with -k.`,
+		Example: `  eval $(aenker agent)
+  aenker agent add -k mykey
+  aenker open -i archive.tar.ae | tar -x
+
+  # agent for a single batch job
+  aenker agent -- sh -c 'aenker agent add && ./decrypt-all.sh'`,
+
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+			// create a private directory for the socket
+			if socket == "" {
+				dir, err := os.MkdirTemp("", "aenker-agent-")
+				if err != nil {
+					return err
+				}
+				socket = filepath.Join(dir, "agent.sock")
+			}
+			if socket, err = filepath.Abs(socket); err != nil {
+				return
+			}
+
+			// restart in the background and print environment
+			if !foreground && len(args) == 0 {
+				return daemonizeAgent(socket)
+			}
+
+			// listen on the socket
+			listener, err := agent.Listen(socket)
+			if err != nil {
+				return
+			}
+			cleanup := func() {
+				listener.Close()
+				os.Remove(socket)
+				os.Remove(filepath.Dir(socket)) // only succeeds if it is empty
+			}
+			defer cleanup()
+
+			// remove socket when interrupted
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+			go func() {
+				<-sig
+				cleanup()
+				os.Exit(0)
+			}()
+
+			ag := agent.New()
+			if len(args) == 0 {
+				err = ag.Serve(listener)
+				return
+			}
+
+			// run the command with the agent and exit with its status
+			go ag.Serve(listener)
+			child := exec.Command(args[0], args[1:]...)
+			child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+			child.Env = append(os.Environ(), agent.SockEnv+"="+socket)
+			if err = child.Run(); err != nil {
+				if exit, ok := err.(*exec.ExitError); ok {
+					cleanup()
+					os.Exit(exit.ExitCode())
+				}
+			}
+			return
+		},
+	}
+	command.Flags().SortFlags = false
+	command.Flags().SetInterspersed(false)
+	command.Flags().StringVarP(&socket, "socket", "a", "", "bind the agent to this socket")
+	command.Flags().BoolVarP(&foreground, "foreground", "D", false, "do not detach into the background")
+
+	AddAgentAddCommand(command)
+	AddAgentListCommand(command)
+	AddAgentRemoveCommand(command)
+	AddAgentLockCommand(command)
+
+	parent.AddCommand(command)
+	return command
+}
+
+// daemonizeAgent starts the agent in the foreground in a new session, waits for
+// its socket and prints the shell commands to use it
+func daemonizeAgent(socket string) (err error) {
+
+	self, err := os.Executable()
+	if err != nil {
+		return
+	}
+	daemon := exec.Command(self, "agent", "--foreground", "--socket", socket)
+	daemon.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = daemon.Start(); err != nil {
+		return
+	}
+
+	// wait until the socket accepts connections
+	for i := 0; ; i++ {
+		client, err := agent.Dial(socket)
+		if err == nil {
+			client.Close()
+			break
+		}
+		if i == 50 {
+			daemon.Process.Kill()
+			return fmt.Errorf("agent did not start: %s", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	fmt.Printf("%s=%s; export %s;\necho Agent pid %d;\n",
+		agent.SockEnv, socket, agent.SockEnv, daemon.Process.Pid)
+	return daemon.Process.Release()
+
+}
+
+// AddAgentAddCommand adds the subcommand to add keys to a running agent.
+func AddAgentAddCommand(parent *cobra.Command) *cobra.Command {
+
+	var private *cf.Key32Flag
+	var comment string
+
+	command := &cobra.Command{
+		Use:   "add",
+		Short: "add a private key to the agent",
+		Long: `Add a private key and all of its derived subkeys to the running agent. Hybrid
+keys cannot be added, since the agent only computes Curve25519 shared secrets.`,
+		Example: "  aenker agent add -k mykey",
+
+		Args: cf.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return private.Check(cmd, args)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if private.IsHybrid() {
+				return errors.New("hybrid keys cannot be added to the agent")
+			}
+			client, err := agent.DialEnv()
+			if err != nil {
+				return
+			}
+			defer client.Close()
+
+			if comment == "" {
+				comment = private.File
+			}
+			if err = client.Add(private.Key, comment); err != nil {
+				return
+			}
+			for _, label := range private.Derived {
+				subkey := keyderivation.Subkey(private.Key, label)
+				if err = client.Add(subkey, fmt.Sprintf("%s (derive: %s)", comment, label)); err != nil {
+					return
+				}
+			}
+			private.Destroy()
+			fmt.Fprintf(os.Stderr, "Identity added: %s\n", comment)
+			return
+		},
+	}
+	command.Flags().SortFlags = false
+	private = cf.AddSecretKeyFlag(command, "key", "k", defaultkey, "private key to add", nil)
+	profileFlag(command, "key", "key")
+	command.Flags().StringVarP(&comment, "comment", "c", "", "comment for the key (default: filename)")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddAgentListCommand adds the subcommand to list the keys of a running agent.
+func AddAgentListCommand(parent *cobra.Command) *cobra.Command {
+
+	command := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "list public keys held by the agent",
+		Args:    cf.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			client, err := agent.DialEnv()
+			if err != nil {
+				return
+			}
+			defer client.Close()
+
+			ids, err := client.List()
+			if err != nil {
+				return
+			}
+			if len(ids) == 0 {
+				fmt.Fprintln(os.Stderr, "The agent has no keys.")
+			}
+			for _, id := range ids {
+				fmt.Printf("%s %s\n", base64(id.Public[:]), id.Comment)
+			}
+			return
+		},
+	}
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddAgentRemoveCommand adds the subcommand to remove keys from a running agent.
+func AddAgentRemoveCommand(parent *cobra.Command) *cobra.Command {
+
+	var all bool
+
+	command := &cobra.Command{
+		Use:     "remove [PUBKEY...]",
+		Aliases: []string{"rm"},
+		Short:   "remove keys from the agent",
+		Long:    "Remove the keys with the given public keys from the agent and wipe them from memory.",
+		Example: `  aenker agent remove $(aenker pk -k mykey)
+  aenker agent remove --all`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if all == (len(args) > 0) {
+				return errors.New("give either public keys or --all")
+			}
+			client, err := agent.DialEnv()
+			if err != nil {
+				return
+			}
+			defer client.Close()
+
+			if all {
+				return client.RemoveAll()
+			}
+			for _, arg := range args {
+				raw, err := b64.StdEncoding.DecodeString(arg)
+				if err != nil || len(raw) != 32 {
+					return fmt.Errorf("invalid public key %q", arg)
+				}
+				public := new([32]byte)
+				copy(public[:], raw)
+				if err = client.Remove(public); err != nil {
+					return err
+				}
+			}
+			return
+		},
+	}
+	command.Flags().BoolVar(&all, "all", false, "remove all keys")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddAgentLockCommand adds the subcommands to lock and unlock a running agent.
+func AddAgentLockCommand(parent *cobra.Command) *cobra.Command {
+
+	lock := func(cmd *cobra.Command, args []string) (err error) {
+		client, err := agent.DialEnv()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+
+		passphrase, err := readPassphrase()
+		if err != nil {
+			return
+		}
+		if cmd.Name() == "lock" {
+			return client.Lock(passphrase)
+		}
+		return client.Unlock(passphrase)
+	}
+
+	parent.AddCommand(&cobra.Command{
+		Use:   "lock",
+		Short: "lock the agent with a passphrase",
+		Long: `Lock the agent with a passphrase. While it is locked, it neither lists
+nor uses any keys until it is unlocked with the same passphrase.`,
+		Args: cf.NoArgs,
+		RunE: lock,
+	})
+	command := &cobra.Command{
+		Use:   "unlock",
+		Short: "unlock the agent",
+		Args:  cf.NoArgs,
+		RunE:  lock,
+	}
+
+	parent.AddCommand(command)
+	return command
+}
+
+// readPassphrase reads a passphrase from the terminal or a line from stdin
+func readPassphrase() (passphrase []byte, err error) {
+	stdin := int(os.Stdin.Fd())
+	if terminal.IsTerminal(stdin) {
+		fmt.Fprint(os.Stderr, "Enter passphrase: ")
+		passphrase, err = terminal.ReadPassword(stdin)
+		fmt.Fprintln(os.Stderr)
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		if err = scanner.Err(); err == nil {
+			err = errors.New("no passphrase given")
+		}
+		return
+	}
+	return scanner.Bytes(), nil
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/vault"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func init() {
+	AddVaultCommand(RootCommand)
+}
+
+// the default vault next to the default secret key
+var defaultvault = path.Join(path.Dir(defaultkey), "vault.ae")
+
+// vaultFlags are the common flags of all vault subcommands
+type vaultFlags struct {
+	key  *cf.Key32Flag
+	file string
+}
+
+// addVaultFlags adds the private key and vault file flags to a command
+func addVaultFlags(cmd *cobra.Command) (vf *vaultFlags) {
+	vf = new(vaultFlags)
+	cmd.Flags().SortFlags = false
+	vf.key = cf.AddSecretKeyFlag(cmd, "key", "k", defaultkey, "your private key", nil)
+	profileFlag(cmd, "key", "key")
+	cmd.Flags().StringVarP(&vf.file, "vault", "f", defaultvault, "vault file")
+	profileFlag(cmd, "vault", "vault")
+	cmd.PreRunE = vf.key.Check
+	return
+}
+
+// open decrypts the vault and destroys the key flag
+func (vf *vaultFlags) open() (*vault.Vault, error) {
+	defer vf.key.Destroy()
+	return vault.Open(vf.file, vf.key.Key, vf.key.KEM)
+}
+
+// AddVaultCommand adds the secrets vault and its subcommands to a cobra command.
+func AddVaultCommand(parent *cobra.Command) *cobra.Command {
+
+	command := &cobra.Command{
+		Use:   "vault",
+		Short: "store named secrets in an encrypted file",
+		Long: `Store many small secrets like passwords and tokens under a name in a single
+file, which is encrypted for your own public key. Every change re-encrypts the
+vault and replaces the file atomically. The secrets can be printed one by one or
+passed to a command in its environment.`,
+		Example: `  aenker vault set db/password
+  aenker vault get db/password
+  aenker vault exec -- ./deploy.sh`,
+	}
+
+	AddVaultGetCommand(command)
+	AddVaultSetCommand(command)
+	AddVaultListCommand(command)
+	AddVaultRemoveCommand(command)
+	AddVaultExecCommand(command)
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddVaultGetCommand adds the subcommand to print a secret.
+func AddVaultGetCommand(parent *cobra.Command) *cobra.Command {
+
+	var vf *vaultFlags
+
+	command := &cobra.Command{
+		Use:   "get NAME",
+		Short: "print a secret",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			v, err := vf.open()
+			fatal(err)
+			defer v.Destroy()
+			value, ok := v.Get(args[0])
+			if !ok {
+				fatal(fmt.Errorf("no secret named %q", args[0]))
+			}
+			_, err = os.Stdout.Write(value)
+			fatal(err)
+		},
+	}
+	vf = addVaultFlags(command)
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddVaultSetCommand adds the subcommand to store a secret.
+func AddVaultSetCommand(parent *cobra.Command) *cobra.Command {
+
+	var vf *vaultFlags
+
+	command := &cobra.Command{
+		Use:   "set NAME",
+		Short: "store a secret",
+		Long: `Store a secret under a name, replacing any previous value. The value is read
+from the terminal without echo or from stdin, so it never appears in the process
+list or shell history.`,
+		Example: `  aenker vault set db/password
+  aenker vault set tls/key < server.key`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			v, err := vf.open()
+			fatal(err)
+			defer v.Destroy()
+			value, err := readSecret(args[0])
+			fatal(err)
+			fatal(v.Set(args[0], value))
+			fatal(v.Save())
+		},
+	}
+	vf = addVaultFlags(command)
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddVaultListCommand adds the subcommand to list the names of all secrets.
+func AddVaultListCommand(parent *cobra.Command) *cobra.Command {
+
+	var vf *vaultFlags
+
+	command := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "list the names of all secrets",
+		Args:    cf.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			v, err := vf.open()
+			fatal(err)
+			defer v.Destroy()
+			for _, name := range v.List() {
+				fmt.Println(name)
+			}
+		},
+	}
+	vf = addVaultFlags(command)
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddVaultRemoveCommand adds the subcommand to remove secrets.
+func AddVaultRemoveCommand(parent *cobra.Command) *cobra.Command {
+
+	var vf *vaultFlags
+
+	command := &cobra.Command{
+		Use:     "rm NAME...",
+		Aliases: []string{"remove"},
+		Short:   "remove secrets",
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			v, err := vf.open()
+			fatal(err)
+			defer v.Destroy()
+			for _, name := range args {
+				if !v.Remove(name) {
+					fatal(fmt.Errorf("no secret named %q", name))
+				}
+			}
+			fatal(v.Save())
+		},
+	}
+	vf = addVaultFlags(command)
+
+	parent.AddCommand(command)
+	return command
+}
+
+// AddVaultExecCommand adds the subcommand to run a command with secrets in its environment.
+func AddVaultExecCommand(parent *cobra.Command) *cobra.Command {
+
+	var vf *vaultFlags
+	var selected []string
+
+	command := &cobra.Command{
+		Use:   "exec [flags] -- COMMAND [ARGS...]",
+		Short: "run a command with secrets in its environment",
+		Long: `Run a command with secrets from the vault as additional environment variables
+and exit with its status. By default, all secrets are passed and the variable
+names are derived from the secret names in upper case, with any characters other
+than letters, digits and underscores replaced, e.g. db/password is DB_PASSWORD.
+Use -s NAME to pass only some secrets or -s NAME=VAR to choose the variable.`,
+		Example: `  aenker vault exec -- ./deploy.sh
+  aenker vault exec -s db/password=PGPASSWORD -- psql -h db.example.com`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			v, err := vf.open()
+			fatal(err)
+
+			// map secrets to variable names
+			if len(selected) == 0 {
+				selected = v.List()
+			}
+			env := os.Environ()
+			for _, sel := range selected {
+				name, variable := sel, envName(sel)
+				if i := strings.LastIndex(sel, "="); i >= 0 {
+					name, variable = sel[:i], sel[i+1:]
+				}
+				value, ok := v.Get(name)
+				if !ok {
+					v.Destroy()
+					fatal(fmt.Errorf("no secret named %q", name))
+				}
+				env = append(env, variable+"="+string(value))
+			}
+			v.Destroy()
+
+			// run the command and exit with its status
+			child := exec.Command(args[0], args[1:]...)
+			child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+			child.Env = env
+			if err = child.Run(); err != nil {
+				if exit, ok := err.(*exec.ExitError); ok {
+					os.Exit(exit.ExitCode())
+				}
+				fatal(err)
+			}
+		},
+	}
+	vf = addVaultFlags(command)
+	command.Flags().SetInterspersed(false)
+	command.Flags().StringArrayVarP(&selected, "secret", "s", nil, "pass only this secret, optionally as NAME=VAR (repeatable)")
+
+	parent.AddCommand(command)
+	return command
+}
+
+// envName derives an environment variable name from a secret name
+func envName(name string) string {
+	env := []byte(strings.ToUpper(name))
+	for i, c := range env {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			env[i] = '_'
+		}
+	}
+	if len(env) > 0 && env[0] >= '0' && env[0] <= '9' {
+		return "_" + string(env)
+	}
+	return string(env)
+}
+
+// readSecret reads a value from the terminal without echo or all of stdin
+func readSecret(name string) ([]byte, error) {
+	stdin := int(os.Stdin.Fd())
+	if terminal.IsTerminal(stdin) {
+		fmt.Fprintf(os.Stderr, "Enter value for %s: ", name)
+		defer fmt.Fprintln(os.Stderr)
+		return terminal.ReadPassword(stdin)
+	}
+	return io.ReadAll(os.Stdin)
+}
@@ -8,20 +8,39 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
+	"strings"
 
+	"github.com/ansemjo/aenker/ae/age"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
 	"github.com/spf13/cobra"
 )
 
 type Key32Flag struct {
-	Key   *[32]byte
-	File  string
-	Check func(cmd *cobra.Command, args []string) error
+	Key     *[32]byte
+	KEM     []byte      // ML-KEM-768 seed or encapsulation key if this is a hybrid key
+	Derived []string    // labels of derived subkeys listed in the keyfile
+	Meta    KeyMetadata // structured metadata in the keyfile
+	Revoked string      // reason for revocation if the public key is in the RevocationList
+	Secret  bool        // warn when the key is given as a literal argument
+	File    string
+	Check   func(cmd *cobra.Command, args []string) error
+
+	buf *securebuf.Buffer // locked memory of Key and KEM, if they were decoded into it
 }
 
+// Aliases are names for keys, which can be given instead of the keys themselves.
+// The values may be anything that is accepted by a Key32Flag.
+var Aliases = map[string]string{}
+
 // AddKey32Flag adds a flag to a command, which can either be a valid base64
-// string or a filename for a 32 byte key. Optionally reads from stdin.
+// string, an age recipient or identity, a reference to a registered KeySource
+// like "env:NAME", a name in Aliases or a filename for a 32 byte key.
+// Optionally reads from stdin. Hybrid keys, which have an additional
+// ML-KEM-768 part, are accepted as base64 strings as well.
 func AddKey32Flag(cmd *cobra.Command, flag, short, defval, usage string, fallback *os.File) (kf *Key32Flag) {
 
 	// add flag to command
@@ -30,78 +49,277 @@ func AddKey32Flag(cmd *cobra.Command, flag, short, defval, usage string, fallbac
 	// return struct with check function for PreRunE
 	return &Key32Flag{
 		Check: func(cmd *cobra.Command, args []string) (err error) {
-			if cmd.Flag(flag).Changed || defval != "" {
-
-				// given string is a valid key
-				if is32ByteBase64Encoded(*str) {
-					kf.Key, err = decodeKey(*str)
-					kf.File = "argument"
-
-				} else {
-					// assume any other string to be a filename
-					var file *os.File
-					file, err = os.Open(*str)
-					if err != nil {
-						return err
-					}
-					defer file.Close()
-					kf.Key, err = decodeKeyFile(file)
-					kf.File = file.Name()
-				}
+			if cmd.Flag(flag).Changed || *str != "" {
+				err = kf.resolve(*str, flag)
 
 			} else if fallback != nil {
 				// if flag was not given but a fallback was defined
-				kf.Key, err = decodeKeyFile(fallback)
+				err = decodeKeyFile(fallback, kf)
 				kf.File = fallback.Name()
 			}
 
+			// consult the revocation list for recipients, private keys must still
+			// open files that were sealed before
+			if err == nil && kf.Key != nil && !kf.Secret {
+				kf.Revoked, err = checkRevoked(kf.Key)
+			}
+
 			// if neither Key will remain nil!
 			return
 		},
 	}
 }
 
+// resolve decodes a key from any of the accepted flag values
+func (kf *Key32Flag) resolve(value, flag string) (err error) {
+
+	// resolve named keys
+	if alias, ok := Aliases[value]; ok {
+		value = alias
+	}
+
+	// given string is a valid key
+	if isKey(value) {
+		kf.Key, kf.KEM, kf.buf, err = decodeKey(value)
+		kf.File = "argument"
+		if kf.Secret {
+			warnLiteral(flag)
+		}
+		return
+	}
+
+	// resolve key from a registered source
+	if source, ref, ok := keySource(value); ok {
+		rc, err := source(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %s", value, err)
+		}
+		defer rc.Close()
+		kf.File = value
+		return decodeKeyReader(rc, value, kf)
+	}
+
+	// assume any other string to be a filename
+	file, err := os.Open(value)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	kf.File = file.Name()
+	return decodeKeyFile(file, kf)
+
+}
+
+// Key32ListFlag is a repeatable flag of public keys, e.g. for several recipients.
+type Key32ListFlag struct {
+	Keys  []*Key32Flag
+	Check func(cmd *cobra.Command, args []string) error
+}
+
+// AddKey32ListFlag adds a repeatable flag to a command, whose values are accepted like
+// those of AddKey32Flag. All of them are checked against the revocation list.
+func AddKey32ListFlag(cmd *cobra.Command, flag, short, usage string) (kl *Key32ListFlag) {
+
+	// add flag to command
+	strs := cmd.Flags().StringArrayP(flag, short, nil, usage)
+
+	// return struct with check function for PreRunE
+	return &Key32ListFlag{
+		Check: func(cmd *cobra.Command, args []string) (err error) {
+			kl.Keys = nil
+			for _, value := range *strs {
+				kf := new(Key32Flag)
+				if err = kf.resolve(value, flag); err != nil {
+					return
+				}
+				if kf.Revoked, err = checkRevoked(kf.Key); err != nil {
+					return
+				}
+				kl.Keys = append(kl.Keys, kf)
+			}
+			if len(kl.Keys) == 0 {
+				return fmt.Errorf("at least one --%s is required", flag)
+			}
+			return
+		},
+	}
+}
+
+// ReadKeyList reads a list of public keys from a file with one key per line. Every line
+// is accepted like the value of a Key32Flag, blank lines and comments with # are skipped.
+func ReadKeyList(name string) (keys []*Key32Flag, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		value := strings.TrimSpace(scanner.Text())
+		if value == "" || strings.HasPrefix(value, "#") {
+			continue
+		}
+		kf := new(Key32Flag)
+		if err = kf.resolve(value, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, line, err)
+		}
+		if kf.Revoked, err = checkRevoked(kf.Key); err != nil {
+			return
+		}
+		keys = append(keys, kf)
+	}
+	if err = scanner.Err(); err == nil && len(keys) == 0 {
+		err = fmt.Errorf("%s: no keys found", name)
+	}
+	return
+}
+
+// AddSecretKeyFlag works like AddKey32Flag but for private keys, which produce a
+// warning when they are given as a literal argument.
+func AddSecretKeyFlag(cmd *cobra.Command, flag, short, defval, usage string, fallback *os.File) (kf *Key32Flag) {
+	kf = AddKey32Flag(cmd, flag, short, defval, usage, fallback)
+	kf.Secret = true
+	return
+}
+
+// IsHybrid returns true if this is a hybrid key with an ML-KEM-768 part.
+func (kf *Key32Flag) IsHybrid() bool {
+	return kf.KEM != nil
+}
+
 // is32ByteBase64Encoded checks if the given string is a base64-encoded 32 byte value.
 func is32ByteBase64Encoded(str string) bool {
 	return regexp.MustCompile("^[A-Za-z0-9+/]{43}=$").MatchString(str)
 }
 
-// decodeKey decodes a base64 string and expects a 32 byte value inside
-func decodeKey(str string) (key *[32]byte, err error) {
+// isHybridBase64Encoded checks if the given string is a base64-encoded hybrid private
+// or public key, i.e. a Curve25519 key followed by an ML-KEM-768 seed or encapsulation key.
+func isHybridBase64Encoded(str string) bool {
+	for _, size := range []int{32 + keyderivation.KEMSeedSize, 32 + keyderivation.KEMPublicSize} {
+		if len(str) == base64.StdEncoding.EncodedLen(size) {
+			if _, err := base64.StdEncoding.DecodeString(str); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isKey checks if the given string is any of the accepted key encodings.
+func isKey(str string) bool {
+	return is32ByteBase64Encoded(str) || isHybridBase64Encoded(str) ||
+		age.IsRecipient(str) || age.IsIdentity(str)
+}
+
+// decodeKey decodes a base64 string or an age key and expects a 32 byte value inside,
+// optionally followed by the ML-KEM-768 part of a hybrid key. Secrets are moved to the
+// returned buffer, which the caller must destroy.
+func decodeKey(str string) (key *[32]byte, kem []byte, buf *securebuf.Buffer, err error) {
+
+	// age keys are bech32 encoded
+	if age.IsRecipient(str) {
+		key, err = age.ParseRecipient(str)
+		return
+	}
+	if age.IsIdentity(str) {
+		if key, err = age.ParseIdentity(str); err == nil {
+			buf = securebuf.Copy(key[:])
+			key = buf.Key()
+		}
+		return
+	}
 
 	k, err := base64.StdEncoding.DecodeString(str)
 	if err != nil {
 		return
 	}
 
-	if len(k) != 32 {
+	switch len(k) {
+	case 32, 32 + keyderivation.KEMSeedSize, 32 + keyderivation.KEMPublicSize:
+	default:
+		securebuf.Wipe(k)
 		err = errors.New("key must be 32 bytes")
 		return
 	}
 
-	key = new([32]byte)
-	copy(key[:], k)
+	// move to locked memory
+	buf = securebuf.Copy(k)
+	key = buf.Key()
+	if len(k) > 32 {
+		kem = buf.Bytes()[32:]
+	}
 	return
 }
 
-// decodeKeyFile reads a file and decodes its contents with decodeKey
-func decodeKeyFile(file *os.File) (key *[32]byte, err error) {
+// SecretKey returns a Key32Flag for a key in a secure buffer, e.g. one that was restored
+// from shares, optionally followed by the ML-KEM-768 part of a hybrid key. The flag takes
+// ownership of the buffer, which is released by Destroy.
+func SecretKey(buf *securebuf.Buffer, file string) *Key32Flag {
+	kf := &Key32Flag{Key: buf.Key(), File: file, buf: buf}
+	if len(buf.Bytes()) > 32 {
+		kf.KEM = buf.Bytes()[32:]
+	}
+	return kf
+}
+
+// Destroy wipes the key and the ML-KEM-768 part of a hybrid key and releases their
+// locked memory. They are nil afterwards.
+func (kf *Key32Flag) Destroy() {
+	if kf.Key != nil {
+		securebuf.Wipe(kf.Key[:])
+	}
+	securebuf.Wipe(kf.KEM)
+	kf.buf.Destroy()
+	kf.Key, kf.KEM, kf.buf = nil, nil, nil
+}
+
+// DerivePrefix marks lines in a keyfile which list the labels of derived subkeys.
+const DerivePrefix = "derive: "
+
+// decodeKeyFile reads a file, decodes the first key with decodeKey
+// and collects the labels of any derived subkeys
+func decodeKeyFile(file *os.File, kf *Key32Flag) (err error) {
+	return decodeKeyReader(file, file.Name(), kf)
+}
+
+// decodeKeyReader works like decodeKeyFile on any reader, name is used in errors
+func decodeKeyReader(reader io.Reader, name string, kf *Key32Flag) (err error) {
+
+	kf.Key, kf.KEM, kf.Derived, kf.Meta = nil, nil, nil, KeyMetadata{}
 
 	// use a line scanner
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
+		line := scanner.Text()
+
 		// test each line for key regexp
-		if line := scanner.Text(); is32ByteBase64Encoded(line) {
-			return decodeKey(line)
+		if kf.Key == nil && isKey(line) {
+			if kf.Key, kf.KEM, kf.buf, err = decodeKey(line); err != nil {
+				return
+			}
 		}
+
+		// list of derived subkeys
+		if strings.HasPrefix(line, DerivePrefix) {
+			kf.Derived = append(kf.Derived, strings.TrimPrefix(line, DerivePrefix))
+		}
+
+		// structured metadata
+		if _, err = kf.Meta.parse(line); err != nil {
+			return fmt.Errorf("invalid metadata in %s: %s", name, err)
+		}
+
 	}
 
 	// return any errors encountered
-	if e := scanner.Err(); e != nil {
-		return nil, e
+	if err = scanner.Err(); err != nil {
+		return
 	}
 
 	// probably hit EOF
-	return nil, fmt.Errorf("no base64 encoded key found in %s", file.Name())
+	if kf.Key == nil {
+		return fmt.Errorf("no base64 encoded or age key found in %s", name)
+	}
+	return
 
 }
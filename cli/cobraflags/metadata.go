@@ -0,0 +1,164 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cobraflags
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// KeyMetadata is the structured information stored in key files next to the key
+// itself. Each field is written on its own line in the form "field: value", while
+// lines starting with '#' are free-form comments:
+//
+//	created: 2026-10-19T12:00:00Z
+//	expires: 2027-10-19T00:00:00Z
+//	usage: encrypt
+//	label: backups
+type KeyMetadata struct {
+	Created time.Time
+	Expires time.Time // zero if the key does not expire
+	Usage   []string  // allowed usages, e.g. "encrypt" or "sign"; empty allows everything
+	Labels  []string
+}
+
+// Prefixes of the metadata lines in key files.
+const (
+	CreatedPrefix = "created: "
+	ExpiresPrefix = "expires: "
+	UsagePrefix   = "usage: "
+	LabelPrefix   = "label: "
+)
+
+// RevocationList is the path to a file of revoked public keys, which is consulted
+// by Key32Flag. It is disabled if empty. Each line holds a base64 encoded public key,
+// optionally followed by a space and the reason for its revocation.
+var RevocationList string
+
+// parse a single line of metadata, returns false if it is not a metadata line
+func (m *KeyMetadata) parse(line string) (ok bool, err error) {
+	switch {
+	case strings.HasPrefix(line, CreatedPrefix):
+		m.Created, err = time.Parse(time.RFC3339, strings.TrimPrefix(line, CreatedPrefix))
+	case strings.HasPrefix(line, ExpiresPrefix):
+		m.Expires, err = time.Parse(time.RFC3339, strings.TrimPrefix(line, ExpiresPrefix))
+	case strings.HasPrefix(line, UsagePrefix):
+		for _, u := range strings.Split(strings.TrimPrefix(line, UsagePrefix), ",") {
+			m.Usage = append(m.Usage, strings.TrimSpace(u))
+		}
+	case strings.HasPrefix(line, LabelPrefix):
+		m.Labels = append(m.Labels, strings.TrimPrefix(line, LabelPrefix))
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+// String formats the metadata as lines for a key file.
+func (m *KeyMetadata) String() string {
+	var b strings.Builder
+	if !m.Created.IsZero() {
+		fmt.Fprintf(&b, "%s%s\n", CreatedPrefix, m.Created.UTC().Format(time.RFC3339))
+	}
+	if !m.Expires.IsZero() {
+		fmt.Fprintf(&b, "%s%s\n", ExpiresPrefix, m.Expires.UTC().Format(time.RFC3339))
+	}
+	if len(m.Usage) > 0 {
+		fmt.Fprintf(&b, "%s%s\n", UsagePrefix, strings.Join(m.Usage, ","))
+	}
+	for _, l := range m.Labels {
+		fmt.Fprintf(&b, "%s%s\n", LabelPrefix, l)
+	}
+	return b.String()
+}
+
+// Allows returns true if the usage is listed or no usages are listed at all.
+func (m *KeyMetadata) Allows(usage string) bool {
+	for _, u := range m.Usage {
+		if u == usage {
+			return true
+		}
+	}
+	return len(m.Usage) == 0
+}
+
+// Usable returns an error if the key is revoked, expired at the given time or
+// not allowed for the given usage.
+func (kf *Key32Flag) Usable(usage string, now time.Time) error {
+	name := kf.File
+	if name == "argument" {
+		name = "on the commandline"
+	}
+	if kf.Revoked != "" {
+		return fmt.Errorf("key %s is revoked: %s", name, kf.Revoked)
+	}
+	if !kf.Meta.Expires.IsZero() && now.After(kf.Meta.Expires) {
+		return fmt.Errorf("key %s expired on %s", name, kf.Meta.Expires.Format("2006-01-02"))
+	}
+	if !kf.Meta.Allows(usage) {
+		return fmt.Errorf("key %s is not allowed to %s", name, usage)
+	}
+	return nil
+}
+
+// checkRevoked looks up the key in the RevocationList and returns the reason
+// for its revocation or an empty string if it is not revoked.
+func checkRevoked(key *[32]byte) (reason string, err error) {
+
+	if RevocationList == "" {
+		return
+	}
+	file, err := os.Open(RevocationList)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer file.Close()
+
+	encoded := base64.StdEncoding.EncodeToString(key[:])
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if fields[0] == encoded {
+			if len(fields) == 2 && fields[1] != "" {
+				return fields[1], nil
+			}
+			return "no reason given", nil
+		}
+	}
+	return "", scanner.Err()
+
+}
+
+// Revoke appends the public key with a reason to the RevocationList.
+func Revoke(key *[32]byte, reason string) (err error) {
+
+	if RevocationList == "" {
+		return errors.New("no revocation list configured")
+	}
+	if strings.ContainsAny(reason, "\r\n") {
+		return errors.New("reason must be a single line")
+	}
+
+	if err = os.MkdirAll(filepath.Dir(RevocationList), 0755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(RevocationList, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s %s\n", base64.StdEncoding.EncodeToString(key[:]), reason)
+	return
+
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cobraflags
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// checkFlag parses a value for a key flag and runs its check
+func checkFlag(t *testing.T, secret bool, value string) (*Key32Flag, error) {
+	cmd := &cobra.Command{}
+	kf := AddKey32Flag(cmd, "key", "k", "", "", nil)
+	kf.Secret = secret
+	if err := cmd.ParseFlags([]string{"--key", value}); err != nil {
+		t.Fatal(err)
+	}
+	return kf, kf.Check(cmd, nil)
+}
+
+func TestUsable(t *testing.T) {
+
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name  string
+		kf    Key32Flag
+		usage string
+		err   string
+	}{
+		{"plain", Key32Flag{}, "encrypt", ""},
+		{"unexpired", Key32Flag{Meta: KeyMetadata{Expires: now.AddDate(0, 0, 1)}}, "encrypt", ""},
+		{"expired", Key32Flag{Meta: KeyMetadata{Expires: now.AddDate(0, 0, -1)}}, "encrypt", "expired on 2026-10-18"},
+		{"usage", Key32Flag{Meta: KeyMetadata{Usage: []string{"sign", "encrypt"}}}, "encrypt", ""},
+		{"wrong usage", Key32Flag{Meta: KeyMetadata{Usage: []string{"sign"}}}, "encrypt", "not allowed to encrypt"},
+		{"revoked", Key32Flag{Revoked: "laptop stolen"}, "encrypt", "revoked: laptop stolen"},
+	} {
+		tc.kf.File = "argument"
+		err := tc.kf.Usable(tc.usage, now)
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: expected %q, got %v", tc.name, tc.err, err)
+		}
+	}
+
+}
+
+func TestMetadataFile(t *testing.T) {
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "peer.pub")
+	content := "# some comment\n" +
+		"expires: 2026-01-01T00:00:00Z\n" +
+		"usage: sign\n" +
+		"lGLDmEQU/+u3zvRXeJrvlpWFCAtKUrEdNjuhhUTAFBo=\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	kf, err := checkFlag(t, false, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kf.Usable("encrypt", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC))
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired recipient is usable: %v", err)
+	}
+	err = kf.Usable("encrypt", time.Date(2025, 10, 19, 0, 0, 0, 0, time.UTC))
+	if err == nil || !strings.Contains(err.Error(), "not allowed to encrypt") {
+		t.Errorf("recipient with wrong usage is usable: %v", err)
+	}
+
+}
+
+func TestRevoked(t *testing.T) {
+
+	defer func(list string) { RevocationList = list }(RevocationList)
+	dir := t.TempDir()
+	RevocationList = filepath.Join(dir, "revoked")
+
+	revoked := "lGLDmEQU/+u3zvRXeJrvlpWFCAtKUrEdNjuhhUTAFBo="
+	other := "FgP2QIfXh5A8/OJIHxOsHxNI4K0eYAtHl/RRmwR+yUA="
+
+	// a missing list revokes nothing
+	if kf, err := checkFlag(t, false, revoked); err != nil || kf.Revoked != "" {
+		t.Fatalf("revoked without a list: %q %v", kf.Revoked, err)
+	}
+
+	kf, _ := checkFlag(t, false, revoked)
+	if err := Revoke(kf.Key, "laptop stolen"); err != nil {
+		t.Fatal(err)
+	}
+	if kf, err := checkFlag(t, false, revoked); err != nil || kf.Revoked != "laptop stolen" {
+		t.Errorf("recipient not revoked: %q %v", kf.Revoked, err)
+	} else if err = kf.Usable("encrypt", time.Now()); err == nil {
+		t.Error("revoked recipient is usable")
+	}
+	if kf, err := checkFlag(t, false, other); err != nil || kf.Revoked != "" {
+		t.Errorf("other recipient revoked: %q %v", kf.Revoked, err)
+	}
+
+	// recipients in list flags are checked as well
+	cmd := &cobra.Command{}
+	kl := AddKey32ListFlag(cmd, "peer", "p", "")
+	if err := cmd.ParseFlags([]string{"-p", other, "-p", revoked}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kl.Check(cmd, nil); err != nil || len(kl.Keys) != 2 || kl.Keys[0].Revoked != "" || kl.Keys[1].Revoked == "" {
+		t.Errorf("list flag not checked: %v", err)
+	}
+
+	// an unreadable list fails for recipients but not for private keys,
+	// which must still open files
+	private := filepath.Join(dir, "key")
+	if err := os.WriteFile(private, []byte(other+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	RevocationList = dir
+	if _, err := checkFlag(t, false, private); err == nil {
+		t.Error("unreadable revocation list was ignored for a recipient")
+	}
+	if kf, err := checkFlag(t, true, private); err != nil || kf.Revoked != "" {
+		t.Errorf("private key was checked against the revocation list: %q %v", kf.Revoked, err)
+	}
+
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cobraflags
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// KeySource resolves the reference in a key flag of the form "scheme:reference" to
+// the contents of a keyfile, so keys can be passed without ever touching the disk.
+type KeySource func(ref string) (io.ReadCloser, error)
+
+// KeySources are the registered key sources by their scheme. Add your own with
+// RegisterKeySource. Filenames which start with a registered scheme can be given
+// with the "file:" scheme.
+var KeySources = map[string]KeySource{
+	"env":  EnvSource,
+	"fd":   FdSource,
+	"cmd":  CmdSource,
+	"file": FileSource,
+}
+
+// RegisterKeySource adds a KeySource for a scheme.
+func RegisterKeySource(scheme string, source KeySource) {
+	KeySources[scheme] = source
+}
+
+// keySource splits a flag value into a registered source and its reference
+func keySource(str string) (source KeySource, ref string, ok bool) {
+	i := strings.Index(str, ":")
+	if i < 1 {
+		return nil, "", false
+	}
+	source, ok = KeySources[str[:i]]
+	return source, str[i+1:], ok
+}
+
+// IsFile returns true if the key was read from a regular keyfile and not from an
+// argument, stdin or another key source.
+func (kf *Key32Flag) IsFile() bool {
+	if kf.File == "argument" || kf.File == os.Stdin.Name() {
+		return false
+	}
+	_, _, source := keySource(kf.File)
+	return !source
+}
+
+// EnvSource reads a key or keyfile from an environment variable, e.g. "env:AENKER_KEY".
+func EnvSource(name string) (io.ReadCloser, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return io.NopCloser(strings.NewReader(value)), nil
+}
+
+// FdSource reads a key or keyfile from an inherited file descriptor, e.g. "fd:3".
+func FdSource(fd string) (io.ReadCloser, error) {
+	n, err := strconv.ParseUint(fd, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file descriptor %q", fd)
+	}
+	file := os.NewFile(uintptr(n), "fd:"+fd)
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %q", fd)
+	}
+	if _, err = file.Stat(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("file descriptor %s is not open", fd)
+	}
+	return file, nil
+}
+
+// CmdSource runs a command with the shell and reads a key or keyfile from its output,
+// e.g. "cmd:pass show aenker". Its stderr is passed through, so it can ask for passwords.
+// The output is wiped when the reader is closed.
+func CmdSource(command string) (io.ReadCloser, error) {
+	if command == "" {
+		return nil, errors.New("empty command")
+	}
+	shell, flag := "/bin/sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.Command(shell, flag, command)
+	cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		securebuf.Wipe(out)
+		return nil, fmt.Errorf("command failed: %s", err)
+	}
+	return &wipeCloser{bytes.NewReader(out), out}, nil
+}
+
+// wipeCloser reads from a secret, which is wiped on Close
+type wipeCloser struct {
+	*bytes.Reader
+	secret []byte
+}
+
+func (w *wipeCloser) Close() error {
+	securebuf.Wipe(w.secret)
+	return nil
+}
+
+// FileSource opens a keyfile, e.g. "file:env:literal-filename".
+func FileSource(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// warnLiteral prints a warning about secret keys given on the commandline
+func warnLiteral(flag string) {
+	fmt.Fprintf(os.Stderr, "WARNING: the secret key given with --%s is visible in the process list "+
+		"and shell history, use env:, fd: or cmd: instead\n", flag)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cobraflags
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestKeySources(t *testing.T) {
+
+	key := "lGLDmEQU/+u3zvRXeJrvlpWFCAtKUrEdNjuhhUTAFBo="
+	dir := t.TempDir()
+	t.Setenv("AENKER_TEST_KEY", key)
+	os.Unsetenv("AENKER_TEST_UNSET")
+
+	// a keyfile whose name looks like a key source
+	file := filepath.Join(dir, "env:key")
+	if err := os.WriteFile(file, []byte("# comment\n"+key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	echo := "echo " + key
+	if runtime.GOOS == "windows" {
+		echo = "echo." + key
+	}
+
+	for _, tc := range []struct {
+		value string
+		err   string // expected error, key is decoded if empty
+	}{
+		{"env:AENKER_TEST_KEY", ""},
+		{"env:AENKER_TEST_UNSET", "is not set"},
+		{"fd:abc", "invalid file descriptor"},
+		{"fd:-1", "invalid file descriptor"},
+		{"fd:99999", "is not open"},
+		{"cmd:" + echo, ""},
+		{"cmd:", "empty command"},
+		{"cmd:exit 3", "command failed"},
+		{"file:" + file, ""},
+		{"file:" + filepath.Join(dir, "missing"), "missing"},
+	} {
+		kf := new(Key32Flag)
+		err := kf.resolve(tc.value, "key")
+		if tc.err == "" {
+			if err != nil || kf.Key == nil || kf.File != tc.value {
+				t.Errorf("%s: not decoded from %q: %v", tc.value, kf.File, err)
+			}
+			if kf.IsFile() {
+				t.Errorf("%s: reported as a keyfile", tc.value)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected %q, got %v", tc.value, tc.err, err)
+		}
+		kf.Destroy()
+	}
+
+	// unregistered schemes and plain filenames are not key sources
+	for _, value := range []string{"", ":x", "nope:x", "env", file} {
+		if _, _, ok := keySource(value); ok {
+			t.Errorf("%q is a key source", value)
+		}
+	}
+
+}
+
+func TestCmdSourceWiped(t *testing.T) {
+
+	rc, err := CmdSource("echo secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(rc)
+	if err != nil || strings.TrimSpace(string(out)) != "secret" {
+		t.Fatalf("wrong output %q: %v", out, err)
+	}
+	secret := rc.(*wipeCloser).secret
+	rc.Close()
+	for _, b := range secret {
+		if b != 0 {
+			t.Fatalf("output not wiped: %q", secret)
+		}
+	}
+
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+//go:build unix
+
+package cobraflags
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestFdSource(t *testing.T) {
+
+	key := "lGLDmEQU/+u3zvRXeJrvlpWFCAtKUrEdNjuhhUTAFBo="
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.WriteString("# comment\n" + key + "\n")
+	w.Close()
+
+	// the source takes ownership of the descriptor, so pass a duplicate
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := "fd:" + strconv.Itoa(fd)
+	kf := new(Key32Flag)
+	if err = kf.resolve(value, "key"); err != nil || kf.Key == nil || kf.File != value {
+		t.Fatalf("not decoded from %s: %v", value, err)
+	}
+	defer kf.Destroy()
+
+	// the descriptor is closed after reading
+	if _, err = FdSource(strconv.Itoa(fd)); err == nil {
+		t.Error("descriptor was not closed")
+	}
+
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Profile is a named set of defaults in the configuration file.
+type Profile struct {
+	Key        string            `yaml:"key"`        // default private key
+	Recipient  string            `yaml:"recipient"`  // default recipient for seal
+	Recipients map[string]string `yaml:"recipients"` // named recipients, usable with -p NAME
+	Chunksize  int               `yaml:"chunksize"`  // chunksize of the aenker format
+	Padding    string            `yaml:"padding"`    // padding policy of the final chunk
+	Parity     string            `yaml:"parity"`     // data:parity chunks for new files
+	Suffix     string            `yaml:"suffix"`     // suffix of encrypted files
+	Vault      string            `yaml:"vault"`      // secrets vault file
+}
+
+// Config is the structure of the configuration file, e.g.:
+//  profile: work
+//  profiles:
+//    work:
+//      key: env:WORK_KEY
+//      recipient: team
+//      recipients:
+//        team: ~/keys/team.pub
+//      suffix: .ae
+type Config struct {
+	Profile  string             `yaml:"profile"` // profile to use if --profile is not given
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// profileAnnotation marks flags, which take their default from a profile field
+const profileAnnotation = "aenker_profile"
+
+// the default configuration file, can be overridden with AENKER_CONFIG
+var defaultconfig = func() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return path.Join(dir, "aenker", "config.yaml")
+	}
+	return ""
+}()
+
+// selected profile
+var profile string
+
+func init() {
+	RootCommand.PersistentFlags().StringVar(&profile, "profile", "", "use defaults from this profile in the config file")
+	RootCommand.PersistentPreRunE = applyProfile
+}
+
+// profileFlag marks a flag of a command to take its default from a profile field
+func profileFlag(cmd *cobra.Command, flag, field string) {
+	if err := cmd.Flags().SetAnnotation(flag, profileAnnotation, []string{field}); err != nil {
+		panic(err)
+	}
+}
+
+// readConfig reads the configuration file, a missing file is an empty configuration
+func readConfig() (config *Config, err error) {
+	config = new(Config)
+	file := defaultconfig
+	if env := os.Getenv("AENKER_CONFIG"); env != "" {
+		file = env
+	}
+	if file == "" {
+		return
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return
+	}
+	if err = yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return
+}
+
+// applyProfile sets all flags of a command, which were not given explicitly, to
+// the values of the selected profile before any PreRunE checks run
+func applyProfile(cmd *cobra.Command, args []string) (err error) {
+
+	config, err := readConfig()
+	if err != nil {
+		return
+	}
+
+	// select profile
+	name := profile
+	if name == "" {
+		name = config.Profile
+	}
+	if name == "" {
+		name = "default"
+	}
+	p, ok := config.Profiles[name]
+	if !ok {
+		if profile != "" || config.Profile != "" {
+			return fmt.Errorf("profile %q not found in config", name)
+		}
+		return nil
+	}
+
+	// register named recipients
+	for alias, key := range p.Recipients {
+		cf.Aliases[alias] = expandHome(key)
+	}
+
+	// values of the profile fields as flag strings
+	fields := map[string]string{
+		"key":       expandHome(p.Key),
+		"recipient": expandHome(p.Recipient),
+		"padding":   p.Padding,
+		"parity":    p.Parity,
+		"suffix":    p.Suffix,
+		"vault":     expandHome(p.Vault),
+	}
+	if p.Chunksize != 0 {
+		fields["chunksize"] = strconv.Itoa(p.Chunksize)
+	}
+
+	// apply as defaults to annotated flags
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		field, ok := f.Annotations[profileAnnotation]
+		if err != nil || !ok || f.Changed || fields[field[0]] == "" {
+			return
+		}
+		// set the value without marking the flag as changed, so commands can still tell
+		// explicit flags apart, e.g. open prefers the agent over a default key
+		if err = f.Value.Set(fields[field[0]]); err != nil {
+			err = fmt.Errorf("profile %q: invalid %s: %s", name, field[0], err)
+			return
+		}
+		f.DefValue = fields[field[0]]
+		delete(f.Annotations, cobra.BashCompOneRequiredFlag)
+	})
+	return
+
+}
+
+// expandHome replaces a leading ~/ with the home directory
+func expandHome(file string) string {
+	if strings.HasPrefix(file, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return path.Join(home, file[2:])
+		}
+	}
+	return file
+}
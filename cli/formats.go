@@ -0,0 +1,207 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ansemjo/aenker/ae"
+	"github.com/ansemjo/aenker/ae/age"
+	"github.com/ansemjo/aenker/agent"
+	"github.com/ansemjo/aenker/chunkstream"
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/sealedbox"
+	"github.com/spf13/cobra"
+)
+
+// writers are the encrypting constructors for the supported file formats
+var writers = map[string]func(io.Writer, *[32]byte) (io.WriteCloser, error){
+	"aenker":    ae.NewWriter,
+	"age":       age.NewWriter,
+	"sealedbox": sealedbox.NewWriter,
+}
+
+// readers are the decrypting constructors for the supported file formats
+var readers = map[string]func(io.Reader, *[32]byte) (io.Reader, error){
+	"aenker":    ae.NewReader,
+	"age":       age.NewReader,
+	"sealedbox": sealedbox.NewReader,
+}
+
+// list of supported formats for usage strings
+var formats = func() string {
+	f := make([]string, 0, len(writers))
+	for k := range writers {
+		f = append(f, k)
+	}
+	sort.Strings(f)
+	return strings.Join(f, "|")
+}()
+
+// checkFormat returns an error if the format is not supported
+func checkFormat(format string) error {
+	if _, ok := writers[format]; !ok {
+		return fmt.Errorf("unknown format %q, must be one of %s", format, formats)
+	}
+	return nil
+}
+
+// newWriter opens a writer in the given format for the peer key,
+// which may be a hybrid key for the aenker format
+func newWriter(format string, w io.Writer, peer *cf.Key32Flag) (io.WriteCloser, error) {
+	if peer.IsHybrid() {
+		if format != "aenker" {
+			return nil, fmt.Errorf("hybrid keys are not supported in %s format", format)
+		}
+		if len(peer.KEM) != keyderivation.KEMPublicSize {
+			return nil, errors.New("peer is not a hybrid public key")
+		}
+		return ae.NewHybridWriter(w, peer.Key, peer.KEM)
+	}
+	return writers[format](w, peer.Key)
+}
+
+// newReader opens a reader in the given format with the private key,
+// which may be a hybrid key or have derived subkeys for the aenker format
+func newReader(format string, r io.Reader, private *cf.Key32Flag) (io.Reader, error) {
+	if private.IsHybrid() {
+		if format != "aenker" {
+			return nil, fmt.Errorf("hybrid keys are not supported in %s format", format)
+		}
+		if len(private.KEM) != keyderivation.KEMSeedSize {
+			return nil, errors.New("key is not a hybrid private key")
+		}
+		return ae.NewHybridReader(r, private.Key, private.KEM)
+	}
+	if len(private.Derived) > 0 && format == "aenker" {
+		// try the master key and all derived subkeys
+		keys := []*[32]byte{private.Key}
+		for _, label := range private.Derived {
+			keys = append(keys, keyderivation.Subkey(private.Key, label))
+		}
+		return ae.NewReaderAny(r, keys...)
+	}
+	return readers[format](r, private.Key)
+}
+
+// newAgentReader opens a reader in the aenker format with the keys held by the
+// agent at AENKER_AUTH_SOCK
+func newAgentReader(format string, r io.Reader) (io.Reader, error) {
+	if format != "aenker" {
+		return nil, fmt.Errorf("the agent cannot open files in %s format", format)
+	}
+	client, err := agent.DialEnv()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	ids, err := client.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("the agent has no keys")
+	}
+	shared := make([]ae.SharedFunc, len(ids))
+	for i := range ids {
+		public := &ids[i].Public
+		shared[i] = func(ephemeral *[32]byte) (*[32]byte, error) {
+			return client.Shared(public, ephemeral)
+		}
+	}
+	return ae.NewReaderShared(r, shared...)
+}
+
+// checkChunksize checks the chunksize of the aenker format and applies it
+func checkChunksize(size int) error {
+	if size < 64 || size > 16<<20 {
+		return fmt.Errorf("chunksize must be between 64 bytes and 16 MiB, got %d", size)
+	}
+	ae.Chunksize = size
+	return nil
+}
+
+// checkPadding applies the padding policy of the final chunk in the aenker format
+func checkPadding(policy string) error {
+	switch policy {
+	case "full":
+		chunkstream.PadFinal = true
+	case "none":
+		chunkstream.PadFinal = false
+	default:
+		return fmt.Errorf("unknown padding policy %q, must be full or none", policy)
+	}
+	return nil
+}
+
+// checkFlush selects framed chunks, which can be flushed, in the aenker format
+func checkFlush(format string, flush bool) error {
+	if flush && format != "aenker" {
+		return fmt.Errorf("--flush is not supported in %s format", format)
+	}
+	ae.Framed = flush
+	return nil
+}
+
+// checkParity parses the numbers of data and parity chunks per group in the form K:M
+// and adds parity to new files in the aenker format
+func checkParity(format string, flush bool, scheme string) error {
+	ae.DataChunks, ae.ParityChunks = 0, 0
+	if scheme == "" {
+		return nil
+	}
+	if format != "aenker" {
+		return fmt.Errorf("--parity is not supported in %s format", format)
+	}
+	if flush {
+		return errors.New("--parity cannot be combined with --flush")
+	}
+	var data, parity int
+	if n, err := fmt.Sscanf(scheme, "%d:%d", &data, &parity); n != 2 || err != nil {
+		return fmt.Errorf("invalid parity %q, must be data:parity chunks like 16:2", scheme)
+	}
+	if data < 1 || parity < 1 || data+parity > 256 {
+		return fmt.Errorf("parity needs at least one data and parity chunk and at most 256 in total, got %s", scheme)
+	}
+	ae.DataChunks, ae.ParityChunks = data, parity
+	return nil
+}
+
+// checkSuffix sets the output filename from the input filename when only the latter
+// was given and a suffix is configured. The suffix is added when sealing and removed
+// when opening. Existing files are never overwritten this way.
+func checkSuffix(cmd *cobra.Command, suffix string, seal bool) error {
+	if suffix == "" || !cmd.Flag("input").Changed || cmd.Flag("output").Changed {
+		return nil
+	}
+	in := cmd.Flag("input").Value.String()
+	out := in + suffix
+	if !seal {
+		if !strings.HasSuffix(in, suffix) || in == suffix {
+			return fmt.Errorf("input does not end in %q, use --output", suffix)
+		}
+		out = strings.TrimSuffix(in, suffix)
+	}
+	if _, err := os.Stat(out); err == nil {
+		return fmt.Errorf("output %s exists already, use --output to overwrite", out)
+	}
+	return cmd.Flags().Set("output", out)
+}
+
+// checkSealedbox handles the --sealedbox shorthand for --format sealedbox
+func checkSealedbox(cmd *cobra.Command, sealedbox bool, format *string) error {
+	if sealedbox {
+		if cmd.Flag("format").Changed && *format != "sealedbox" {
+			return fmt.Errorf("--sealedbox conflicts with --format %s", *format)
+		}
+		*format = "sealedbox"
+	}
+	return checkFormat(*format)
+}
@@ -4,9 +4,16 @@
 package cli
 
 import (
+	"bufio"
 	b64 "encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+
+	cf "github.com/ansemjo/aenker/cli/cobraflags"
+	"github.com/ansemjo/aenker/keyderivation"
 )
 
 var base64 = b64.StdEncoding.EncodeToString
@@ -19,3 +26,61 @@ func fatal(err error) {
 		os.Exit(1)
 	}
 }
+
+// publicKey calculates the public key of a private key flag, which includes
+// the ML-KEM-768 encapsulation key if it is a hybrid key.
+func publicKey(private *cf.Key32Flag) (pub []byte, err error) {
+	pub = keyderivation.Public(private.Key)[:]
+	if private.IsHybrid() {
+		kempub, err := keyderivation.KEMPublic(private.KEM)
+		if err != nil {
+			return nil, err
+		}
+		pub = append(pub, kempub...)
+	}
+	return
+}
+
+// replaceFile atomically replaces a regular file with the output of write. The output
+// is written to a temporary file in the same directory first, which is renamed over the
+// original with the same permissions only if everything succeeded.
+func replaceFile(file string, write func(w io.Writer) error) (err error) {
+
+	stat, err := os.Stat(file)
+	if err != nil {
+		return
+	}
+	if !stat.Mode().IsRegular() {
+		return errors.New("not a regular file")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".tmp-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	out := bufio.NewWriter(tmp)
+	if err = write(out); err != nil {
+		return
+	}
+	if err = out.Flush(); err != nil {
+		return
+	}
+	if err = tmp.Chmod(stat.Mode().Perm()); err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), file)
+
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package dropbox receives file uploads over HTTP and encrypts them for a public key at
+// the moment of receipt. Uploads are streamed through ae.NewWriter into a file with a
+// random name, so the plaintext is never held in memory or written to disk as a whole.
+package dropbox
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ansemjo/aenker/ae"
+)
+
+// Suffix is appended to the random names of uploaded files.
+const Suffix = ".ae"
+
+// Receipt is returned to the uploader as JSON for every file.
+type Receipt struct {
+	Name       string    `json:"name"`       // random name of the encrypted file
+	Filename   string    `json:"filename"`   // original name given by the uploader, if any
+	Size       int64     `json:"size"`       // size of the plaintext
+	Ciphertext int64     `json:"ciphertext"` // size of the encrypted file
+	SHA256     string    `json:"sha256"`     // hash of the encrypted file
+	Received   time.Time `json:"received"`
+}
+
+// Handler is an http.Handler, which accepts raw PUT requests and multipart/form-data
+// POST requests and answers GET requests with a simple upload form.
+type Handler struct {
+	dir    string
+	public *[32]byte
+	kempub []byte
+
+	// MaxSize limits the size of a single request in bytes if it is greater than zero.
+	MaxSize int64
+
+	// ErrorLog logs failed uploads, the standard logger is used if nil.
+	ErrorLog *log.Logger
+}
+
+// NewHandler returns a handler, which stores encrypted uploads for the public key in
+// dir. The kempub may be nil, otherwise uploads are encrypted for a hybrid key.
+func NewHandler(dir string, public *[32]byte, kempub []byte) *Handler {
+	return &Handler{dir: dir, public: public, kempub: kempub}
+}
+
+// ServeHTTP handles uploads and returns a single Receipt for PUT and a list of
+// Receipts for POST requests.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if h.MaxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxSize)
+	}
+
+	switch r.Method {
+
+	case http.MethodGet, http.MethodHead:
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, form)
+
+	case http.MethodPut:
+		receipt, err := h.store(r.Body, "")
+		if err != nil {
+			h.fail(w, err)
+			return
+		}
+		h.reply(w, receipt)
+
+	case http.MethodPost:
+		parts, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		receipts := []*Receipt{}
+		for {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				h.fail(w, err)
+				return
+			}
+			if part.FileName() == "" {
+				continue
+			}
+			receipt, err := h.store(part, part.FileName())
+			if err != nil {
+				h.fail(w, err)
+				return
+			}
+			receipts = append(receipts, receipt)
+		}
+		if len(receipts) == 0 {
+			http.Error(w, "no files in upload", http.StatusBadRequest)
+			return
+		}
+		h.reply(w, receipts)
+
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+
+}
+
+// store encrypts an upload into a temporary file, which is renamed to a random name
+// only if the upload was complete
+func (h *Handler) store(r io.Reader, filename string) (receipt *Receipt, err error) {
+
+	random := make([]byte, 16)
+	if _, err = io.ReadFull(rand.Reader, random); err != nil {
+		return
+	}
+	name := hex.EncodeToString(random) + Suffix
+
+	tmp, err := os.CreateTemp(h.dir, ".upload-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// encrypt and hash the ciphertext on the way to disk
+	hash := sha256.New()
+	counter := &countWriter{w: io.MultiWriter(tmp, hash)}
+	var cw io.WriteCloser
+	if h.kempub != nil {
+		cw, err = ae.NewHybridWriter(counter, h.public, h.kempub)
+	} else {
+		cw, err = ae.NewWriter(counter, h.public)
+	}
+	if err != nil {
+		return
+	}
+	size, err := io.Copy(cw, r)
+	if err != nil {
+		return
+	}
+	if err = cw.Close(); err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(h.dir, name)); err != nil {
+		return
+	}
+
+	return &Receipt{
+		Name:       name,
+		Filename:   filename,
+		Size:       size,
+		Ciphertext: counter.n,
+		SHA256:     hex.EncodeToString(hash.Sum(nil)),
+		Received:   time.Now().UTC(),
+	}, nil
+
+}
+
+// reply writes a JSON response
+func (h *Handler) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// fail logs an error and reports it to the client
+func (h *Handler) fail(w http.ResponseWriter, err error) {
+	var toolarge *http.MaxBytesError
+	if errors.As(err, &toolarge) {
+		http.Error(w, fmt.Sprintf("upload exceeds %d bytes", toolarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf("dropbox: %s", err)
+	} else {
+		log.Printf("dropbox: %s", err)
+	}
+	http.Error(w, "upload failed", http.StatusInternalServerError)
+}
+
+// countWriter counts the bytes written
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
+
+// form is a minimal upload page for browsers
+const form = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>aenker dropbox</title></head>
+<body>
+<h1>Upload files</h1>
+<p>Files are encrypted immediately upon receipt.</p>
+<form method="post" enctype="multipart/form-data">
+<input type="file" name="file" multiple>
+<input type="submit" value="Upload">
+</form>
+</body>
+</html>
+`
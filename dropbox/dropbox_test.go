@@ -0,0 +1,84 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package dropbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ansemjo/aenker/ae"
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+func TestUpload(t *testing.T) {
+
+	private := new([32]byte)
+	rand.Read(private[:])
+	dir := t.TempDir()
+	h := NewHandler(dir, keyderivation.Public(private), nil)
+	h.MaxSize = 10000
+
+	// raw upload
+	plain := []byte("Hello, World!")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/", bytes.NewReader(plain)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("PUT: status %d: %s", rec.Code, rec.Body)
+	}
+	var receipt Receipt
+	if err := json.Unmarshal(rec.Body.Bytes(), &receipt); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stored file matches the receipt and decrypts
+	ct, err := os.ReadFile(filepath.Join(dir, receipt.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(ct); hex.EncodeToString(sum[:]) != receipt.SHA256 || int64(len(ct)) != receipt.Ciphertext {
+		t.Error("receipt does not match the stored file")
+	}
+	r, _ := ae.NewReader(bytes.NewReader(ct), private)
+	if out, err := io.ReadAll(r); err != nil || !bytes.Equal(out, plain) {
+		t.Errorf("decryption failed: %v", err)
+	}
+
+	// multipart upload with two files
+	var body bytes.Buffer
+	mp := multipart.NewWriter(&body)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		part, _ := mp.CreateFormFile("file", name)
+		part.Write([]byte(name))
+	}
+	mp.Close()
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", mp.FormDataContentType())
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var receipts []Receipt
+	if err := json.Unmarshal(rec.Body.Bytes(), &receipts); err != nil || len(receipts) != 2 || receipts[1].Filename != "b.txt" {
+		t.Errorf("POST: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// uploads above the limit leave nothing behind
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/", bytes.NewReader(make([]byte, 20000))))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large PUT: status %d", rec.Code)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 3 {
+		t.Errorf("expected 3 files, found %d", len(files))
+	}
+
+}
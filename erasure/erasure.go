@@ -0,0 +1,174 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package erasure implements a systematic Reed-Solomon erasure code over GF(256). Data in
+// k shards is extended with m parity shards, so that any k of the k+m shards reconstruct
+// all others. Errors are not located by the code itself, so damaged shards must be known,
+// e.g. from a checksum or an authentication tag, and are treated as missing.
+//
+// The parity shards are computed with a Cauchy matrix C, so that any k rows of the
+// generator matrix [I; C] are linearly independent:
+//  C[i][j] = 1 / ((k + i) ^ j)
+package erasure
+
+import (
+	"errors"
+
+	"github.com/ansemjo/aenker/gf256"
+)
+
+// ErrTooFewShards is returned when less than k shards are present.
+var ErrTooFewShards = errors.New("erasure: too few shards to reconstruct")
+
+// Code is a Reed-Solomon code with a fixed number of data and parity shards.
+type Code struct {
+	Data, Parity int
+	cauchy       [][]byte
+}
+
+// New returns a code for the given number of data and parity shards. Both must be
+// positive and there can be at most 256 shards in total.
+func New(data, parity int) (*Code, error) {
+	if data < 1 || parity < 1 || data+parity > 256 {
+		return nil, errors.New("erasure: need at least one data and parity shard and at most 256 shards")
+	}
+	c := &Code{Data: data, Parity: parity, cauchy: make([][]byte, parity)}
+	for i := range c.cauchy {
+		c.cauchy[i] = make([]byte, data)
+		for j := range c.cauchy[i] {
+			c.cauchy[i][j] = gf256.Div(1, byte(data+i)^byte(j))
+		}
+	}
+	return c, nil
+}
+
+// Encode computes the parity shards from the data shards. All k+m shards must be
+// allocated and have the same length.
+func (c *Code) Encode(shards [][]byte) error {
+	if err := c.check(shards); err != nil {
+		return err
+	}
+	for i := 0; i < c.Parity; i++ {
+		c.parity(shards, i)
+	}
+	return nil
+}
+
+// Reconstruct rebuilds all shards that are not present in place. All k+m shards must be
+// allocated and have the same length and at least k of them must be present.
+func (c *Code) Reconstruct(shards [][]byte, present []bool) error {
+	if err := c.check(shards); err != nil {
+		return err
+	}
+	if len(present) != len(shards) {
+		return errors.New("erasure: wrong number of flags")
+	}
+
+	// rows of the generator matrix for the first k present shards
+	rows := make([]int, 0, c.Data)
+	for i := range shards {
+		if present[i] && len(rows) < c.Data {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < c.Data {
+		return ErrTooFewShards
+	}
+	matrix := make([][]byte, c.Data)
+	for r, i := range rows {
+		if i < c.Data {
+			matrix[r] = make([]byte, c.Data)
+			matrix[r][i] = 1
+		} else {
+			matrix[r] = append([]byte(nil), c.cauchy[i-c.Data]...)
+		}
+	}
+
+	// the inverse maps the present shards back to the data shards
+	inverse, err := invert(matrix)
+	if err != nil {
+		return err
+	}
+	for j := 0; j < c.Data; j++ {
+		if present[j] {
+			continue
+		}
+		zero(shards[j])
+		for r, i := range rows {
+			gf256.MulAdd(shards[j], shards[i], inverse[j][r])
+		}
+	}
+	for i := 0; i < c.Parity; i++ {
+		if !present[c.Data+i] {
+			c.parity(shards, i)
+		}
+	}
+	return nil
+}
+
+// check the number and lengths of shards
+func (c *Code) check(shards [][]byte) error {
+	if len(shards) != c.Data+c.Parity {
+		return errors.New("erasure: wrong number of shards")
+	}
+	for _, s := range shards {
+		if len(s) != len(shards[0]) {
+			return errors.New("erasure: shards must have the same length")
+		}
+	}
+	return nil
+}
+
+// parity computes parity shard i from the data shards
+func (c *Code) parity(shards [][]byte, i int) {
+	p := shards[c.Data+i]
+	zero(p)
+	for j, coeff := range c.cauchy[i] {
+		gf256.MulAdd(p, shards[j], coeff)
+	}
+}
+
+// invert a square matrix with Gauss-Jordan elimination
+func invert(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+	inverse := make([][]byte, n)
+	for i := range inverse {
+		inverse[i] = make([]byte, n)
+		inverse[i][i] = 1
+	}
+	for col := 0; col < n; col++ {
+		// find a pivot and swap it into place
+		pivot := col
+		for pivot < n && matrix[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("erasure: singular matrix")
+		}
+		matrix[col], matrix[pivot] = matrix[pivot], matrix[col]
+		inverse[col], inverse[pivot] = inverse[pivot], inverse[col]
+
+		// scale the pivot row to one
+		scale := gf256.Div(1, matrix[col][col])
+		for j := 0; j < n; j++ {
+			matrix[col][j] = gf256.Mul(matrix[col][j], scale)
+			inverse[col][j] = gf256.Mul(inverse[col][j], scale)
+		}
+
+		// eliminate the column in all other rows
+		for row := 0; row < n; row++ {
+			if f := matrix[row][col]; row != col && f != 0 {
+				gf256.MulAdd(matrix[row], matrix[col], f)
+				gf256.MulAdd(inverse[row], inverse[col], f)
+			}
+		}
+	}
+	return inverse, nil
+}
+
+// zero all bytes
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package erasure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestReconstruct(t *testing.T) {
+
+	code, err := New(5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := make([][]byte, 8)
+	for i := range shards {
+		shards[i] = make([]byte, 100)
+		if i < 5 {
+			rand.Read(shards[i])
+		}
+	}
+	if err = code.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+	original := make([][]byte, len(shards))
+	for i := range shards {
+		original[i] = append([]byte(nil), shards[i]...)
+	}
+
+	// every combination of missing shards, up to three can be rebuilt
+	for mask := 0; mask < 1<<8; mask++ {
+		present, missing := make([]bool, 8), 0
+		for i := range present {
+			copy(shards[i], original[i])
+			if present[i] = mask&(1<<uint(i)) == 0; !present[i] {
+				missing++
+				rand.Read(shards[i])
+			}
+		}
+		err = code.Reconstruct(shards, present)
+		if missing > 3 {
+			if err != ErrTooFewShards {
+				t.Errorf("%08b: expected too few shards, got %v", mask, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%08b: %v", mask, err)
+		}
+		for i := range shards {
+			if !bytes.Equal(shards[i], original[i]) {
+				t.Fatalf("%08b: shard %d differs", mask, i)
+			}
+		}
+	}
+
+}
+
+func TestNew(t *testing.T) {
+	for _, km := range [][2]int{{0, 1}, {1, 0}, {200, 57}} {
+		if _, err := New(km[0], km[1]); err == nil {
+			t.Errorf("%d+%d shards accepted", km[0], km[1])
+		}
+	}
+	if _, err := New(128, 128); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package gateway serves the decrypted content of encrypted files over HTTP. A request
+// for /path/name is answered with the plaintext of the file path/name.ae below the root
+// directory. Range requests only decrypt the chunks they overlap, since all chunks of a
+// file have the same size. Framed files from seal --flush cannot be served.
+package gateway
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ansemjo/aenker/ae"
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// Handler is an http.Handler, which decrypts files below a root directory.
+type Handler struct {
+	root    string
+	suffix  string
+	private *securebuf.Buffer
+	kemseed *securebuf.Buffer // nil unless hybrid files are opened
+
+	// ErrorLog logs files that cannot be opened, the standard logger is used if nil.
+	ErrorLog *log.Logger
+}
+
+// NewHandler returns a handler for the files with the given suffix, usually ".ae",
+// below root. The kemseed may be nil, otherwise files for hybrid keys are opened.
+func NewHandler(root, suffix string, private *[32]byte, kemseed []byte) *Handler {
+	h := &Handler{
+		root:    root,
+		suffix:  suffix,
+		private: securebuf.Copy(append([]byte(nil), private[:]...)),
+	}
+	if kemseed != nil {
+		h.kemseed = securebuf.Copy(append([]byte(nil), kemseed...))
+	}
+	return h
+}
+
+// ServeHTTP serves GET and HEAD requests with support for Range, If-Modified-Since
+// and the other conditional headers of http.ServeContent.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// map the request path to a file below root
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(filepath.Join(h.root, filepath.FromSlash(name)+h.suffix))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// open for random access, which authenticates the final chunk
+	var ra interface {
+		io.ReaderAt
+		Size() int64
+		Destroy()
+	}
+	if h.kemseed != nil {
+		ra, err = ae.NewHybridReaderAt(file, stat.Size(), h.private.Key(), h.kemseed.Bytes())
+	} else {
+		ra, err = ae.NewReaderAt(file, stat.Size(), h.private.Key())
+	}
+	if err == ae.ErrFramed {
+		h.logf("gateway: %s: %s", name, err)
+		http.Error(w, "framed files cannot be served", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		h.logf("gateway: %s: %s", name, err)
+		http.Error(w, "file cannot be decrypted", http.StatusForbidden)
+		return
+	}
+	defer ra.Destroy()
+
+	// content type is detected from the name without suffix or sniffed
+	http.ServeContent(w, r, path.Base(name), stat.ModTime(), io.NewSectionReader(ra, 0, ra.Size()))
+
+}
+
+// Destroy wipes the private key and the kemseed. The handler must not be used afterwards.
+func (h *Handler) Destroy() {
+	h.private.Destroy()
+	h.kemseed.Destroy()
+}
+
+func (h *Handler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package gateway
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ansemjo/aenker/ae"
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+func TestHandler(t *testing.T) {
+
+	private := new([32]byte)
+	rand.Read(private[:])
+	plain := make([]byte, 10000)
+	rand.Read(plain)
+
+	// encrypt a file below root
+	root := t.TempDir()
+	var buf bytes.Buffer
+	w, _ := ae.NewWriter(&buf, keyderivation.Public(private))
+	w.Write(plain)
+	w.Close()
+	if err := os.WriteFile(filepath.Join(root, "data.bin.ae"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHandler(root, ".ae", private, nil)
+	h.ErrorLog = log.New(io.Discard, "", 0)
+	defer h.Destroy()
+	get := func(path string, header ...string) *http.Response {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	// complete file
+	res := get("/data.bin")
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || !bytes.Equal(body, plain) {
+		t.Errorf("GET: status %d, %d bytes", res.StatusCode, len(body))
+	}
+
+	// range across chunk boundaries
+	res = get("/data.bin", "Range", "bytes=1900-4099")
+	body, _ = io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusPartialContent || !bytes.Equal(body, plain[1900:4100]) {
+		t.Errorf("Range: status %d, %d bytes", res.StatusCode, len(body))
+	}
+
+	// not modified
+	res = get("/data.bin", "If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: status %d", res.StatusCode)
+	}
+
+	// missing files and paths outside of root
+	for _, path := range []string{"/missing", "/../data.bin.ae", "/data.bin.ae"} {
+		if res = get(path); res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d", path, res.StatusCode)
+		}
+	}
+
+	// framed files have no random access
+	buf.Reset()
+	ae.Framed = true
+	w, _ = ae.NewWriter(&buf, keyderivation.Public(private))
+	ae.Framed = false
+	w.Write(plain)
+	w.Close()
+	if err := os.WriteFile(filepath.Join(root, "framed.bin.ae"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if res = get("/framed.bin"); res.StatusCode != http.StatusNotImplemented {
+		t.Errorf("framed file: status %d", res.StatusCode)
+	}
+
+	// hybrid files with a kemseed
+	kemseed := make([]byte, keyderivation.KEMSeedSize)
+	rand.Read(kemseed)
+	kempub, err := keyderivation.KEMPublic(kemseed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	w, _ = ae.NewHybridWriter(&buf, keyderivation.Public(private), kempub)
+	w.Write(plain)
+	w.Close()
+	if err := os.WriteFile(filepath.Join(root, "hybrid.bin.ae"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h = NewHandler(root, ".ae", private, kemseed)
+	h.ErrorLog = log.New(io.Discard, "", 0)
+	defer h.Destroy()
+	for _, path := range []string{"/hybrid.bin", "/data.bin"} {
+		res = get(path)
+		body, _ = io.ReadAll(res.Body)
+		if res.StatusCode != http.StatusOK || !bytes.Equal(body, plain) {
+			t.Errorf("%s with kemseed: status %d, %d bytes", path, res.StatusCode, len(body))
+		}
+	}
+
+	// wrong key
+	other := new([32]byte)
+	rand.Read(other[:])
+	h = NewHandler(root, ".ae", other, nil)
+	h.ErrorLog = log.New(io.Discard, "", 0)
+	if res = get("/data.bin"); res.StatusCode != http.StatusForbidden {
+		t.Errorf("wrong key: status %d", res.StatusCode)
+	}
+
+}
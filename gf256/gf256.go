@@ -0,0 +1,64 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package gf256 implements arithmetic in GF(256) with the reducing polynomial
+// x^8 + x^4 + x^3 + x + 1 (0x11b), which is also used by AES. Addition and subtraction
+// are both XOR. Multiplication uses logarithm tables with the generator 3, which are
+// computed in init. It is shared by the shamir and erasure packages.
+package gf256
+
+var (
+	exp [510]byte
+	log [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		// multiply by the generator x+1
+		x ^= xtime(x)
+	}
+}
+
+// multiply by x and reduce
+func xtime(a byte) byte {
+	if a&0x80 != 0 {
+		return a<<1 ^ 0x1b
+	}
+	return a << 1
+}
+
+// Mul multiplies two field elements.
+func Mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return exp[int(log[a])+int(log[b])]
+}
+
+// Div divides a by a non-zero b.
+func Div(a, b byte) byte {
+	if b == 0 {
+		panic("gf256: division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return exp[int(log[a])+255-int(log[b])]
+}
+
+// MulAdd adds c times every byte of src to dst, which must be at least as long.
+func MulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	var table [256]byte
+	for i := range table {
+		table[i] = Mul(c, byte(i))
+	}
+	for i, s := range src {
+		dst[i] ^= table[s]
+	}
+}
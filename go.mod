@@ -1,12 +1,17 @@
 module github.com/ansemjo/aenker
 
+go 1.24
+
+require (
+	github.com/spf13/cobra v0.0.3
+	github.com/spf13/pflag v1.0.2
+	golang.org/x/crypto v0.0.0-20181001203147-e3636079e1a4
+	gopkg.in/yaml.v2 v2.2.1
+)
+
 require (
 	github.com/cpuguy83/go-md2man v1.0.8 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/russross/blackfriday v1.5.1 // indirect
-	github.com/spf13/cobra v0.0.3
-	github.com/spf13/pflag v1.0.2 // indirect
-	golang.org/x/crypto v0.0.0-20181001203147-e3636079e1a4
 	golang.org/x/sys v0.0.0-20180928133829-e4b3c5e90611 // indirect
-	gopkg.in/yaml.v2 v2.2.1 // indirect
 )
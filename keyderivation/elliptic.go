@@ -4,6 +4,7 @@
 package keyderivation
 
 import (
+	"github.com/ansemjo/aenker/securebuf"
 	"golang.org/x/crypto/curve25519"
 )
 
@@ -16,7 +17,8 @@ func Elliptic(private, peer *[32]byte, salt []byte, info string) (key []byte) {
 	shared := new([32]byte)
 	curve25519.ScalarMult(shared, private, peer)
 
-	// derive key with hkdf
+	// derive key with hkdf and wipe the shared secret
+	defer securebuf.Wipe(shared[:])
 	return HKDF(shared[:], salt, info)
 
 }
@@ -0,0 +1,64 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package keyderivation
+
+import (
+	"crypto/mlkem"
+
+	"github.com/ansemjo/aenker/securebuf"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Sizes of the ML-KEM-768 parts of a hybrid key.
+const (
+	KEMSeedSize       = mlkem.SeedSize
+	KEMPublicSize     = mlkem.EncapsulationKeySize768
+	KEMCiphertextSize = mlkem.CiphertextSize768
+)
+
+// KEMPublic returns the ML-KEM-768 encapsulation key for a 64 byte decapsulation key seed.
+func KEMPublic(seed []byte) (pub []byte, err error) {
+	dk, err := mlkem.NewDecapsulationKey768(seed)
+	if err != nil {
+		return
+	}
+	return dk.EncapsulationKey().Bytes(), nil
+}
+
+// Encapsulate generates a shared secret and the ciphertext which encapsulates it for the
+// given ML-KEM-768 encapsulation key.
+func Encapsulate(pub []byte) (shared, ciphertext []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(pub)
+	if err != nil {
+		return
+	}
+	shared, ciphertext = ek.Encapsulate()
+	return
+}
+
+// Decapsulate recovers the shared secret from a ciphertext with the ML-KEM-768 seed.
+func Decapsulate(seed, ciphertext []byte) (shared []byte, err error) {
+	dk, err := mlkem.NewDecapsulationKey768(seed)
+	if err != nil {
+		return
+	}
+	return dk.Decapsulate(ciphertext)
+}
+
+// Hybrid performs anonymous Diffie-Hellman like Elliptic and derives a 32 byte key with
+// HKDF from the concatenation of its result and a post-quantum KEM shared secret. The
+// derived key is secure as long as either of the two remains unbroken.
+func Hybrid(private, peer *[32]byte, kemshared, salt []byte, info string) (key []byte) {
+
+	// perform anonymous diffie-hellman
+	shared := new([32]byte)
+	curve25519.ScalarMult(shared, private, peer)
+
+	// derive key with hkdf from both secrets and wipe them
+	secret := append(shared[:], kemshared...)
+	defer securebuf.Wipe(secret)
+	securebuf.Wipe(shared[:])
+	return HKDF(secret, salt, info)
+
+}
@@ -6,14 +6,62 @@
 package keyderivation
 
 import (
+	"fmt"
+	"time"
+
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/blake2b"
 )
 
-// Password derives a 32 byte key from a password and salt with Argon2i and
-// the predefined cost settings time=32, memory=256MB, threads=4.
-// Keys generated this way are compatible with https://github.com/ansemjo/stdkdf.
-func Password(password []byte, salt string) (key []byte) {
+// Argon2Params are the cost settings for password-based key derivation.
+type Argon2Params struct {
+	Argon2i bool   // use the legacy Argon2i variant instead of Argon2id
+	Time    uint32 // number of passes over the memory
+	Memory  uint32 // memory size in KiB
+	Threads uint8  // degree of parallelism
+}
+
+// DefaultArgon2 are the default cost settings of Argon2id with time=32, memory=256MB,
+// threads=4, which are the same costs as in LegacyArgon2.
+var DefaultArgon2 = Argon2Params{Time: 32, Memory: 256 * 1024, Threads: 4}
+
+// LegacyArgon2 are the fixed Argon2i settings of keyfiles from earlier versions, which
+// did not record them. Keys derived with them are compatible with
+// https://github.com/ansemjo/stdkdf.
+var LegacyArgon2 = Argon2Params{Argon2i: true, Time: 32, Memory: 256 * 1024, Threads: 4}
+
+// String returns the algorithm and its parameters in a human-readable form.
+func (p Argon2Params) String() string {
+	return fmt.Sprintf("%s time=%d memory=%d threads=%d", p.Algorithm(), p.Time, p.Memory/1024, p.Threads)
+}
+
+// Algorithm returns the name of the Argon2 variant.
+func (p Argon2Params) Algorithm() string {
+	if p.Argon2i {
+		return "argon2i"
+	}
+	return "argon2id"
+}
+
+// Password derives a 32 byte key from a password and salt with Argon2id and the given
+// cost settings. The salt is hashed with Blake2b first, so it can have any length.
+func Password(password []byte, salt string, p Argon2Params) (key []byte) {
 	s := blake2b.Sum256([]byte(salt))
-	return argon2.Key(password, s[:], 32, 256*1024, 4, 32)
+	if p.Argon2i {
+		return argon2.Key(password, s[:], p.Time, p.Memory, p.Threads, 32)
+	}
+	return argon2.IDKey(password, s[:], p.Time, p.Memory, p.Threads, 32)
+}
+
+// Calibrate returns the parameters with the given memory size and threads and a time
+// cost, which takes approximately the target duration on the current machine. It is
+// estimated from the duration of a single pass and is at least one.
+func Calibrate(target time.Duration, p Argon2Params) Argon2Params {
+	p.Time = 1
+	start := time.Now()
+	Password([]byte("calibration"), "aenker", p)
+	if pass := time.Since(start); pass < target {
+		p.Time = uint32((target + pass/2) / pass)
+	}
+	return p
 }
@@ -0,0 +1,37 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build pbkdf
+
+package keyderivation
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+
+	// small costs, the salt is hashed with blake2b first
+	for _, tc := range []struct {
+		argon2i bool
+		key     string
+	}{
+		{true, "27a6347a972c98e1217dbfa9d069fcf3807ea05d9bf9e13b12a040094608e852"},
+		{false, "ba3b2e746b55b901809167741db417dfacefc8dbc54a6b1a9fe868ea8c14c689"},
+	} {
+		p := Argon2Params{Argon2i: tc.argon2i, Time: 2, Memory: 1024, Threads: 2}
+		if key := hex.EncodeToString(Password([]byte("password"), "aenker", p)); key != tc.key {
+			t.Errorf("%s: wrong key %s", p, key)
+		}
+	}
+
+	// the legacy settings must not change, earlier keyfiles depend on them
+	if LegacyArgon2 != (Argon2Params{Argon2i: true, Time: 32, Memory: 256 * 1024, Threads: 4}) {
+		t.Errorf("legacy settings changed: %s", LegacyArgon2)
+	}
+	if DefaultArgon2.Argon2i || DefaultArgon2.Time < LegacyArgon2.Time || DefaultArgon2.Memory < LegacyArgon2.Memory {
+		t.Errorf("default settings are weaker than the legacy settings: %s", DefaultArgon2)
+	}
+
+}
@@ -0,0 +1,17 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package keyderivation
+
+import "github.com/ansemjo/aenker/securebuf"
+
+// Subkeyinfo is the prefix of the HKDF context info for subkey derivation.
+const Subkeyinfo = "aenker subkey "
+
+// Subkey derives a child private key from a master private key and a label like
+// "project/x" or "2026-Q4" with HKDF. The same master and label always yield the same
+// child, so keys can be rotated or scoped without distributing a new master key.
+// Children of different labels are independent and do not reveal the master key.
+func Subkey(master *[32]byte, label string) (child *[32]byte) {
+	return securebuf.Copy(HKDF(master[:], nil, Subkeyinfo+label)).Key()
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package keyderivation
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestSubkey(t *testing.T) {
+
+	master := new([32]byte)
+	for i := range master {
+		master[i] = byte(i)
+	}
+
+	// HKDF-BLAKE2b-512 with an empty salt and the info "aenker subkey <label>",
+	// derived keys in existing keyfiles depend on these
+	for _, tc := range []struct {
+		label, child string
+	}{
+		{"project/x", "5b578af358a133bf7dab99345e451a9096a2ecbb4aee42691cc1cab445f0242f"},
+		{"2026-Q4", "75074113dc140a48d11cef39ab19fcf2e073ede49a6d7778917c5ad1ec8b4fc5"},
+	} {
+		if child := hex.EncodeToString(Subkey(master, tc.label)[:]); child != tc.child {
+			t.Errorf("%s: wrong subkey %s", tc.label, child)
+		}
+	}
+
+	if *Subkey(master, "a") == *Subkey(master, "b") {
+		t.Error("different labels yield the same subkey")
+	}
+
+}
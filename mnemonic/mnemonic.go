@@ -0,0 +1,108 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package mnemonic encodes secrets as a checksummed list of words like BIP 39 [0], which is
+// much easier to write down on paper and transcribe correctly than base64. The English
+// wordlist from the BIP is embedded and used for all encodings.
+//
+// A 32 byte key is encoded in 24 words: 256 bits of entropy and an 8 bit checksum from its
+// SHA-256 hash are split into groups of 11 bits, which are indices into the wordlist. Only
+// the entropy encoding of the BIP is implemented, the seed derivation with PBKDF2 is not.
+//
+//  [0]: https://github.com/bitcoin/bips/blob/master/bip-0039.mediawiki
+package mnemonic
+
+import (
+	"crypto/sha256"
+	_ "embed" // for the wordlist
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//go:embed english.txt
+var english string
+
+// Wordlist is the list of 2048 English words used for encoding.
+var Wordlist = strings.Fields(english)
+
+// index of every word and its unique four letter prefix
+var index = func() map[string]int {
+	m := make(map[string]int, 2*len(Wordlist))
+	for i, w := range Wordlist {
+		m[w] = i
+		if len(w) > 4 {
+			m[w[:4]] = i
+		}
+	}
+	return m
+}()
+
+// Encode converts entropy to words. Its length must be a multiple of four
+// bytes between 16 and 32 bytes, which results in 12 to 24 words.
+func Encode(entropy []byte) (words []string, err error) {
+
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return nil, errors.New("mnemonic: entropy must be 16 to 32 bytes in steps of four")
+	}
+
+	// append the checksum, which is the first len/4 bits of the hash
+	sum := sha256.Sum256(entropy)
+	data := append(append([]byte{}, entropy...), sum[0])
+	bits := len(entropy)*8 + len(entropy)/4
+
+	words = make([]string, bits/11)
+	for i := range words {
+		words[i] = Wordlist[readBits(data, i*11, 11)]
+	}
+	return
+
+}
+
+// Decode converts words back to entropy and verifies the checksum. Words are
+// case-insensitive and may be abbreviated to their unique first four letters.
+func Decode(words []string) (entropy []byte, err error) {
+
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, errors.New("mnemonic: must be 12 to 24 words in steps of three")
+	}
+
+	// concatenate the 11 bit indices
+	data := make([]byte, (len(words)*11+7)/8)
+	for i, w := range words {
+		idx, ok := index[strings.ToLower(w)]
+		if !ok {
+			return nil, fmt.Errorf("mnemonic: unknown word %q at position %d", w, i+1)
+		}
+		writeBits(data, i*11, 11, idx)
+	}
+
+	// split entropy and checksum
+	bits := len(words) * 11
+	checkbits := bits / 33
+	entropy = data[:(bits-checkbits)/8]
+
+	sum := sha256.Sum256(entropy)
+	if readBits(data, bits-checkbits, checkbits) != int(sum[0]>>uint(8-checkbits)) {
+		return nil, errors.New("mnemonic: checksum mismatch")
+	}
+	return entropy, nil
+
+}
+
+// readBits reads n bits at the given bit offset as a big-endian integer
+func readBits(data []byte, offset, n int) (v int) {
+	for i := offset; i < offset+n; i++ {
+		v = v<<1 | int(data[i/8]>>uint(7-i%8)&1)
+	}
+	return
+}
+
+// writeBits writes the lowest n bits of v at the given bit offset
+func writeBits(data []byte, offset, n, v int) {
+	for i := 0; i < n; i++ {
+		if v>>uint(n-1-i)&1 == 1 {
+			data[(offset+i)/8] |= 1 << uint(7-(offset+i)%8)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package mnemonic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestVectors(t *testing.T) {
+
+	// test vectors from BIP 39
+	vectors := []struct{ entropy, words string }{
+		{"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank yellow"},
+		{"0000000000000000000000000000000000000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art"},
+		{"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote"},
+	}
+
+	for i, v := range vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		words, err := Encode(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(words, " ") != v.words {
+			t.Errorf("vector[%d] wrong encoding: %s", i, strings.Join(words, " "))
+		}
+		decoded, err := Decode(strings.Fields(v.words))
+		if err != nil || !bytes.Equal(decoded, entropy) {
+			t.Errorf("vector[%d] wrong decoding: %x, %v", i, decoded, err)
+		}
+	}
+
+}
+
+func TestDecode(t *testing.T) {
+
+	// abbreviations and case
+	words := strings.Fields("ZOO zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote")
+	if _, err := Decode(words); err != nil {
+		t.Error(err)
+	}
+
+	// swapped words fail the checksum
+	words[0], words[23] = words[23], words[0]
+	if _, err := Decode(words); err == nil {
+		t.Error("swapped words were not detected")
+	}
+
+	if _, err := Decode([]string{"abandon", "xylophone"}); err == nil {
+		t.Error("wrong number of words was accepted")
+	}
+
+}
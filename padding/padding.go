@@ -101,6 +101,11 @@ func Remove(chunk *[]byte) (final bool) {
 		return
 	}
 
+	// an unpadded final chunk may be empty
+	if length == 0 {
+		return
+	}
+
 	// mask during pad checking, padding ? 1 : 0
 	// when check is set to zero (from the beginning or during the
 	// for loop) that means that no future bytes will increment the
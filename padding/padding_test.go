@@ -85,6 +85,7 @@ func TestRemove(t *testing.T) {
 		{"unknown", "unknown" + string(Running), false},
 		{"unknow", "unknownnnnn" + string(Padded), true},
 		{"unknown", "unknown" + string(Unpadded), true},
+		{"", string(Unpadded), true},
 	}
 
 	for i, tc := range table {
@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package sealedbox implements anonymous public-key encryption compatible with libsodium's
+// crypto_box_seal [0]. A sealed box is a single message which is not chunked, so the entire
+// plaintext must fit into memory. This is intended for small secrets and aenker's
+// Curve25519 keys can be used with it directly.
+//
+// The message is encrypted with crypto_box (XSalsa20Poly1305) using a random ephemeral key
+// and a nonce, which is the Blake2b hash of the ephemeral and recipient's public key:
+//  ephemeral_pk || box(m, blake2b(ephemeral_pk || recipient_pk), recipient_pk, ephemeral_sk)
+//
+//  [0]: https://doc.libsodium.org/public-key_cryptography/sealed_boxes
+package sealedbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/ansemjo/aenker/keyderivation"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Overhead is the number of bytes a sealed box is longer than its plaintext.
+const Overhead = 32 + box.Overhead
+
+// nonce computes the deterministic nonce from both public keys
+func nonce(ephemeral, peer *[32]byte) (n *[24]byte) {
+	h, err := blake2b.New(24, nil)
+	if err != nil {
+		// valid size and no key, this shouldn't happen
+		panic(err)
+	}
+	h.Write(ephemeral[:])
+	h.Write(peer[:])
+	n = new([24]byte)
+	copy(n[:], h.Sum(nil))
+	return
+}
+
+// Seal encrypts a message anonymously for the given Curve25519 public key. The
+// result is Overhead bytes longer than the message.
+func Seal(message []byte, peer *[32]byte) (sealed []byte, err error) {
+
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+
+	sealed = make([]byte, 32, len(message)+Overhead)
+	copy(sealed, public[:])
+	return box.Seal(sealed, message, nonce(public, peer), peer, private), nil
+
+}
+
+// Open decrypts and authenticates a sealed box with your Curve25519 private key.
+func Open(sealed []byte, private *[32]byte) (message []byte, err error) {
+
+	if len(sealed) < Overhead {
+		return nil, errors.New("sealedbox: message too short")
+	}
+
+	ephemeral := new([32]byte)
+	copy(ephemeral[:], sealed[:32])
+
+	public := keyderivation.Public(private)
+	message, ok := box.Open(nil, sealed[32:], nonce(ephemeral, public), ephemeral, private)
+	if !ok {
+		return nil, errors.New("sealedbox: message authentication failed")
+	}
+	return
+
+}
+
+// boxWriter buffers all writes and seals them upon Close.
+type boxWriter struct {
+	bytes.Buffer
+	writer io.Writer
+	peer   *[32]byte
+}
+
+func (bw *boxWriter) Close() (err error) {
+	sealed, err := Seal(bw.Bytes(), bw.peer)
+	if err != nil {
+		return
+	}
+	_, err = bw.writer.Write(sealed)
+	return
+}
+
+// NewWriter returns a WriteCloser, which buffers all written data in memory and writes
+// a single sealed box for the given public key to the provided Writer upon .Close().
+// The signature matches ae.NewWriter so the two can be used interchangeably.
+func NewWriter(w io.Writer, peer *[32]byte) (io.WriteCloser, error) {
+	return &boxWriter{writer: w, peer: peer}, nil
+}
+
+// NewReader reads the provided Reader until EOF and opens the sealed box with your
+// private key. If that succeeds, a Reader over the plaintext is returned. The signature
+// matches ae.NewReader so the two can be used interchangeably.
+func NewReader(r io.Reader, private *[32]byte) (io.Reader, error) {
+
+	sealed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := Open(sealed, private)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(message), nil
+
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package sealedbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+func TestSealOpen(t *testing.T) {
+
+	private := new([32]byte)
+	rand.Read(private[:])
+	public := keyderivation.Public(private)
+
+	message := []byte("Hello, World!")
+	sealed, err := Seal(message, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != len(message)+Overhead {
+		t.Errorf("unexpected length: %d", len(sealed))
+	}
+
+	opened, err := Open(sealed, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(message, opened) {
+		t.Errorf("opened message differs: %q", opened)
+	}
+
+	// any bitflip must fail authentication
+	for _, i := range []int{0, 31, 32, len(sealed) - 1} {
+		sealed[i] ^= 0x01
+		if _, err := Open(sealed, private); err == nil {
+			t.Errorf("bitflip at %d not detected", i)
+		}
+		sealed[i] ^= 0x01
+	}
+
+	if _, err := Open(sealed[:Overhead-1], private); err == nil {
+		t.Errorf("short message not rejected")
+	}
+
+}
+
+func TestKnownAnswer(t *testing.T) {
+
+	// keypair and boxes generated with crypto_box_keypair and crypto_box_seal of libsodium 1.0.18
+	private, public := new([32]byte), new([32]byte)
+	hex.Decode(private[:], []byte("8f4db1d4d2ce007f9497c5b37f73ab603794bbbcda928b32a7fa92b531ce7afa"))
+	hex.Decode(public[:], []byte("c0218e726284213be77c1756487bc9d2fceb61b859231548b1612286ed10ca2c"))
+	if *keyderivation.Public(private) != *public {
+		t.Fatal("public key differs")
+	}
+
+	for message, box := range map[string]string{
+		"": "ea2b81515e6a291598d4118f3e944f7a1bf4e19ccc1c920d3bc98664f42df74acb723ecd2dc5d418cafef32cd4fa5430",
+		"aenker known answer test\n": "c7d14776822791a082145a1d6966dfdd69e67fc6c8ab0e525575fbe456ab650c" +
+			"45555f0e2fc3c29709d776324187071cf7c01007ae65c181f5fdf5fd19bd4c06505e7e818f38c04819",
+	} {
+		sealed, _ := hex.DecodeString(box)
+		opened, err := Open(sealed, private)
+		if err != nil {
+			t.Errorf("%q: %s", message, err)
+		} else if string(opened) != message {
+			t.Errorf("%q: opened %q", message, opened)
+		}
+	}
+
+}
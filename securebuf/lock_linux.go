@@ -0,0 +1,44 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build linux
+
+package securebuf
+
+import (
+	"os"
+	"syscall"
+)
+
+// from linux/prctl.h
+const prSetDumpable = 4
+
+// alloc maps whole anonymous pages and locks them, falling back to the heap
+func alloc(size int) (mem []byte, mmap bool) {
+	page := os.Getpagesize()
+	length := (size/page + 1) * page
+	mem, err := syscall.Mmap(-1, 0, length, syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return make([]byte, size), false
+	}
+	_ = syscall.Mlock(mem)
+	return mem, true
+}
+
+// free unlocks and unmaps pages from alloc
+func free(mem []byte) {
+	_ = syscall.Munlock(mem)
+	_ = syscall.Munmap(mem)
+}
+
+func disableCoreDumps() error {
+	if err := syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{}); err != nil {
+		return err
+	}
+	// also prevents ptrace attachment by other processes of the same user
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetDumpable, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// +build !linux
+
+package securebuf
+
+func alloc(size int) (mem []byte, mmap bool) { return make([]byte, size), false }
+
+func free(mem []byte) {}
+
+func disableCoreDumps() error { return nil }
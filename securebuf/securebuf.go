@@ -0,0 +1,69 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package securebuf provides buffers for key material, which are locked in memory
+// where supported, so they are not swapped to disk, and wiped when destroyed.
+//
+// Go's garbage collector may still copy or retain data in other places, so this is
+// a best-effort measure to reduce the lifetime of secrets in memory.
+package securebuf
+
+// Buffer is a fixed-size byte slice that is locked in memory. On Linux, it is
+// allocated on its own pages outside of the Go heap, so the garbage collector
+// never copies it.
+type Buffer struct {
+	data []byte
+	mem  []byte // the whole allocation
+	mmap bool   // mem was mapped by alloc
+}
+
+// New allocates a new zeroed buffer of the given size and tries to lock it in
+// memory. Failing to lock the memory, e.g. because of a low RLIMIT_MEMLOCK, is
+// not an error.
+func New(size int) *Buffer {
+	mem, mmap := alloc(size)
+	return &Buffer{data: mem[:size:size], mem: mem, mmap: mmap}
+}
+
+// Copy allocates a new buffer with a copy of data and wipes the original.
+func Copy(data []byte) *Buffer {
+	b := New(len(data))
+	copy(b.data, data)
+	Wipe(data)
+	return b
+}
+
+// Bytes returns the underlying slice, which is nil after Destroy.
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Key returns the first 32 bytes of the buffer as a key.
+func (b *Buffer) Key() *[32]byte {
+	return (*[32]byte)(b.data[:32])
+}
+
+// Destroy wipes and unlocks the buffer. It must not be used afterwards.
+func (b *Buffer) Destroy() {
+	if b == nil || b.data == nil {
+		return
+	}
+	Wipe(b.mem)
+	if b.mmap {
+		free(b.mem)
+	}
+	b.data, b.mem = nil, nil
+}
+
+// Wipe overwrites a slice with zeroes.
+func Wipe(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
+
+// DisableCoreDumps prevents the current process from writing core dumps, which
+// might contain secrets from memory. It is a no-op on unsupported platforms.
+func DisableCoreDumps() error {
+	return disableCoreDumps()
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package securebuf
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestBuffer(t *testing.T) {
+
+	// new buffers are zeroed, also across pages
+	for _, size := range []int{1, 32, os.Getpagesize(), 3*os.Getpagesize() + 5} {
+		b := New(size)
+		if len(b.Bytes()) != size || cap(b.Bytes()) != size || !bytes.Equal(b.Bytes(), make([]byte, size)) {
+			t.Errorf("New(%d): wrong buffer", size)
+		}
+		if runtime.GOOS == "linux" && !b.mmap {
+			t.Errorf("New(%d): not mapped outside of the heap", size)
+		}
+		b.Destroy()
+	}
+
+	// copies wipe the original and the key aliases the buffer
+	data := []byte("0123456789abcdef0123456789abcdef!")
+	b := Copy(data)
+	if !bytes.Equal(data, make([]byte, len(data))) {
+		t.Error("original was not wiped")
+	}
+	if string(b.Bytes()) != "0123456789abcdef0123456789abcdef!" {
+		t.Errorf("wrong copy %q", b.Bytes())
+	}
+	key := b.Key()
+	key[0] = 'x'
+	if b.Bytes()[0] != 'x' {
+		t.Error("key does not alias the buffer")
+	}
+
+	// destroyed buffers are empty and can be destroyed again
+	b.Destroy()
+	if b.Bytes() != nil || b.mem != nil {
+		t.Error("buffer was not released")
+	}
+	b.Destroy()
+	var none *Buffer
+	none.Destroy()
+
+}
+
+func TestWipe(t *testing.T) {
+	data := []byte("secret")
+	Wipe(data)
+	if !bytes.Equal(data, make([]byte, 6)) {
+		t.Errorf("not wiped: %q", data)
+	}
+	Wipe(nil)
+}
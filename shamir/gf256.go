@@ -0,0 +1,20 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package shamir
+
+import "github.com/ansemjo/aenker/gf256"
+
+// Arithmetic in GF(256) is shared with the erasure package, see gf256.
+var (
+	mul = gf256.Mul
+	div = gf256.Div
+)
+
+// evaluate the polynomial with the given coefficients at x with Horner's method
+func evaluate(coeff []byte, x byte) (y byte) {
+	for i := len(coeff) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coeff[i]
+	}
+	return
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package shamir splits secrets into shares with Shamir's secret sharing [0] over GF(256),
+// so that any threshold number of shares can recover the secret while fewer shares reveal
+// nothing about it. Every byte of the secret is shared with its own random polynomial.
+//
+//  [0]: https://en.wikipedia.org/wiki/Shamir%27s_Secret_Sharing
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// Share is a single share of a secret. The Index is the x coordinate at which
+// all polynomials were evaluated and is never zero.
+type Share struct {
+	Index     byte
+	Threshold byte
+	Value     []byte
+}
+
+// Split splits the secret into n shares, any threshold of which can be combined to
+// recover the secret. The threshold must be at least two and n at most 255.
+func Split(secret []byte, n, threshold int) (shares []Share, err error) {
+
+	if threshold < 2 || threshold > n {
+		return nil, errors.New("shamir: threshold must be between two and the number of shares")
+	}
+	if n > 255 {
+		return nil, errors.New("shamir: at most 255 shares are possible")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("shamir: cannot split an empty secret")
+	}
+
+	shares = make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{Index: byte(i + 1), Threshold: byte(threshold), Value: make([]byte, len(secret))}
+	}
+
+	// random coefficients for every byte, the constant term is the secret itself
+	coeff := make([]byte, threshold)
+	for b, s := range secret {
+		if _, err = io.ReadFull(rand.Reader, coeff[1:]); err != nil {
+			return nil, err
+		}
+		coeff[0] = s
+		for i := range shares {
+			shares[i].Value[b] = evaluate(coeff, shares[i].Index)
+		}
+	}
+
+	return shares, nil
+
+}
+
+// Combine recovers the secret from at least threshold shares with Lagrange interpolation
+// at zero. All shares must have the same length and threshold and distinct indices.
+//
+// Wrong or manipulated shares can not be detected and yield a wrong secret, so you
+// should verify the result against some other information like a public key.
+func Combine(shares []Share) (secret []byte, err error) {
+
+	if len(shares) == 0 {
+		return nil, errors.New("shamir: no shares given")
+	}
+	threshold := int(shares[0].Threshold)
+	length := len(shares[0].Value)
+	if len(shares) < threshold {
+		return nil, errors.New("shamir: not enough shares to reach the threshold")
+	}
+
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.Index == 0 || seen[s.Index] {
+			return nil, errors.New("shamir: share indices must be unique and non-zero")
+		}
+		if int(s.Threshold) != threshold || len(s.Value) != length {
+			return nil, errors.New("shamir: shares do not belong to the same secret")
+		}
+		seen[s.Index] = true
+	}
+
+	// exactly threshold shares are needed
+	shares = shares[:threshold]
+
+	// lagrange basis polynomials evaluated at zero
+	basis := make([]byte, threshold)
+	for i, si := range shares {
+		num, den := byte(1), byte(1)
+		for j, sj := range shares {
+			if i != j {
+				num = mul(num, sj.Index)
+				den = mul(den, sj.Index^si.Index)
+			}
+		}
+		basis[i] = div(num, den)
+	}
+
+	secret = make([]byte, length)
+	for b := range secret {
+		for i, s := range shares {
+			secret[b] ^= mul(basis[i], s.Value[b])
+		}
+	}
+	return
+
+}
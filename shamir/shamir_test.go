@@ -0,0 +1,69 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestField(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if div(mul(byte(a), byte(b)), byte(b)) != byte(a) {
+				t.Fatalf("(%d * %d) / %d != %d", a, b, b, a)
+			}
+		}
+	}
+	// test vector from FIPS 197, section 4.2
+	if mul(0x57, 0x83) != 0xc1 {
+		t.Errorf("{57} * {83} != {c1}")
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// any combination of three shares works
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				s, err := Combine([]Share{shares[k], shares[i], shares[j]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(s, secret) {
+					t.Errorf("shares %d, %d, %d did not recover the secret", i, j, k)
+				}
+			}
+		}
+	}
+
+	// more than enough is fine, too few are not
+	if s, err := Combine(shares); err != nil || !bytes.Equal(s, secret) {
+		t.Errorf("all shares did not recover the secret: %v", err)
+	}
+	if _, err := Combine(shares[:2]); err == nil {
+		t.Errorf("two shares did not fail")
+	}
+	if _, err := Combine([]Share{shares[0], shares[0], shares[1]}); err == nil {
+		t.Errorf("duplicate shares did not fail")
+	}
+
+	// invalid parameters
+	for _, p := range [][2]int{{5, 1}, {3, 4}, {256, 3}} {
+		if _, err := Split(secret, p[0], p[1]); err == nil {
+			t.Errorf("split with n=%d, threshold=%d did not fail", p[0], p[1])
+		}
+	}
+
+}
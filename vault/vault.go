@@ -0,0 +1,236 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+// Package vault stores a map of named secrets in a single file, which is encrypted
+// with ae for the public key of its owner. Every change is written atomically.
+//
+// The plaintext of the file is a sequence of entries, which are sorted by name:
+//  uint16 BE length of name | name | uint32 BE length of value | value
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ansemjo/aenker/ae"
+	"github.com/ansemjo/aenker/chunkstream"
+	"github.com/ansemjo/aenker/keyderivation"
+	"github.com/ansemjo/aenker/securebuf"
+)
+
+// MaxNameLength is the maximum length of a secret's name.
+const MaxNameLength = 0xffff
+
+// Vault is an opened vault. The values are held in locked memory until Destroy.
+type Vault struct {
+	path    string
+	private *securebuf.Buffer
+	kemseed *securebuf.Buffer // nil unless the vault is encrypted for a hybrid key
+	secrets map[string]*securebuf.Buffer
+}
+
+// Open decrypts the vault at path with the owner's private key. The kemseed may be nil,
+// otherwise the vault is encrypted for a hybrid key. A missing file is an empty vault,
+// which is created on the first Save.
+func Open(path string, private *[32]byte, kemseed []byte) (v *Vault, err error) {
+
+	v = &Vault{
+		path:    path,
+		private: securebuf.Copy(append([]byte(nil), private[:]...)),
+		secrets: make(map[string]*securebuf.Buffer),
+	}
+	if kemseed != nil {
+		v.kemseed = securebuf.Copy(append([]byte(nil), kemseed...))
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return v, nil
+	} else if err != nil {
+		v.Destroy()
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader
+	if kemseed != nil {
+		reader, err = ae.NewHybridReader(bufio.NewReader(file), private, kemseed)
+	} else {
+		reader, err = ae.NewReader(bufio.NewReader(file), private)
+	}
+	if err == nil {
+		defer reader.(chunkstream.Destroyer).Destroy()
+		err = v.decode(reader)
+	}
+	if err != nil {
+		v.Destroy()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return
+
+}
+
+// Get returns the value of a secret. The slice is only valid until Destroy.
+func (v *Vault) Get(name string) (value []byte, ok bool) {
+	buf, ok := v.secrets[name]
+	if !ok {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// Set stores a copy of the value under a name and wipes the original.
+func (v *Vault) Set(name string, value []byte) error {
+	if name == "" || len(name) > MaxNameLength {
+		return errors.New("invalid secret name")
+	}
+	v.secrets[name].Destroy()
+	v.secrets[name] = securebuf.Copy(value)
+	return nil
+}
+
+// Remove deletes a secret and returns false if it did not exist.
+func (v *Vault) Remove(name string) bool {
+	buf, ok := v.secrets[name]
+	buf.Destroy()
+	delete(v.secrets, name)
+	return ok
+}
+
+// List returns the sorted names of all secrets.
+func (v *Vault) List() (names []string) {
+	names = make([]string, 0, len(v.secrets))
+	for name := range v.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Save encrypts the vault for the owner's public key and atomically replaces the file.
+func (v *Vault) Save() (err error) {
+
+	public := keyderivation.Public(v.private.Key())
+	var kempub []byte
+	if v.kemseed != nil {
+		if kempub, err = keyderivation.KEMPublic(v.kemseed.Bytes()); err != nil {
+			return
+		}
+	}
+
+	// write to a temporary file in the same directory first
+	dir := filepath.Dir(v.path)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(v.path)+".tmp-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	out := bufio.NewWriter(tmp)
+	var cw io.WriteCloser
+	if kempub != nil {
+		cw, err = ae.NewHybridWriter(out, public, kempub)
+	} else {
+		cw, err = ae.NewWriter(out, public)
+	}
+	if err != nil {
+		return
+	}
+	if err = v.encode(cw); err != nil {
+		cw.(chunkstream.Destroyer).Destroy()
+		return
+	}
+	if err = cw.Close(); err != nil {
+		return
+	}
+	if err = out.Flush(); err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), v.path)
+
+}
+
+// Destroy wipes all secrets, the private key and the kemseed from memory.
+func (v *Vault) Destroy() {
+	for name, buf := range v.secrets {
+		buf.Destroy()
+		delete(v.secrets, name)
+	}
+	v.private.Destroy()
+	v.kemseed.Destroy()
+}
+
+// encode writes all entries sorted by name
+func (v *Vault) encode(w io.Writer) (err error) {
+	var length [4]byte
+	for _, name := range v.List() {
+		value := v.secrets[name].Bytes()
+		binary.BigEndian.PutUint16(length[:2], uint16(len(name)))
+		if _, err = w.Write(length[:2]); err != nil {
+			return
+		}
+		if _, err = io.WriteString(w, name); err != nil {
+			return
+		}
+		binary.BigEndian.PutUint32(length[:], uint32(len(value)))
+		if _, err = w.Write(length[:]); err != nil {
+			return
+		}
+		if _, err = w.Write(value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// decode reads all entries from the decrypted plaintext
+func (v *Vault) decode(r io.Reader) (err error) {
+
+	var plain bytes.Buffer
+	defer func() { securebuf.Wipe(plain.Bytes()[:plain.Cap()]) }()
+	if _, err = plain.ReadFrom(r); err != nil {
+		return
+	}
+
+	data := plain.Bytes()
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return errors.New("malformed vault")
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+n+4 {
+			return errors.New("malformed vault")
+		}
+		name := string(data[2 : 2+n])
+		data = data[2+n:]
+		m := int(binary.BigEndian.Uint32(data))
+		if len(data) < 4+m {
+			return errors.New("malformed vault")
+		}
+		v.secrets[name] = securebuf.Copy(data[4 : 4+m])
+		data = data[4+m:]
+	}
+	return
+
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Anton Semjonov
+// Licensed under the MIT License
+
+package vault
+
+import (
+	"crypto/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ansemjo/aenker/keyderivation"
+)
+
+func TestSaveOpen(t *testing.T) {
+
+	key := new([32]byte)
+	rand.Read(key[:])
+	path := filepath.Join(t.TempDir(), "vault.ae")
+
+	// a missing vault is empty
+	v, err := Open(path, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.List()) != 0 {
+		t.Fatal("new vault is not empty")
+	}
+	v.Set("db/password", []byte("hunter2"))
+	v.Set("token", []byte{})
+	v.Set("apikey", []byte("secret"))
+	if !v.Remove("apikey") || v.Remove("apikey") {
+		t.Error("unexpected result of Remove")
+	}
+	if err = v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	v.Destroy()
+
+	// open again
+	v, err = Open(path, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Destroy()
+	if names := v.List(); !reflect.DeepEqual(names, []string{"db/password", "token"}) {
+		t.Errorf("wrong names: %q", names)
+	}
+	if value, ok := v.Get("db/password"); !ok || string(value) != "hunter2" {
+		t.Errorf("wrong value: %q", value)
+	}
+
+	// other keys cannot open it
+	other := new([32]byte)
+	rand.Read(other[:])
+	if _, err = Open(path, other, nil); err == nil {
+		t.Error("opened vault with the wrong key")
+	}
+
+}
+
+func TestHybrid(t *testing.T) {
+
+	key := new([32]byte)
+	rand.Read(key[:])
+	kemseed := make([]byte, keyderivation.KEMSeedSize)
+	rand.Read(kemseed)
+	path := filepath.Join(t.TempDir(), "vault.ae")
+
+	// the caller may wipe its kemseed right after opening
+	seed := append([]byte(nil), kemseed...)
+	v, err := Open(path, key, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range seed {
+		seed[i] = 0
+	}
+	v.Set("pw", []byte("hybrid"))
+	if err = v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	v.Destroy()
+
+	v, err = Open(path, key, kemseed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Destroy()
+	if value, ok := v.Get("pw"); !ok || string(value) != "hybrid" {
+		t.Errorf("wrong value: %q", value)
+	}
+	if _, err = Open(path, key, nil); err == nil {
+		t.Error("opened hybrid vault without the kemseed")
+	}
+
+}